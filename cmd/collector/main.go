@@ -3,85 +3,552 @@ package main
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"zephyrus/internal/client"
 	"zephyrus/internal/collector"
+	"zephyrus/internal/flagconfig"
+	"zephyrus/internal/supervisor"
 )
 
-// RetryTimeout describes the amount of time to wait between connection retries to the server when
-// a connection error occurs.
-const RetryTimeout = 1 * time.Second
+const (
+	// exitCodeShutdown is the exit code after a signal-triggered shutdown that flushed all consumers.
+	exitCodeShutdown = 0
+	// exitCodeForcedShutdown is the exit code after a signal-triggered shutdown that could not flush
+	// all consumers within the shutdown timeout.
+	exitCodeForcedShutdown = 3
+)
+
+// envPrefix is the prefix of environment variables setting configuration options.
+const envPrefix = "ZEPHYRUS_COLLECTOR_"
+
+// configWatchInterval describes the amount of time between checks of whether the configuration
+// file has changed on disk.
+const configWatchInterval = 5 * time.Second
 
+// config is the collector configuration, including the supervisor configuration and the path to
+// the configuration file from which it was read, if any.
 type config struct {
-	ServerAddr string
-	StatsdAddr string
-	SampleRate float64
+	ConfigFile string
+	supervisor.Config
 }
 
 func main() {
-	cfg, err := parseConfig()
+	os.Exit(run())
+}
+
+// run runs the collector until it is signaled to shut down, returning the process exit code.
+func run() int {
+	cfg, err := parseConfig(os.Args[1:], flag.ExitOnError)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Printf(
-		"collector: using configuration: zephyrus=%s statsd=%s sample rate=%f",
-		cfg.ServerAddr,
+		"collector: using configuration: zephyrus=%s statsd=%s influxdb=%s graphite=%s mqtt=%s file=%s sample rate=%f alerts=%s",
+		strings.Join(cfg.Servers, ","),
 		cfg.StatsdAddr,
+		cfg.InfluxDB.URL,
+		cfg.Graphite.Addr,
+		cfg.MQTT.Broker,
+		cfg.File.Path,
 		cfg.SampleRate,
+		cfg.Alerts,
 	)
 
-	log.Printf("collector: connecting to Zephyrus gRPC server")
-	zephyrus, err := client.NewZephyrusClient(cfg.ServerAddr)
+	s, err := supervisor.NewSupervisor(&cfg.Config)
 	if err != nil {
 		panic(err)
 	}
-	defer zephyrus.Close()
 
-	log.Printf("collector: reading device metadata")
-	identifier, err := zephyrus.DeviceInfo.GetIdentifier()
-	if err != nil {
-		panic(err)
+	log.Printf("collector: starting collection")
+	for _, addr := range cfg.Servers {
+		if err := s.Start(addr); err != nil {
+			panic(err)
+		}
 	}
 
-	log.Printf("collector: connecting to statsd server")
-	consumer, err := collector.NewTemperatureStatsdConsumer(identifier, cfg.StatsdAddr)
-	if err != nil {
-		panic(err)
+	done := make(chan bool)
+	defer close(done)
+
+	var changes <-chan bool
+	if cfg.ConfigFile != "" {
+		changes = flagconfig.Watch(cfg.ConfigFile, configWatchInterval, done)
 	}
 
-	log.Printf("collector: starting collection")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
 	for {
-		if err := zephyrus.Weather.StreamTemperature(cfg.SampleRate, consumer); err != nil {
-			log.Printf(
-				"collector: temperature stream error: device=%s error=%v",
-				identifier,
-				err,
-			)
-			time.Sleep(RetryTimeout)
+		select {
+		case <-changes:
+			log.Printf("collector: configuration file changed; reloading")
+			reload(s)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				log.Printf("collector: received SIGHUP; reloading")
+				reload(s)
+				continue
+			}
+
+			log.Printf("collector: received %v; shutting down: timeout=%v", sig, s.Config().ShutdownTimeout)
+			if err := s.Shutdown(); err != nil {
+				log.Printf("collector: %v; exiting", err)
+				return exitCodeForcedShutdown
+			}
+
+			return exitCodeShutdown
 		}
 	}
 }
 
-func parseConfig() (*config, error) {
-	serverAddr := flag.String("server", "", "Address of the Zephyrus gRPC server")
-	statsdAddr := flag.String("statsd", "", "Address of the statsd server")
-	sampleRate := flag.Float64("sample-rate", 1.0, "Collection sample rate from the device")
-	flag.Parse()
+// reload rereads the configuration and applies it. If the configuration is invalid, the error is
+// logged and the current configuration remains in effect.
+func reload(s *supervisor.Supervisor) {
+	cfg, err := parseConfig(os.Args[1:], flag.ContinueOnError)
+	if err != nil {
+		log.Printf("collector: failed to reload configuration: error=%v", err)
+		return
+	}
+
+	s.Apply(&cfg.Config)
+}
+
+// parseConfig parses the configuration from command-line arguments, the configuration file, and
+// the environment. It may be called again to reload the configuration.
+func parseConfig(args []string, errorHandling flag.ErrorHandling) (*config, error) {
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+
+	configFile := fs.String(
+		flagconfig.FileFlag,
+		"",
+		"Path to a YAML, TOML, or JSON configuration file whose keys are flag names; flags take precedence over the file, and the file over ZEPHYRUS_COLLECTOR_FLAG_NAME environment variables",
+	)
+	printConfig := fs.Bool(
+		flagconfig.PrintFlag,
+		false,
+		"Print the effective configuration as YAML and exit",
+	)
+	servers := flagconfig.NewStringList(
+		fs,
+		"server",
+		"Address of a Zephyrus gRPC server, as host:port or unix:///path/to/socket; repeat or separate with commas to monitor several servers",
+	)
+	statsdAddr := fs.String("statsd", "", "Address of the statsd server; statsd is disabled if empty")
+	statsdFormat := fs.String(
+		"statsd-tag-format",
+		string(collector.TagFormatInfluxDB),
+		"Format of statsd metric tags: influxdb (name,tag=value), dogstatsd (|#tag:value), graphite (name;tag=value), or none (tag values appended to the dotted name)",
+	)
+	sampleRate := fs.Float64("sample-rate", 1.0, "Collection sample rate from the device")
+	alerts := fs.String(
+		"alerts",
+		"",
+		"Path to a JSON file of alert rules and webhooks notified when they fire or resolve",
+	)
+	useTLS := fs.Bool("tls", false, "Connect to the Zephyrus server over TLS")
+	tlsCA := fs.String(
+		"tls-ca",
+		"",
+		"Path to PEM-encoded CA certificates used to verify the server; implies -tls",
+	)
+	tlsCert := fs.String(
+		"tls-cert",
+		"",
+		"Path to a PEM-encoded client certificate for mutual TLS; implies -tls, and is reloaded on change",
+	)
+	tlsKey := fs.String("tls-key", "", "Path to the PEM-encoded private key for -tls-cert")
+	tlsServer := fs.String(
+		"tls-server-name",
+		"",
+		"Name against which to verify the server certificate, if different from the server address",
+	)
+	tokenFile := fs.String(
+		"token-file",
+		"",
+		"Path to a file containing a bearer token presented to the Zephyrus server",
+	)
+	shutdownTimeout := fs.Duration(
+		"shutdown-timeout",
+		10*time.Second,
+		"Maximum amount of time to wait for pending readings and notifications to flush on shutdown",
+	)
+	retryInitialInterval := fs.Duration(
+		"retry-initial-interval",
+		collector.DefaultRetryPolicy.InitialInterval,
+		"Amount of time to wait before retrying after a connection or stream error",
+	)
+	retryMaxInterval := fs.Duration(
+		"retry-max-interval",
+		collector.DefaultRetryPolicy.MaxInterval,
+		"Maximum amount of time to wait between retries, and the retry interval while a server's circuit is open",
+	)
+	retryMultiplier := fs.Float64(
+		"retry-multiplier",
+		collector.DefaultRetryPolicy.Multiplier,
+		"Factor by which the retry interval grows after each consecutive failure",
+	)
+	retryJitter := fs.Float64(
+		"retry-jitter",
+		collector.DefaultRetryPolicy.Jitter,
+		"Fraction of the retry interval, between 0 and 1, by which it is randomized",
+	)
+	circuitThreshold := fs.Int(
+		"circuit-failure-threshold",
+		collector.DefaultRetryPolicy.FailureThreshold,
+		"Number of consecutive failures after which a server's circuit opens, suppressing error logs and retrying at the maximum interval",
+	)
+	sinkQueueSize := fs.Int(
+		"sink-queue-size",
+		collector.DefaultSinkQueueSize,
+		"Number of readings queued for each sink, beyond which readings are dropped for that sink until it catches up",
+	)
+	sinkErrorPolicy := fs.String(
+		"sink-error-policy",
+		string(collector.SinkErrorIgnore),
+		"How sink errors are handled: ignore (count and log them) or abort (additionally reconnect the stream)",
+	)
+	queueDir := fs.String(
+		"queue-dir",
+		"",
+		"Directory of disk-backed write-ahead queues buffering readings for InfluxDB and Graphite while they are unreachable; queueing is disabled if empty",
+	)
+	queueMaxSize := fs.Int64(
+		"queue-max-size",
+		collector.DefaultDiskQueueMaxSize,
+		"Size in bytes of each sink's and device's disk queue, beyond which the oldest pending readings are dropped",
+	)
+	influxDBURL := fs.String(
+		"influxdb-url",
+		"",
+		"URL of an InfluxDB server to write readings to: http(s)://host:8086 for the write API, or udp://host:8089 for the UDP listener; InfluxDB is disabled if empty",
+	)
+	influxDBDatabase := fs.String("influxdb-database", "", "InfluxDB 1 database to write to")
+	influxDBRetentionPolicy := fs.String(
+		"influxdb-retention-policy",
+		"",
+		"InfluxDB 1 retention policy to write to; the database's default if empty",
+	)
+	influxDBUsername := fs.String("influxdb-username", "", "InfluxDB 1 username")
+	influxDBPasswordFile := fs.String(
+		"influxdb-password-file",
+		"",
+		"Path to a file containing the InfluxDB 1 password for -influxdb-username",
+	)
+	influxDBOrg := fs.String("influxdb-org", "", "InfluxDB 2 organization to write to")
+	influxDBBucket := fs.String(
+		"influxdb-bucket",
+		"",
+		"InfluxDB 2 bucket to write to; selects the InfluxDB 2 write API",
+	)
+	influxDBTokenFile := fs.String(
+		"influxdb-token-file",
+		"",
+		"Path to a file containing an InfluxDB API token",
+	)
+	influxDBBatchSize := fs.Int(
+		"influxdb-batch-size",
+		collector.DefaultInfluxDBBatchSize,
+		"Number of readings buffered before they are written to InfluxDB",
+	)
+	influxDBFlushInterval := fs.Duration(
+		"influxdb-flush-interval",
+		collector.DefaultInfluxDBFlushInterval,
+		"Maximum amount of time a reading is buffered before it is written to InfluxDB",
+	)
+	graphiteAddr := fs.String(
+		"graphite",
+		"",
+		"Address of a Graphite Carbon plaintext listener to write readings to, as host:port; Graphite is disabled if empty",
+	)
+	graphitePath := fs.String(
+		"graphite-path",
+		collector.DefaultGraphitePath,
+		"Template of the Graphite metric path of readings, in Go text/template syntax; {{.Device}} is the device identifier",
+	)
+	mqttBroker := fs.String(
+		"mqtt-broker",
+		"",
+		"URL of an MQTT broker to publish readings to, e.g. tcp://host:1883 or ssl://host:8883; MQTT is disabled if empty",
+	)
+	mqttClientID := fs.String(
+		"mqtt-client-id",
+		collector.DefaultMQTTClientID,
+		"Prefix of the MQTT client identifier, to which the device identifier and a hash of the server address are appended",
+	)
+	mqttUsername := fs.String("mqtt-username", "", "MQTT username")
+	mqttPasswordFile := fs.String(
+		"mqtt-password-file",
+		"",
+		"Path to a file containing the MQTT password for -mqtt-username",
+	)
+	mqttTopic := fs.String(
+		"mqtt-topic",
+		collector.DefaultMQTTTopic,
+		"Template of the MQTT topic of readings, in Go text/template syntax; {{.Device}} is the device identifier",
+	)
+	mqttAvailabilityTopic := fs.String(
+		"mqtt-availability-topic",
+		collector.DefaultMQTTAvailabilityTopic,
+		"Template of the MQTT topic of device availability (online or offline), in Go text/template syntax",
+	)
+	mqttQoS := fs.Int("mqtt-qos", 0, "Quality of service of MQTT messages: 0, 1, or 2")
+	mqttRetain := fs.Bool("mqtt-retain", false, "Publish readings as retained MQTT messages")
+	mqttDiscovery := fs.Bool(
+		"mqtt-discovery",
+		false,
+		"Publish Home Assistant MQTT discovery configuration, so that sensors appear automatically",
+	)
+	mqttDiscoveryPrefix := fs.String(
+		"mqtt-discovery-prefix",
+		collector.DefaultMQTTDiscoveryPrefix,
+		"Topic prefix of Home Assistant MQTT discovery configuration",
+	)
+	filePath := fs.String(
+		"file",
+		"",
+		"Template of the path of a local file to archive readings to, in Go text/template syntax; {{.Device}} is the device identifier and {{.Server}} the server address, both of which are required with more than one server; archiving is disabled if empty",
+	)
+	fileFormat := fs.String("file-format", string(collector.FileFormatCSV), "Format of archived readings: csv or jsonl")
+	fileMaxSize := fs.Int64(
+		"file-max-size",
+		collector.DefaultFileMaxSize,
+		"Size in bytes beyond which the archive file is rotated; unlimited if 0",
+	)
+	fileRotationInterval := fs.Duration(
+		"file-rotation-interval",
+		collector.DefaultFileRotation,
+		"Age beyond which the archive file is rotated; unlimited if 0",
+	)
+	fileCompress := fs.Bool("file-compress", true, "Compress rotated archive files with gzip")
+	fileMaxFiles := fs.Int(
+		"file-max-files",
+		collector.DefaultFileMaxFiles,
+		"Maximum number of rotated archive files retained per device, beyond which the oldest are deleted; unlimited if 0",
+	)
+	fileFsync := fs.String(
+		"file-fsync",
+		string(collector.FsyncInterval),
+		"When archived readings are synced to disk: always (after every reading), interval (every -file-fsync-interval), or never (only on rotation and shutdown)",
+	)
+	fileFsyncInterval := fs.Duration(
+		"file-fsync-interval",
+		collector.DefaultFileFsyncInterval,
+		"Interval at which archived readings are synced to disk, with -file-fsync=interval",
+	)
+	if err := flagconfig.Parse(fs, args, envPrefix); err != nil {
+		return nil, err
+	}
+
+	if *printConfig {
+		if err := flagconfig.Print(os.Stdout, fs); err != nil {
+			return nil, err
+		}
+
+		os.Exit(0)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, errors.New("config: TLS certificate and key must be specified together")
+	}
 
-	if *serverAddr == "" {
-		return nil, errors.New("config: address of Zephyrus server must be specified")
+	if len(*servers) == 0 {
+		return nil, errors.New("config: address of at least one Zephyrus server must be specified")
 	}
 
-	if *statsdAddr == "" {
-		return nil, errors.New("config: address of statsd server must be specified")
+	if *statsdAddr == "" && *influxDBURL == "" && *graphiteAddr == "" && *mqttBroker == "" && *filePath == "" {
+		return nil, errors.New("config: address of a statsd, InfluxDB, Graphite, or MQTT server, or an archive file, must be specified")
+	}
+
+	format, err := collector.ParseTagFormat(*statsdFormat)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	retry := collector.RetryPolicy{
+		InitialInterval:  *retryInitialInterval,
+		MaxInterval:      *retryMaxInterval,
+		Multiplier:       *retryMultiplier,
+		Jitter:           *retryJitter,
+		FailureThreshold: *circuitThreshold,
+	}
+	if err := retry.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	policy, err := collector.ParseSinkErrorPolicy(*sinkErrorPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	fanOut := collector.FanOutConfig{QueueSize: *sinkQueueSize, ErrorPolicy: policy}
+	if err := fanOut.Validate(); err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	if *queueDir != "" {
+		queue := collector.DiskQueueConfig{Dir: *queueDir, MaxSize: *queueMaxSize, Retry: retry}
+		if err := queue.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	var influxDB collector.InfluxDBConfig
+	if *influxDBURL != "" {
+		influxDB = collector.InfluxDBConfig{
+			URL:             *influxDBURL,
+			Database:        *influxDBDatabase,
+			RetentionPolicy: *influxDBRetentionPolicy,
+			Username:        *influxDBUsername,
+			Org:             *influxDBOrg,
+			Bucket:          *influxDBBucket,
+			BatchSize:       *influxDBBatchSize,
+			FlushInterval:   *influxDBFlushInterval,
+		}
+
+		if influxDB.Password, err = readSecret(*influxDBPasswordFile); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		if influxDB.Token, err = readSecret(*influxDBTokenFile); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		if err := influxDB.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	var graphite collector.GraphiteConfig
+	if *graphiteAddr != "" {
+		graphite = collector.GraphiteConfig{Addr: *graphiteAddr, PathTemplate: *graphitePath}
+
+		if err := graphite.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	var broker collector.MQTTConfig
+	if *mqttBroker != "" {
+		if *mqttQoS < 0 || *mqttQoS > 2 {
+			return nil, errors.New("config: MQTT QoS must be 0, 1, or 2")
+		}
+
+		broker = collector.MQTTConfig{
+			Broker:            *mqttBroker,
+			ClientID:          *mqttClientID,
+			Username:          *mqttUsername,
+			Topic:             *mqttTopic,
+			AvailabilityTopic: *mqttAvailabilityTopic,
+			QoS:               byte(*mqttQoS),
+			Retain:            *mqttRetain,
+			Discovery:         *mqttDiscovery,
+			DiscoveryPrefix:   *mqttDiscoveryPrefix,
+		}
+
+		if broker.Password, err = readSecret(*mqttPasswordFile); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		if err := broker.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	var archive collector.FileConfig
+	if *filePath != "" {
+		format, err := collector.ParseFileFormat(*fileFormat)
+		if err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		fsync, err := collector.ParseFsyncPolicy(*fileFsync)
+		if err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		archive = collector.FileConfig{
+			Path:             *filePath,
+			Format:           format,
+			MaxSize:          *fileMaxSize,
+			RotationInterval: *fileRotationInterval,
+			Compress:         *fileCompress,
+			MaxFiles:         *fileMaxFiles,
+			Fsync:            fsync,
+			FsyncInterval:    *fileFsyncInterval,
+		}
+
+		if err := archive.Validate(); err != nil {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+
+		if len(dedupe(*servers)) > 1 {
+			if err := archive.ValidateShared(); err != nil {
+				return nil, fmt.Errorf("config: %v", err)
+			}
+		}
 	}
 
 	return &config{
-		ServerAddr: *serverAddr,
-		StatsdAddr: *statsdAddr,
-		SampleRate: *sampleRate,
+		ConfigFile: *configFile,
+		Config: supervisor.Config{
+			Servers:         dedupe(*servers),
+			StatsdAddr:      *statsdAddr,
+			StatsdFormat:    format,
+			SampleRate:      *sampleRate,
+			Alerts:          *alerts,
+			TLS:             *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsServer != "",
+			TLSCA:           *tlsCA,
+			TLSCert:         *tlsCert,
+			TLSKey:          *tlsKey,
+			TLSServer:       *tlsServer,
+			TokenFile:       *tokenFile,
+			ShutdownTimeout: *shutdownTimeout,
+			Retry:           retry,
+			InfluxDB:        influxDB,
+			Graphite:        graphite,
+			MQTT:            broker,
+			File:            archive,
+			SinkQueueSize:   fanOut.QueueSize,
+			SinkErrorPolicy: fanOut.ErrorPolicy,
+			QueueDir:        *queueDir,
+			QueueMaxSize:    *queueMaxSize,
+		},
 	}, nil
 }
+
+// readSecret returns the trimmed contents of a file containing a secret, or an empty string if no
+// file is specified.
+func readSecret(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// dedupe returns the values in order, omitting repeated values.
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
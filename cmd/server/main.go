@@ -1,25 +1,83 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"zephyrus/internal/alert"
 	"zephyrus/internal/device"
+	"zephyrus/internal/flagconfig"
+	"zephyrus/internal/listener"
+	"zephyrus/internal/logging"
+	"zephyrus/internal/metrics"
 	"zephyrus/internal/server"
+	"zephyrus/internal/tlsconfig"
 )
 
+const (
+	// exitCodeShutdown is the exit code after a signal-triggered shutdown that drained cleanly.
+	exitCodeShutdown = 0
+	// exitCodeForcedShutdown is the exit code after a signal-triggered shutdown that could not drain
+	// within the shutdown timeout.
+	exitCodeForcedShutdown = 3
+)
+
+// httpListenerName is the FileDescriptorName of socket-activated listeners serving HTTP endpoints.
+// All other socket-activated listeners serve gRPC.
+const httpListenerName = "http"
+
+// envPrefix is the prefix of environment variables setting configuration options.
+const envPrefix = "ZEPHYRUS_SERVER_"
+
 type config struct {
-	Port       int
-	Identifier string
+	ListenAddr      string
+	UnixSocketMode  os.FileMode
+	Identifier      string
+	AlertRules      string
+	AlertInterval   time.Duration
+	HTTPAddr        string
+	Gateway         bool
+	Dashboard       bool
+	QueryToken      bool
+	HealthWindow    time.Duration
+	Readiness       bool
+	TLSCert         string
+	TLSKey          string
+	TLSClientCA     string
+	AuthTokens      string
+	Limits          server.Limits
+	LogFormat       logging.Format
+	LogLevel        logging.Level
+	ShutdownTimeout time.Duration
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server and blocks until it is shut down, returning the process exit code.
+func run() int {
 	cfg, err := parseConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	log.Printf("main: using configuration: port=%d device=%s", cfg.Port, cfg.Identifier)
+	log.Printf(
+		"main: using configuration: listen=%s device=%s alert rules=%s http=%s",
+		cfg.ListenAddr,
+		cfg.Identifier,
+		cfg.AlertRules,
+		cfg.HTTPAddr,
+	)
 
 	log.Printf("main: finding and initializing device")
 	temper, err := device.NewTemperClient(cfg.Identifier)
@@ -32,29 +90,385 @@ func main() {
 	}
 	defer temper.Close()
 
+	registry := metrics.NewRegistry()
+
+	instrumented, err := device.NewInstrumentedSensor(temper, registry)
+	if err != nil {
+		panic(err)
+	}
+
+	sensor := device.NewThrottledSensor(instrumented)
+	sensor.RegisterMetrics(registry)
+
+	// Functions invoked to reload configuration from disk when the process receives SIGHUP.
+	var reloaders []func() error
+
+	// The alert engine reads from the device, so it is only run if there are rules to evaluate.
+	if cfg.AlertRules != "" {
+		log.Printf("main: initializing alert engine: rules=%s", cfg.AlertRules)
+		rules, err := alert.LoadRules(cfg.AlertRules)
+		if err != nil {
+			panic(err)
+		}
+
+		alerts := alert.NewEngine(sensor, rules, cfg.AlertInterval)
+		alerts.Start()
+		defer alerts.Stop()
+
+		reloaders = append(reloaders, func() error {
+			return alerts.LoadRules(cfg.AlertRules)
+		})
+	}
+
 	log.Printf("main: initializing Zephyrus gRPC server")
-	zephyrus, err := server.NewZephyrusServer(device.NewThrottledSensor(temper))
+	opts := []server.Option{
+		server.WithMetrics(registry),
+		server.WithHealthWindow(cfg.HealthWindow),
+		server.WithLimits(cfg.Limits),
+		server.WithAccessLogger(logging.NewLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel)),
+	}
+	if cfg.Readiness {
+		opts = append(opts, server.WithReadiness(cfg.HealthWindow))
+	}
+
+	if cfg.Gateway {
+		opts = append(opts, server.WithGateway())
+	}
+
+	if cfg.Dashboard {
+		opts = append(opts, server.WithDashboard())
+	}
+
+	if cfg.QueryToken {
+		opts = append(opts, server.WithQueryToken())
+	}
+
+	if cfg.TLSCert != "" {
+		log.Printf("main: enabling TLS: cert=%s client ca=%s", cfg.TLSCert, cfg.TLSClientCA)
+		tlsConfig, err := tlsconfig.NewServerConfig(&tlsconfig.ServerOptions{
+			CertFile:     cfg.TLSCert,
+			KeyFile:      cfg.TLSKey,
+			ClientCAFile: cfg.TLSClientCA,
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		opts = append(opts, server.WithTLS(tlsConfig))
+	}
+
+	if cfg.AuthTokens != "" {
+		log.Printf("main: enabling token authentication: tokens=%s", cfg.AuthTokens)
+		auth, err := server.NewTokenAuthenticator(cfg.AuthTokens)
+		if err != nil {
+			panic(err)
+		}
+
+		reloaders = append(reloaders, auth.Reload)
+		opts = append(opts, server.WithTokenAuthenticator(auth))
+	}
+
+	zephyrus, err := server.NewZephyrusServer(sensor, opts...)
 	if err != nil {
 		panic(err)
 	}
 
-	log.Printf("main: serving on port %d", cfg.Port)
-	if err := zephyrus.Serve(cfg.Port); err != nil {
+	grpcListeners, httpListeners, err := openListeners(cfg)
+	if err != nil {
 		panic(err)
 	}
+
+	serveErrors := make(chan error, len(grpcListeners)+len(httpListeners))
+
+	for _, l := range httpListeners {
+		log.Printf("main: serving HTTP endpoints on %s: tls=%v", l.Addr(), cfg.TLSCert != "")
+		go func(l net.Listener) {
+			serveErrors <- zephyrus.ServeHTTPListener(l)
+		}(l)
+	}
+
+	for _, l := range grpcListeners {
+		log.Printf("main: serving on %s", l.Addr())
+		go func(l net.Listener) {
+			serveErrors <- zephyrus.Serve(l)
+		}(l)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case err := <-serveErrors:
+			panic(err)
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(reloaders)
+				continue
+			}
+
+			log.Printf("main: received %v; shutting down: timeout=%v", sig, cfg.ShutdownTimeout)
+			return shutdown(zephyrus, cfg.ShutdownTimeout)
+		}
+	}
+}
+
+// openListeners opens the listeners on which to serve gRPC calls and HTTP endpoints. If the process
+// was socket-activated by systemd, the passed sockets are used in place of -listen, and a socket
+// named "http" (if any) is used in place of -http-addr.
+func openListeners(cfg *config) ([]net.Listener, []net.Listener, error) {
+	activated, err := listener.SystemdListeners()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var grpcListeners, httpListeners []net.Listener
+	for name, listeners := range activated {
+		log.Printf("main: using %d socket-activated listeners: name=%s", len(listeners), name)
+
+		if name == httpListenerName {
+			httpListeners = append(httpListeners, listeners...)
+		} else {
+			grpcListeners = append(grpcListeners, listeners...)
+		}
+	}
+
+	if len(grpcListeners) == 0 {
+		l, err := listener.Listen(cfg.ListenAddr, cfg.UnixSocketMode)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		grpcListeners = append(grpcListeners, l)
+	}
+
+	if len(httpListeners) == 0 && cfg.HTTPAddr != "" {
+		l, err := listener.Listen(cfg.HTTPAddr, cfg.UnixSocketMode)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		httpListeners = append(httpListeners, l)
+	}
+
+	return grpcListeners, httpListeners, nil
+}
+
+// shutdown gracefully stops the server within the timeout and returns the process exit code.
+// Deferred cleanup in run, such as closing the device, happens after this returns.
+func shutdown(zephyrus *server.ZephyrusServer, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := zephyrus.Shutdown(ctx); err != nil {
+		log.Printf("main: failed to shut down cleanly: error=%v", err)
+		return exitCodeForcedShutdown
+	}
+
+	log.Printf("main: shut down cleanly")
+	return exitCodeShutdown
+}
+
+// reload invokes each reloader. Failed reloads are logged and ignored, leaving the previously
+// loaded configuration in effect.
+func reload(reloaders []func() error) {
+	log.Printf("main: reloading configuration")
+
+	for _, reloader := range reloaders {
+		if err := reloader(); err != nil {
+			log.Printf("main: failed to reload configuration: error=%v", err)
+		}
+	}
 }
 
 func parseConfig() (*config, error) {
-	port := flag.Int("port", 6840, "TCP port on which the gRPC server should listen")
+	flag.String(
+		flagconfig.FileFlag,
+		"",
+		"Path to a YAML, TOML, or JSON configuration file whose keys are flag names; flags take precedence over the file, and the file over ZEPHYRUS_SERVER_FLAG_NAME environment variables",
+	)
+	printConfig := flag.Bool(
+		flagconfig.PrintFlag,
+		false,
+		"Print the effective configuration as YAML and exit",
+	)
+	port := flag.Int("port", 6840, "TCP port on which the gRPC server should listen on all interfaces")
+	listen := flag.String(
+		"listen",
+		"",
+		"Address on which the gRPC server should listen, as host:port or unix:///path/to/socket; overrides -port",
+	)
+	unixSocketMode := flag.String(
+		"unix-socket-mode",
+		"0660",
+		"Permissions, in octal, of Unix domain sockets created by -listen and -http-addr",
+	)
 	identifier := flag.String(
 		"identifier",
 		"temper",
 		"Name used to uniquely identify the device associated with this server",
 	)
-	flag.Parse()
+	alertRules := flag.String(
+		"alert-rules",
+		"",
+		"Path to a JSON file of alert rules, which are evaluated and logged if set; reloaded on SIGHUP",
+	)
+	alertInterval := flag.Duration(
+		"alert-interval",
+		1*time.Second,
+		"Interval at which alert rules are evaluated against device readings",
+	)
+	httpAddr := flag.String(
+		"http-addr",
+		"",
+		"Address (e.g. :9840 or unix:///path/to/socket) on which to serve HTTP endpoints, including Prometheus /metrics, over TLS if -tls-cert is set; disabled if empty",
+	)
+	gateway := flag.Bool(
+		"gateway",
+		false,
+		"Serve the device and weather services as JSON and Server-Sent Events under /v1/ on -http-addr",
+	)
+	dashboard := flag.Bool(
+		"dashboard",
+		false,
+		"Serve a web dashboard of the current temperature and device status at / on -http-addr",
+	)
+	queryToken := flag.Bool(
+		"http-query-token",
+		false,
+		"Accept bearer tokens in the access_token query parameter of -gateway requests, for clients that cannot set headers; tokens in URLs may be logged",
+	)
+	healthWindow := flag.Duration(
+		"health-window",
+		30*time.Second,
+		"Maximum age of the last successful device read for the device to be reported healthy",
+	)
+	readiness := flag.Bool(
+		"readiness",
+		false,
+		"Fail Meta health checks when the device is not healthy, rather than reporting liveness only",
+	)
+	tlsCert := flag.String(
+		"tls-cert",
+		"",
+		"Path to a PEM-encoded server certificate; enables TLS if set, and is reloaded on change",
+	)
+	tlsKey := flag.String("tls-key", "", "Path to the PEM-encoded private key for -tls-cert")
+	tlsClientCA := flag.String(
+		"tls-client-ca",
+		"",
+		"Path to PEM-encoded CA certificates; if set, clients must present a certificate signed by one of them",
+	)
+	authTokens := flag.String(
+		"auth-tokens",
+		"",
+		"Path to a JSON file of principals, bearer tokens, and allowed methods; if set, calls require a token",
+	)
+	maxStreams := flag.Int(
+		"max-streams-per-peer",
+		16,
+		"Maximum number of concurrent streams per client host; unlimited if 0",
+	)
+	maxSampleRate := flag.Float64(
+		"max-sample-rate",
+		10,
+		"Maximum stream sample rate a client may request, in samples per second; unlimited if 0",
+	)
+	rejectSampleRate := flag.Bool(
+		"reject-excess-sample-rate",
+		false,
+		"Reject streams requesting a sample rate above -max-sample-rate, rather than clamping it",
+	)
+	unaryRate := flag.Float64(
+		"unary-rate-limit",
+		0,
+		"Maximum sustained rate of GetTemperature calls per client host, per second; unlimited if 0",
+	)
+	unaryBurst := flag.Int(
+		"unary-burst",
+		1,
+		"Number of GetTemperature calls a client host may make in a burst above -unary-rate-limit",
+	)
+	logFormat := flag.String("log-format", "text", "Format of access logs: text or json")
+	logLevel := flag.String(
+		"log-level",
+		"info",
+		"Minimum level of access logs: debug (includes health checks), info, warn, or error",
+	)
+	shutdownTimeout := flag.Duration(
+		"shutdown-timeout",
+		10*time.Second,
+		"Maximum amount of time to drain active calls on SIGINT or SIGTERM before closing them forcibly",
+	)
+	if err := flagconfig.Parse(flag.CommandLine, os.Args[1:], envPrefix); err != nil {
+		return nil, err
+	}
+
+	if *printConfig {
+		if err := flagconfig.Print(os.Stdout, flag.CommandLine); err != nil {
+			return nil, err
+		}
+
+		os.Exit(0)
+	}
+
+	format, err := logging.ParseFormat(*logFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	socketMode, err := strconv.ParseUint(*unixSocketMode, 8, 32)
+	if err != nil || socketMode > 0777 {
+		return nil, fmt.Errorf("config: invalid Unix socket mode %q", *unixSocketMode)
+	}
+
+	listenAddr := *listen
+	if listenAddr == "" {
+		listenAddr = fmt.Sprintf(":%d", *port)
+	}
+
+	if *maxStreams < 0 || *maxSampleRate < 0 || *unaryRate < 0 || *unaryBurst < 0 {
+		return nil, errors.New("config: limits must be non-negative")
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, errors.New("config: TLS certificate and key must be specified together")
+	}
+
+	if *tlsClientCA != "" && *tlsCert == "" {
+		return nil, errors.New("config: TLS client CA requires a server certificate and key")
+	}
 
 	return &config{
-		Port:       *port,
-		Identifier: *identifier,
+		ListenAddr:     listenAddr,
+		UnixSocketMode: os.FileMode(socketMode),
+		Identifier:     *identifier,
+		AlertRules:     *alertRules,
+		AlertInterval:  *alertInterval,
+		HTTPAddr:       *httpAddr,
+		Gateway:        *gateway,
+		Dashboard:      *dashboard,
+		QueryToken:     *queryToken,
+		HealthWindow:   *healthWindow,
+		Readiness:      *readiness,
+		TLSCert:        *tlsCert,
+		TLSKey:         *tlsKey,
+		TLSClientCA:    *tlsClientCA,
+		AuthTokens:     *authTokens,
+		Limits: server.Limits{
+			MaxStreamsPerPeer:      *maxStreams,
+			MaxSampleRate:          *maxSampleRate,
+			RejectExcessSampleRate: *rejectSampleRate,
+			UnaryRate:              *unaryRate,
+			UnaryBurst:             *unaryBurst,
+		},
+		LogFormat:       format,
+		LogLevel:        level,
+		ShutdownTimeout: *shutdownTimeout,
 	}, nil
 }
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"zephyrus/internal/client"
+	"zephyrus/internal/tlsconfig"
+)
+
+const (
+	// exitCodeError is the exit code after a failed command.
+	exitCodeError = 1
+	// exitCodeUsage is the exit code after invalid command-line usage.
+	exitCodeUsage = 2
+)
+
+// Output formats.
+const (
+	formatHuman = "human"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+const usage = `Usage: zephyrusctl [options] <command> [command options]
+
+Commands:
+  temp      Read the current temperature
+  stream    Stream temperatures (options: -rate, -samples)
+  status    Read the device status
+  id        Read the device identifier
+  health    Check server health via the gRPC health checking protocol (options: -service)
+
+Options:
+`
+
+// errUnhealthy is returned by the health command when the server reports not serving.
+var errUnhealthy = errors.New("server is not serving")
+
+// usageError describes invalid command-line usage of a subcommand.
+type usageError struct {
+	error
+}
+
+type config struct {
+	ServerAddr string
+	Output     string
+	TLS        bool
+	TLSCA      string
+	TLSCert    string
+	TLSKey     string
+	TLSServer  string
+	TokenFile  string
+	Command    string
+	Args       []string
+}
+
+// command is a subcommand run against a connected client, printing results to the printer.
+type command func(zephyrus *client.ZephyrusClient, p *printer, args []string) error
+
+// commands maps each subcommand name to its implementation.
+var commands = map[string]command{
+	"temp":   runTemp,
+	"stream": runStream,
+	"status": runStatus,
+	"id":     runID,
+	"health": runHealth,
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the command specified on the command line and returns the process exit code.
+func run() int {
+	cfg, err := parseConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zephyrusctl: %v\n", err)
+		return exitCodeUsage
+	}
+
+	cmd := commands[cfg.Command]
+
+	zephyrus, err := connect(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zephyrusctl: %v\n", err)
+		return exitCodeError
+	}
+	defer zephyrus.Close()
+
+	p := newPrinter(os.Stdout, cfg.Output)
+
+	err = cmd(zephyrus, p, cfg.Args)
+	if flushErr := p.flush(); err == nil {
+		err = flushErr
+	}
+
+	if _, ok := err.(usageError); ok {
+		// The flag package has already reported the error.
+		return exitCodeUsage
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "zephyrusctl: %v\n", err)
+		return exitCodeError
+	}
+
+	return 0
+}
+
+// connect creates a client using the configured connection options.
+func connect(cfg *config) (*client.ZephyrusClient, error) {
+	var opts []client.Option
+	if cfg.TLS {
+		tlsConfig, err := tlsconfig.NewClientConfig(&tlsconfig.ClientOptions{
+			CAFile:     cfg.TLSCA,
+			CertFile:   cfg.TLSCert,
+			KeyFile:    cfg.TLSKey,
+			ServerName: cfg.TLSServer,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, client.WithTLS(tlsConfig))
+	}
+
+	if cfg.TokenFile != "" {
+		token, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, client.WithToken(strings.TrimSpace(string(token))))
+	}
+
+	return client.NewZephyrusClient(cfg.ServerAddr, opts...)
+}
+
+// runTemp prints the current temperature.
+func runTemp(zephyrus *client.ZephyrusClient, p *printer, args []string) error {
+	if err := parseCommandFlags("temp", args, nil); err != nil {
+		return err
+	}
+
+	temperature, err := zephyrus.Weather.GetTemperature()
+	if err != nil {
+		return err
+	}
+
+	return p.print(newTemperatureRecord(temperature))
+}
+
+// runStream prints streamed temperatures until the requested number of samples is received or the
+// process is interrupted.
+func runStream(zephyrus *client.ZephyrusClient, p *printer, args []string) error {
+	var rate float64
+	var samples int
+
+	if err := parseCommandFlags("stream", args, func(fs *flag.FlagSet) {
+		fs.Float64Var(&rate, "rate", 1.0, "Server-side sample rate, in samples per second; 0 streams as fast as possible")
+		fs.IntVar(&samples, "samples", 0, "Number of samples to stream; streams indefinitely if 0")
+	}); err != nil {
+		return err
+	}
+
+	if samples < 0 {
+		return errors.New("number of samples must be non-negative")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := zephyrus.Weather.StreamTemperatureSamplesContext(ctx, rate, int32(samples), p)
+	if ctx.Err() != nil {
+		// Interrupting an indefinite stream is the normal way to end it.
+		return nil
+	}
+
+	return err
+}
+
+// runStatus prints the device status.
+func runStatus(zephyrus *client.ZephyrusClient, p *printer, args []string) error {
+	if err := parseCommandFlags("status", args, nil); err != nil {
+		return err
+	}
+
+	status, err := zephyrus.DeviceInfo.GetStatus()
+	if err != nil {
+		return err
+	}
+
+	return p.print(&record{
+		fields: []string{"status"},
+		values: []interface{}{status.String()},
+		human:  status.String(),
+	})
+}
+
+// runID prints the device identifier.
+func runID(zephyrus *client.ZephyrusClient, p *printer, args []string) error {
+	if err := parseCommandFlags("id", args, nil); err != nil {
+		return err
+	}
+
+	identifier, err := zephyrus.DeviceInfo.GetIdentifier()
+	if err != nil {
+		return err
+	}
+
+	return p.print(&record{
+		fields: []string{"identifier"},
+		values: []interface{}{identifier},
+		human:  identifier,
+	})
+}
+
+// runHealth prints the serving status of the server, failing if it is not serving.
+func runHealth(zephyrus *client.ZephyrusClient, p *printer, args []string) error {
+	var service string
+
+	if err := parseCommandFlags("health", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&service, "service", "", "Name of the service to check (e.g. zephyrus.Weather); the server as a whole if empty")
+	}); err != nil {
+		return err
+	}
+
+	serving, err := zephyrus.Health.Check(service)
+	if err != nil {
+		return err
+	}
+
+	status := "NOT_SERVING"
+	if serving {
+		status = "SERVING"
+	}
+
+	if err := p.print(&record{
+		fields: []string{"service", "status"},
+		values: []interface{}{service, status},
+		human:  status,
+	}); err != nil {
+		return err
+	}
+
+	if !serving {
+		return errUnhealthy
+	}
+
+	return nil
+}
+
+// parseCommandFlags parses the flags of a subcommand, registered by the define function, if any.
+// Subcommands accept no positional arguments.
+func parseCommandFlags(name string, args []string, define func(*flag.FlagSet)) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if define != nil {
+		define(fs)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "%s: unexpected arguments: %s\n", name, strings.Join(fs.Args(), " "))
+		return usageError{errors.New("unexpected arguments")}
+	}
+
+	return nil
+}
+
+// record is a single result of a command, with fields in output order.
+type record struct {
+	fields []string
+	values []interface{}
+	// Human-readable representation of the record.
+	human string
+}
+
+// newTemperatureRecord creates a record of a temperature reading taken now.
+func newTemperatureRecord(temperature float64) *record {
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+
+	return &record{
+		fields: []string{"timestamp", "temperature", "unit"},
+		values: []interface{}{timestamp, temperature, "celsius"},
+		human:  fmt.Sprintf("%s  %.2f °C", timestamp, temperature),
+	}
+}
+
+// printer writes records in an output format. It implements client.TemperatureConsumer, so that
+// streamed temperatures are printed as they arrive.
+type printer struct {
+	out    io.Writer
+	format string
+	csv    *csv.Writer
+	// Whether the CSV header has been written.
+	header bool
+}
+
+// newPrinter creates a printer writing to out in the specified format.
+func newPrinter(out io.Writer, format string) *printer {
+	return &printer{out: out, format: format, csv: csv.NewWriter(out)}
+}
+
+// print writes a single record.
+func (p *printer) print(r *record) error {
+	switch p.format {
+	case formatJSON:
+		obj := make(map[string]interface{}, len(r.fields))
+		for i, field := range r.fields {
+			obj[field] = r.values[i]
+		}
+
+		return json.NewEncoder(p.out).Encode(obj)
+	case formatCSV:
+		if !p.header {
+			if err := p.csv.Write(r.fields); err != nil {
+				return err
+			}
+
+			p.header = true
+		}
+
+		row := make([]string, len(r.values))
+		for i, value := range r.values {
+			if f, ok := value.(float64); ok {
+				row[i] = strconv.FormatFloat(f, 'f', -1, 64)
+			} else {
+				row[i] = fmt.Sprint(value)
+			}
+		}
+
+		if err := p.csv.Write(row); err != nil {
+			return err
+		}
+
+		// Rows are flushed immediately so that streamed readings can be consumed as they arrive.
+		p.csv.Flush()
+
+		return p.csv.Error()
+	default:
+		_, err := fmt.Fprintln(p.out, r.human)
+		return err
+	}
+}
+
+// flush writes any buffered output.
+func (p *printer) flush() error {
+	p.csv.Flush()
+	return p.csv.Error()
+}
+
+// Consume prints a streamed temperature.
+func (p *printer) Consume(temperature float64) error {
+	return p.print(newTemperatureRecord(temperature))
+}
+
+func parseConfig() (*config, error) {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	serverAddr := flag.String(
+		"server",
+		"localhost:6840",
+		"Address of the Zephyrus gRPC server, as host:port or unix:///path/to/socket",
+	)
+	output := flag.String("output", formatHuman, "Output format: human, json, or csv")
+	useTLS := flag.Bool("tls", false, "Connect to the Zephyrus server over TLS")
+	tlsCA := flag.String(
+		"tls-ca",
+		"",
+		"Path to PEM-encoded CA certificates used to verify the server; implies -tls",
+	)
+	tlsCert := flag.String(
+		"tls-cert",
+		"",
+		"Path to a PEM-encoded client certificate for mutual TLS; implies -tls",
+	)
+	tlsKey := flag.String("tls-key", "", "Path to the PEM-encoded private key for -tls-cert")
+	tlsServer := flag.String(
+		"tls-server-name",
+		"",
+		"Name against which to verify the server certificate, if different from the server address",
+	)
+	tokenFile := flag.String(
+		"token-file",
+		"",
+		"Path to a file containing a bearer token presented to the Zephyrus server",
+	)
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return nil, errors.New("config: a command must be specified")
+	}
+
+	if _, ok := commands[flag.Arg(0)]; !ok {
+		return nil, fmt.Errorf("config: unknown command %q", flag.Arg(0))
+	}
+
+	switch *output {
+	case formatHuman, formatJSON, formatCSV:
+	default:
+		return nil, fmt.Errorf("config: unknown output format %q", *output)
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return nil, errors.New("config: TLS certificate and key must be specified together")
+	}
+
+	return &config{
+		ServerAddr: *serverAddr,
+		Output:     *output,
+		TLS:        *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsServer != "",
+		TLSCA:      *tlsCA,
+		TLSCert:    *tlsCert,
+		TLSKey:     *tlsKey,
+		TLSServer:  *tlsServer,
+		TokenFile:  *tokenFile,
+		Command:    flag.Arg(0),
+		Args:       flag.Args()[1:],
+	}, nil
+}
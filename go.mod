@@ -1,10 +1,13 @@
 module zephyrus
 
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/golang/protobuf v1.3.2
 	github.com/zserge/hid v0.0.0-20190124175232-e1626f1782f3
 	golang.org/x/net v0.0.0-20191124235446-72fef5d5e266
 	google.golang.org/grpc v1.25.1
+	gopkg.in/yaml.v2 v2.2.7
 	lib.kevinlin.info/aperture v0.0.0-20191229014409-1086497fddd8
 )
 
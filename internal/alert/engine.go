@@ -0,0 +1,142 @@
+package alert
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"zephyrus/internal/device"
+)
+
+// subscriberBufferSize describes the number of events buffered per subscriber before further
+// events are dropped for that subscriber.
+const subscriberBufferSize = 64
+
+// Engine periodically observes a sensor, evaluates alert rules against each observation, and
+// broadcasts resulting events to subscribers.
+type Engine struct {
+	// Sensor under observation.
+	sensor device.Sensor
+	// Rule evaluator.
+	evaluator *Evaluator
+	// Interval between consecutive observations.
+	interval time.Duration
+	// Active event subscribers.
+	subscribers map[chan *Event]bool
+	// Mutex used to synchronize access to subscribers.
+	mutex sync.Mutex
+	// Channel closed to stop the observation loop.
+	done chan bool
+}
+
+// NewEngine creates an engine that observes the sensor at the specified interval.
+// Note that the sensor should be the same (throttled) instance backing the gRPC services, so that
+// alert evaluation shares device reads with temperature streams rather than adding to them.
+func NewEngine(sensor device.Sensor, rules []*Rule, interval time.Duration) *Engine {
+	return &Engine{
+		sensor:      sensor,
+		evaluator:   NewEvaluator(rules),
+		interval:    interval,
+		subscribers: make(map[chan *Event]bool),
+		done:        make(chan bool),
+	}
+}
+
+// Start begins observing the sensor in the background.
+func (e *Engine) Start() {
+	go func() {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			e.observe()
+
+			select {
+			case <-ticker.C:
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops observing the sensor.
+func (e *Engine) Stop() {
+	close(e.done)
+}
+
+// SetRules replaces the evaluated rules, broadcasting resolve events for any firing rules that
+// were removed or modified.
+func (e *Engine) SetRules(rules []*Rule) {
+	e.broadcast(e.evaluator.SetRules(rules))
+}
+
+// LoadRules reads rules from a file on disk and replaces the evaluated rules.
+func (e *Engine) LoadRules(path string) error {
+	rules, err := LoadRules(path)
+	if err != nil {
+		return err
+	}
+
+	e.SetRules(rules)
+
+	return nil
+}
+
+// Active returns the firing events for all rules that are currently firing.
+func (e *Engine) Active() []*Event {
+	return e.evaluator.Active()
+}
+
+// Subscribe registers a new event subscriber. The returned function must be called to unregister
+// the subscriber when it is no longer interested in events.
+func (e *Engine) Subscribe() (<-chan *Event, func()) {
+	ch := make(chan *Event, subscriberBufferSize)
+
+	e.mutex.Lock()
+	e.subscribers[ch] = true
+	e.mutex.Unlock()
+
+	unsubscribe := func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+
+		delete(e.subscribers, ch)
+	}
+
+	return ch, unsubscribe
+}
+
+// observe makes a single observation of the sensor and broadcasts any resulting events.
+func (e *Engine) observe() {
+	temperature, err := e.sensor.GetTemperature()
+
+	e.broadcast(e.evaluator.Observe(Observation{
+		Time:        time.Now(),
+		Temperature: temperature,
+		Err:         err,
+		Status:      e.sensor.GetStatus(),
+	}))
+}
+
+// broadcast sends events to all subscribers without blocking on slow subscribers.
+func (e *Engine) broadcast(events []*Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, event := range events {
+		log.Printf("alert: %s", event.Message)
+
+		for ch := range e.subscribers {
+			select {
+			case ch <- event:
+			default:
+				log.Printf("alert: dropping event for slow subscriber: rule=%s", event.Rule)
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package alert
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"zephyrus/schemas"
+)
+
+// State describes the state transition reported by an alert event.
+type State string
+
+const (
+	// StateFiring indicates that a rule's condition has been met for its minimum duration.
+	StateFiring State = "firing"
+	// StateResolved indicates that a previously firing rule's condition has cleared.
+	StateResolved State = "resolved"
+)
+
+// Observation is a single sample of device state fed into an Evaluator.
+type Observation struct {
+	// Time at which the observation was made.
+	Time time.Time
+	// Temperature reading, in celsius units. Only meaningful when Err is nil.
+	Temperature float64
+	// Error encountered while reading the temperature, if any.
+	Err error
+	// Reported status of the device.
+	Status schemas.Status
+}
+
+// Event describes a transition of a rule into or out of the firing state.
+type Event struct {
+	// Name of the rule that transitioned.
+	Rule string
+	// Kind of the rule that transitioned.
+	Kind Kind
+	// New state of the rule.
+	State State
+	// Value that triggered the transition; its meaning depends on the rule kind.
+	Value float64
+	// Time of the observation that triggered the transition.
+	Time time.Time
+	// Human-readable description of the transition.
+	Message string
+}
+
+// sample is a single successful temperature reading retained for rate of change evaluation.
+type sample struct {
+	time        time.Time
+	temperature float64
+}
+
+// ruleState tracks the evaluation state of a single rule across observations.
+type ruleState struct {
+	rule *Rule
+	// Whether the rule is currently firing, and the event that caused it to fire.
+	firing *Event
+	// Time at which the rule's condition was first continuously met, if pending.
+	pendingSince time.Time
+	// Recent readings, retained only for rate of change rules.
+	samples []sample
+}
+
+// Evaluator evaluates a set of rules against a sequence of observations, applying hysteresis and
+// minimum durations to produce fire and resolve events. It is safe for concurrent use.
+type Evaluator struct {
+	// Per-rule evaluation state, in rule definition order.
+	states []*ruleState
+	// Time of the first observation, used as the staleness baseline before any good reading.
+	start time.Time
+	// Time of the most recent successful reading.
+	lastGood time.Time
+	// Mutex used to synchronize access to evaluation state.
+	mutex sync.Mutex
+}
+
+// NewEvaluator creates an evaluator for the specified rules.
+func NewEvaluator(rules []*Rule) *Evaluator {
+	e := &Evaluator{}
+	e.SetRules(rules)
+
+	return e
+}
+
+// SetRules replaces the evaluated rules. State is preserved for rules whose definitions are
+// unchanged; resolve events are returned for firing rules that were removed or modified.
+func (e *Evaluator) SetRules(rules []*Rule) []*Event {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	existing := make(map[string]*ruleState)
+	for _, state := range e.states {
+		existing[state.rule.Name] = state
+	}
+
+	var events []*Event
+	states := make([]*ruleState, 0, len(rules))
+
+	for _, rule := range rules {
+		if state, ok := existing[rule.Name]; ok && *state.rule == *rule {
+			states = append(states, state)
+			delete(existing, rule.Name)
+			continue
+		}
+
+		states = append(states, &ruleState{rule: rule})
+	}
+
+	for _, state := range e.states {
+		if _, ok := existing[state.rule.Name]; ok && state.firing != nil {
+			events = append(events, &Event{
+				Rule:    state.rule.Name,
+				Kind:    state.rule.Kind,
+				State:   StateResolved,
+				Value:   state.firing.Value,
+				Time:    time.Now(),
+				Message: fmt.Sprintf("%s: rule removed or modified", state.rule.Name),
+			})
+		}
+	}
+
+	e.states = states
+
+	return events
+}
+
+// Active returns the firing events for all rules that are currently firing.
+func (e *Evaluator) Active() []*Event {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var active []*Event
+	for _, state := range e.states {
+		if state.firing != nil {
+			active = append(active, state.firing)
+		}
+	}
+
+	return active
+}
+
+// Observe evaluates all rules against a new observation and returns any resulting transitions.
+func (e *Evaluator) Observe(obs Observation) []*Event {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.start.IsZero() {
+		e.start = obs.Time
+	}
+
+	if obs.Err == nil {
+		e.lastGood = obs.Time
+	}
+
+	var events []*Event
+	for _, state := range e.states {
+		if event := e.evaluate(state, obs); event != nil {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// evaluate applies a single observation to a single rule and returns an event on transition.
+func (e *Evaluator) evaluate(state *ruleState, obs Observation) *Event {
+	active, resolved, value, ok := e.condition(state, obs)
+	if !ok {
+		return nil
+	}
+
+	rule := state.rule
+
+	if state.firing != nil {
+		if !resolved {
+			return nil
+		}
+
+		state.firing = nil
+
+		return &Event{
+			Rule:    rule.Name,
+			Kind:    rule.Kind,
+			State:   StateResolved,
+			Value:   value,
+			Time:    obs.Time,
+			Message: describe(rule, StateResolved, value),
+		}
+	}
+
+	if !active {
+		state.pendingSince = time.Time{}
+		return nil
+	}
+
+	if state.pendingSince.IsZero() {
+		state.pendingSince = obs.Time
+	}
+
+	if obs.Time.Sub(state.pendingSince) < time.Duration(rule.For) {
+		return nil
+	}
+
+	state.pendingSince = time.Time{}
+	state.firing = &Event{
+		Rule:    rule.Name,
+		Kind:    rule.Kind,
+		State:   StateFiring,
+		Value:   value,
+		Time:    obs.Time,
+		Message: describe(rule, StateFiring, value),
+	}
+
+	return state.firing
+}
+
+// condition computes whether a rule's firing condition is met, whether its resolve condition
+// (accounting for hysteresis) is met, and the value under evaluation. The final return value is
+// false if the rule cannot be evaluated against the observation.
+func (e *Evaluator) condition(state *ruleState, obs Observation) (bool, bool, float64, bool) {
+	rule := state.rule
+
+	switch rule.Kind {
+	case KindAbove:
+		if obs.Err != nil {
+			return false, false, 0, false
+		}
+
+		t := obs.Temperature
+		return t > rule.Threshold, t < rule.Threshold-rule.Hysteresis, t, true
+	case KindBelow:
+		if obs.Err != nil {
+			return false, false, 0, false
+		}
+
+		t := obs.Temperature
+		return t < rule.Threshold, t > rule.Threshold+rule.Hysteresis, t, true
+	case KindRateOfChange:
+		if obs.Err != nil {
+			return false, false, 0, false
+		}
+
+		rate, ok := state.rate(obs, time.Duration(rule.Window))
+		if !ok {
+			return false, false, 0, false
+		}
+
+		magnitude := math.Abs(rate)
+		return magnitude > rule.Threshold, magnitude < rule.Threshold-rule.Hysteresis, rate, true
+	case KindStale:
+		baseline := e.lastGood
+		if baseline.IsZero() {
+			baseline = e.start
+		}
+
+		age := obs.Time.Sub(baseline)
+		stale := age > time.Duration(rule.Window)
+		return stale, !stale, age.Seconds(), true
+	case KindDeviceError:
+		failed := obs.Status == schemas.Status_ERROR
+		return failed, !failed, float64(obs.Status), true
+	}
+
+	return false, false, 0, false
+}
+
+// rate records a reading and computes the rate of change, in degrees per minute, across the
+// window. Returns false until at least a full window of readings has been retained.
+func (s *ruleState) rate(obs Observation, window time.Duration) (float64, bool) {
+	s.samples = append(s.samples, sample{time: obs.Time, temperature: obs.Temperature})
+
+	// Retain only the most recent sample at or before the start of the window, so that the oldest
+	// retained sample always spans the full window.
+	boundary := obs.Time.Add(-window)
+	for len(s.samples) > 1 && !s.samples[1].time.After(boundary) {
+		s.samples = s.samples[1:]
+	}
+
+	oldest := s.samples[0]
+	if oldest.time.After(boundary) {
+		return 0, false
+	}
+
+	elapsed := obs.Time.Sub(oldest.time).Minutes()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	return (obs.Temperature - oldest.temperature) / elapsed, true
+}
+
+// describe formats a human-readable message for a rule transition.
+func describe(rule *Rule, state State, value float64) string {
+	switch rule.Kind {
+	case KindAbove:
+		return fmt.Sprintf("%s %s: temperature %.2f C (threshold above %.2f C)", rule.Name, state, value, rule.Threshold)
+	case KindBelow:
+		return fmt.Sprintf("%s %s: temperature %.2f C (threshold below %.2f C)", rule.Name, state, value, rule.Threshold)
+	case KindRateOfChange:
+		return fmt.Sprintf("%s %s: temperature changing at %.2f C/min (threshold %.2f C/min)", rule.Name, state, value, rule.Threshold)
+	case KindStale:
+		return fmt.Sprintf("%s %s: last good reading %.0fs ago", rule.Name, state, value)
+	case KindDeviceError:
+		return fmt.Sprintf("%s %s: device status %s", rule.Name, state, schemas.Status(int32(value)))
+	}
+
+	return fmt.Sprintf("%s %s", rule.Name, state)
+}
@@ -0,0 +1,176 @@
+package alert
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"zephyrus/schemas"
+)
+
+// step is a single observation fed to an evaluator, with the transition expected from it.
+type step struct {
+	// Offset of the observation from the start of the test.
+	at time.Duration
+	// Temperature reading.
+	temperature float64
+	// Whether the reading failed.
+	failed bool
+	// Reported device status.
+	status schemas.Status
+	// Expected transition, or an empty state if none.
+	want State
+}
+
+func TestEvaluatorObserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{
+			name: "above fires immediately without a minimum duration",
+			rule: Rule{Name: "hot", Kind: KindAbove, Threshold: 30},
+			steps: []step{
+				{at: 0, temperature: 29},
+				{at: time.Second, temperature: 31, want: StateFiring},
+				{at: 2 * time.Second, temperature: 32},
+				{at: 3 * time.Second, temperature: 29, want: StateResolved},
+			},
+		},
+		{
+			name: "above resolves only past the hysteresis margin",
+			rule: Rule{Name: "hot", Kind: KindAbove, Threshold: 30, Hysteresis: 2},
+			steps: []step{
+				{at: 0, temperature: 31, want: StateFiring},
+				{at: time.Second, temperature: 29},
+				{at: 2 * time.Second, temperature: 28},
+				{at: 3 * time.Second, temperature: 30.5},
+				{at: 4 * time.Second, temperature: 27.9, want: StateResolved},
+			},
+		},
+		{
+			name: "below resolves only past the hysteresis margin",
+			rule: Rule{Name: "cold", Kind: KindBelow, Threshold: 10, Hysteresis: 1},
+			steps: []step{
+				{at: 0, temperature: 9, want: StateFiring},
+				{at: time.Second, temperature: 10.5},
+				{at: 2 * time.Second, temperature: 11.5, want: StateResolved},
+			},
+		},
+		{
+			name: "above fires after the condition holds for the minimum duration",
+			rule: Rule{Name: "hot", Kind: KindAbove, Threshold: 30, For: Duration(time.Minute)},
+			steps: []step{
+				{at: 0, temperature: 31},
+				{at: 30 * time.Second, temperature: 31},
+				{at: 59 * time.Second, temperature: 31},
+				{at: time.Minute, temperature: 31, want: StateFiring},
+				{at: 2 * time.Minute, temperature: 31},
+			},
+		},
+		{
+			name: "minimum duration restarts when the condition clears",
+			rule: Rule{Name: "hot", Kind: KindAbove, Threshold: 30, For: Duration(time.Minute)},
+			steps: []step{
+				{at: 0, temperature: 31},
+				{at: 50 * time.Second, temperature: 29},
+				{at: 60 * time.Second, temperature: 31},
+				{at: 110 * time.Second, temperature: 31},
+				{at: 120 * time.Second, temperature: 31, want: StateFiring},
+			},
+		},
+		{
+			name: "failed readings neither fire nor resolve temperature rules",
+			rule: Rule{Name: "hot", Kind: KindAbove, Threshold: 30},
+			steps: []step{
+				{at: 0, temperature: 31, want: StateFiring},
+				{at: time.Second, failed: true},
+				{at: 2 * time.Second, temperature: 29, want: StateResolved},
+			},
+		},
+		{
+			name: "rate of change waits for a full window and applies hysteresis",
+			rule: Rule{Name: "rising", Kind: KindRateOfChange, Threshold: 1, Hysteresis: 0.5, Window: Duration(time.Minute)},
+			steps: []step{
+				{at: 0, temperature: 20},
+				{at: 30 * time.Second, temperature: 21},
+				{at: time.Minute, temperature: 22, want: StateFiring},
+				{at: 2 * time.Minute, temperature: 22.8},
+				{at: 3 * time.Minute, temperature: 23.2, want: StateResolved},
+			},
+		},
+		{
+			name: "stale fires when no good reading arrives within the window",
+			rule: Rule{Name: "stale", Kind: KindStale, Window: Duration(time.Minute)},
+			steps: []step{
+				{at: 0, temperature: 20},
+				{at: 30 * time.Second, failed: true},
+				{at: 61 * time.Second, failed: true, want: StateFiring},
+				{at: 90 * time.Second, temperature: 20, want: StateResolved},
+			},
+		},
+		{
+			name: "device error fires on error status",
+			rule: Rule{Name: "error", Kind: KindDeviceError, For: Duration(10 * time.Second)},
+			steps: []step{
+				{at: 0, status: schemas.Status_ERROR},
+				{at: 10 * time.Second, status: schemas.Status_ERROR, want: StateFiring},
+				{at: 20 * time.Second, status: schemas.Status_OPENED, want: StateResolved},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule := test.rule
+			e := NewEvaluator([]*Rule{&rule})
+			start := time.Unix(1577836800, 0)
+
+			for i, s := range test.steps {
+				obs := Observation{Time: start.Add(s.at), Temperature: s.temperature, Status: s.status}
+				if s.failed {
+					obs.Err = errors.New("read failed")
+				}
+
+				events := e.Observe(obs)
+
+				var got State
+				if len(events) > 1 {
+					t.Fatalf("step %d: got %d events, want at most 1", i, len(events))
+				} else if len(events) == 1 {
+					got = events[0].State
+				}
+
+				if got != s.want {
+					t.Fatalf("step %d: got transition %q, want %q", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluatorSetRules(t *testing.T) {
+	hot := &Rule{Name: "hot", Kind: KindAbove, Threshold: 30}
+	e := NewEvaluator([]*Rule{hot})
+	e.Observe(Observation{Time: time.Now(), Temperature: 31})
+
+	if active := e.Active(); len(active) != 1 {
+		t.Fatalf("got %d active alerts, want 1", len(active))
+	}
+
+	// An unchanged rule keeps its state.
+	if events := e.SetRules([]*Rule{{Name: "hot", Kind: KindAbove, Threshold: 30}}); len(events) != 0 {
+		t.Fatalf("got %d events for an unchanged rule, want 0", len(events))
+	}
+
+	// A modified rule resolves and starts over.
+	events := e.SetRules([]*Rule{{Name: "hot", Kind: KindAbove, Threshold: 35}})
+	if len(events) != 1 || events[0].State != StateResolved {
+		t.Fatalf("got events %v for a modified rule, want a single resolve", events)
+	}
+
+	if active := e.Active(); len(active) != 0 {
+		t.Fatalf("got %d active alerts after modifying the rule, want 0", len(active))
+	}
+}
@@ -0,0 +1,139 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Kind describes the condition that an alert rule evaluates.
+type Kind string
+
+const (
+	// KindAbove fires when the temperature rises above the rule threshold.
+	KindAbove Kind = "above"
+	// KindBelow fires when the temperature falls below the rule threshold.
+	KindBelow Kind = "below"
+	// KindRateOfChange fires when the absolute rate of temperature change, in degrees celsius per
+	// minute over the rule window, exceeds the rule threshold.
+	KindRateOfChange Kind = "rate_of_change"
+	// KindStale fires when the sensor has not produced a successful reading within the rule window.
+	KindStale Kind = "stale"
+	// KindDeviceError fires when the device reports an ERROR status.
+	KindDeviceError Kind = "device_error"
+)
+
+// Duration is a time.Duration that is serialized as a human-readable string (e.g. "5m").
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("alert: duration must be a string: %v", err)
+	}
+
+	parsed, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("alert: %v", err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule describes a single alerting condition.
+type Rule struct {
+	// Unique name of the rule.
+	Name string `json:"name"`
+	// Condition evaluated by the rule.
+	Kind Kind `json:"kind"`
+	// Threshold value, in degrees celsius for above/below rules and degrees celsius per minute for
+	// rate of change rules. Unused by other kinds.
+	Threshold float64 `json:"threshold"`
+	// Margin by which the value must recover past the threshold before a firing alert resolves.
+	Hysteresis float64 `json:"hysteresis"`
+	// Minimum amount of time the condition must hold continuously before the alert fires.
+	For Duration `json:"for"`
+	// Observation window for rate of change rules, and the maximum reading age for stale rules.
+	Window Duration `json:"window"`
+}
+
+// Validate checks the rule for semantic correctness.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert: rule name must be specified")
+	}
+
+	switch r.Kind {
+	case KindAbove, KindBelow, KindDeviceError:
+	case KindRateOfChange:
+		if r.Threshold <= 0 {
+			return fmt.Errorf("alert: rule %s: rate of change threshold must be positive", r.Name)
+		}
+
+		if r.Window <= 0 {
+			return fmt.Errorf("alert: rule %s: rate of change window must be positive", r.Name)
+		}
+	case KindStale:
+		if r.Window <= 0 {
+			return fmt.Errorf("alert: rule %s: stale window must be positive", r.Name)
+		}
+	default:
+		return fmt.Errorf("alert: rule %s: unknown kind %q", r.Name, r.Kind)
+	}
+
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("alert: rule %s: hysteresis must be non-negative", r.Name)
+	}
+
+	if r.For < 0 {
+		return fmt.Errorf("alert: rule %s: duration must be non-negative", r.Name)
+	}
+
+	return nil
+}
+
+// ruleFile describes the on-disk format of an alert rules file.
+type ruleFile struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// ParseRules parses and validates a JSON-serialized list of rules.
+func ParseRules(data []byte) ([]*Rule, error) {
+	var file ruleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("alert: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range file.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert: duplicate rule name %s", rule.Name)
+		}
+
+		names[rule.Name] = true
+	}
+
+	return file.Rules, nil
+}
+
+// LoadRules reads and parses rules from a JSON file on disk.
+func LoadRules(path string) ([]*Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("alert: %v", err)
+	}
+
+	return ParseRules(data)
+}
@@ -1,11 +1,16 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strings"
 
 	"zephyrus/schemas"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // ZephyrusClient is an abstraction over a gRPC client to the Zephyrus gRPC server.
@@ -14,24 +19,63 @@ type ZephyrusClient struct {
 	DeviceInfo *DeviceInfoService
 	// Weather provides abstractions for reading and streaming weather information.
 	Weather *WeatherService
+	// Health provides abstractions for checking server health.
+	Health *HealthService
 
 	// The underlying persistent connection to the server.
 	conn *grpc.ClientConn
 }
 
-// NewZephyrusClient creates a new client instance for the server at the specified address.
-func NewZephyrusClient(addr string) (*ZephyrusClient, error) {
-	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+// unixScheme is the prefix of addresses describing Unix domain sockets.
+const unixScheme = "unix:"
+
+// NewZephyrusClient creates a new client instance for the server at the specified address, either
+// a TCP address of the form host:port or a Unix domain socket address of the form
+// unix:///path/to/socket.
+func NewZephyrusClient(addr string, opts ...Option) (*ZephyrusClient, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var dialOpts []grpc.DialOption
+	if o.tls != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(o.tls)))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+
+	if o.token != "" {
+		creds := &tokenCredentials{token: o.token, secure: o.tls != nil}
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
+	}
+
+	if strings.HasPrefix(addr, unixScheme) {
+		path := strings.TrimPrefix(strings.TrimPrefix(addr, unixScheme), "//")
+		dialOpts = append(
+			dialOpts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			}),
+			// The socket path is not a meaningful authority, nor a name to verify a certificate against.
+			grpc.WithAuthority("localhost"),
+		)
+	}
+
+	conn, err := grpc.Dial(addr, dialOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("client: %v", err)
 	}
 
 	deviceInfo := &DeviceInfoService{client: schemas.NewDeviceInfoClient(conn)}
 	weather := &WeatherService{client: schemas.NewWeatherClient(conn)}
+	health := &HealthService{client: healthpb.NewHealthClient(conn)}
 
 	return &ZephyrusClient{
 		DeviceInfo: deviceInfo,
 		Weather:    weather,
+		Health:     health,
 		conn:       conn,
 	}, nil
 }
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+)
+
+// tokenCredentials implements credentials.PerRPCCredentials, attaching a bearer token to every call.
+type tokenCredentials struct {
+	token string
+	// Whether the token may only be sent over a secure connection.
+	secure bool
+}
+
+// GetRequestMetadata returns the authorization header for the call.
+func (c *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+// RequireTransportSecurity reports whether the token may only be sent over a secure connection.
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
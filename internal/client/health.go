@@ -0,0 +1,27 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthService provides client abstractions over the standard gRPC health checking service.
+type HealthService struct {
+	client healthpb.HealthClient
+}
+
+// Check reports whether the server reports the named service as serving. The empty service name
+// describes the server as a whole.
+func (s *HealthService) Check(service string) (bool, error) {
+	ctx := context.Background()
+	req := &healthpb.HealthCheckRequest{Service: service}
+
+	resp, err := s.client.Check(ctx, req)
+	if err != nil {
+		return false, fmt.Errorf("health: %v", err)
+	}
+
+	return resp.Status == healthpb.HealthCheckResponse_SERVING, nil
+}
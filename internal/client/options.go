@@ -0,0 +1,31 @@
+package client
+
+import (
+	"crypto/tls"
+)
+
+// Option configures optional behavior of a ZephyrusClient.
+type Option func(*options)
+
+// options describes all optional client configuration.
+type options struct {
+	// TLS configuration for the connection; the connection is insecure if nil.
+	tls *tls.Config
+	// Bearer token attached to every call, if any.
+	token string
+}
+
+// WithTLS secures the connection to the server with the specified TLS configuration.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithToken attaches a bearer token to every call, for servers requiring token authentication.
+// Note that unless the connection also uses TLS, the token is sent in cleartext.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
@@ -1,7 +1,9 @@
 package client
 
 // TemperatureConsumer describes a type that asynchronously consumes temperature readings. The
-// producer is the gRPC client, via the gRPC server's temperature streaming API.
+// producer is the gRPC client, via the gRPC server's temperature streaming API. Consumers that
+// buffer or deliver readings asynchronously should also implement io.Closer, flushing any pending
+// readings on Close.
 type TemperatureConsumer interface {
 	// Consume a single temperature value, in celsius units.
 	// The consumer may optionally return a non-nil error to abort the streaming operation.
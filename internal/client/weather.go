@@ -32,10 +32,20 @@ func (s *WeatherService) StreamTemperature(sampleRate float64, consumer Temperat
 	return s.StreamTemperatureSamples(sampleRate, 0, consumer)
 }
 
+// StreamTemperatureContext is like StreamTemperature, but stops streaming when the context is done.
+func (s *WeatherService) StreamTemperatureContext(ctx context.Context, sampleRate float64, consumer TemperatureConsumer) error {
+	return s.StreamTemperatureSamplesContext(ctx, sampleRate, 0, consumer)
+}
+
 // StreamTemperatureSamples requests a stream of a specified number of samples at s specified sample
 // rate.
 func (s *WeatherService) StreamTemperatureSamples(sampleRate float64, samples int32, consumer TemperatureConsumer) error {
-	ctx := context.Background()
+	return s.StreamTemperatureSamplesContext(context.Background(), sampleRate, samples, consumer)
+}
+
+// StreamTemperatureSamplesContext is like StreamTemperatureSamples, but stops streaming when the
+// context is done.
+func (s *WeatherService) StreamTemperatureSamplesContext(ctx context.Context, sampleRate float64, samples int32, consumer TemperatureConsumer) error {
 	req := &schemas.GetTemperatureStreamRequest{
 		Samples:    samples,
 		SampleRate: sampleRate,
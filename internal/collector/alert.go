@@ -0,0 +1,264 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"zephyrus/internal/alert"
+)
+
+const (
+	// Interval at which alert rules are evaluated in the absence of readings, so that stale rules
+	// can fire while the stream is down.
+	alertTickInterval = 1 * time.Second
+	// Number of pending notifications buffered for each receiver before further notifications are
+	// dropped.
+	alertQueueSize = 64
+)
+
+// errNoReading is the observation error recorded for evaluation ticks without a new reading.
+var errNoReading = errors.New("no reading")
+
+// AlertConfig describes the alert rules evaluated by the collector and the webhooks notified.
+type AlertConfig struct {
+	// Rules to evaluate against consumed readings.
+	Rules []*alert.Rule `json:"rules"`
+	// Webhook receivers notified of every fire and resolve event.
+	Webhooks []*WebhookReceiver `json:"webhooks"`
+}
+
+// LoadAlertConfig reads and validates an alert configuration from a JSON file on disk.
+func LoadAlertConfig(path string) (*AlertConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("alert: %v", err)
+	}
+
+	rules, err := alert.ParseRules(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg AlertConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("alert: %v", err)
+	}
+
+	cfg.Rules = rules
+
+	for _, rule := range cfg.Rules {
+		// The collector only observes temperatures, not the device status.
+		if rule.Kind == alert.KindDeviceError {
+			return nil, fmt.Errorf("alert: rule %s: %s rules are only supported by the server", rule.Name, rule.Kind)
+		}
+	}
+
+	for _, receiver := range cfg.Webhooks {
+		if err := receiver.init(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cfg, nil
+}
+
+// AlertWebhookConsumer is a consumer implementing the client.TemperatureConsumer interface for
+// evaluating alert rules against consumed temperatures and notifying webhooks of fire and resolve
+// events. Notifications are delivered asynchronously, by a separate worker for each receiver, so
+// that slow or unreachable receivers block neither the stream nor each other.
+type AlertWebhookConsumer struct {
+	// Device identifier included in all notifications.
+	identifier string
+	// Rule evaluator.
+	evaluator *alert.Evaluator
+	// Delivery workers, by receiver URL. Keying by URL preserves the state of receivers that survive
+	// a reload.
+	workers map[string]*webhookWorker
+	// Whether the consumer is closed.
+	closed bool
+	// Mutex used to synchronize access to the workers and closed state across reloads.
+	mutex sync.RWMutex
+	// HTTP client used for webhook delivery.
+	http *http.Client
+	// Channel closed to stop the evaluation ticker and abandon delivery retries.
+	done chan bool
+	// Wait group tracking background goroutines.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+}
+
+// webhookWorker delivers notifications to a single receiver, in order.
+type webhookWorker struct {
+	// Receiver notified, replaced on reload. Guarded by the consumer mutex.
+	receiver *WebhookReceiver
+	// Pending notifications.
+	queue chan *alert.Event
+	// Last delivered state for each rule, used to suppress duplicate notifications. Only accessed
+	// by the worker goroutine.
+	delivered map[string]alert.State
+}
+
+// NewAlertWebhookConsumer creates a new alert consumer using the specified device identifier and
+// alert configuration.
+func NewAlertWebhookConsumer(deviceIdentifier string, cfg *AlertConfig) *AlertWebhookConsumer {
+	c := &AlertWebhookConsumer{
+		identifier: deviceIdentifier,
+		evaluator:  alert.NewEvaluator(cfg.Rules),
+		workers:    make(map[string]*webhookWorker),
+		http:       &http.Client{Timeout: webhookRequestTimeout},
+		done:       make(chan bool),
+	}
+
+	c.mutex.Lock()
+	c.setWebhooks(cfg.Webhooks)
+	c.mutex.Unlock()
+
+	c.wg.Add(1)
+	go c.tick()
+
+	return c
+}
+
+// Consume evaluates alert rules against the passed temperature and enqueues any notifications.
+func (c *AlertWebhookConsumer) Consume(temperature float64) error {
+	c.enqueue(c.evaluator.Observe(alert.Observation{
+		Time:        time.Now(),
+		Temperature: temperature,
+	}))
+
+	return nil
+}
+
+// Reload replaces the alert rules and webhook receivers. Rules that are unchanged keep their state;
+// firing rules that are removed or changed are resolved, and receivers still configured are notified.
+func (c *AlertWebhookConsumer) Reload(cfg *AlertConfig) {
+	c.mutex.Lock()
+	if !c.closed {
+		c.setWebhooks(cfg.Webhooks)
+	}
+	c.mutex.Unlock()
+
+	c.enqueue(c.evaluator.SetRules(cfg.Rules))
+
+	log.Printf("alert: reloaded configuration: rules=%d webhooks=%d", len(cfg.Rules), len(cfg.Webhooks))
+}
+
+// Close stops rule evaluation and waits for pending notifications to be delivered. Failed
+// deliveries are no longer retried once the consumer is closed.
+func (c *AlertWebhookConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mutex.Lock()
+		c.closed = true
+		c.setWebhooks(nil)
+		c.mutex.Unlock()
+
+		c.wg.Wait()
+	})
+
+	return nil
+}
+
+// setWebhooks starts a worker for each new receiver, and stops the workers of receivers that are no
+// longer configured once they have delivered their pending notifications. The mutex must be held.
+func (c *AlertWebhookConsumer) setWebhooks(webhooks []*WebhookReceiver) {
+	workers := make(map[string]*webhookWorker, len(webhooks))
+
+	for _, receiver := range webhooks {
+		worker, ok := c.workers[receiver.URL]
+		if !ok {
+			worker = &webhookWorker{
+				queue:     make(chan *alert.Event, alertQueueSize),
+				delivered: make(map[string]alert.State),
+			}
+
+			c.wg.Add(1)
+			go c.deliver(worker)
+		}
+
+		worker.receiver = receiver
+		workers[receiver.URL] = worker
+		delete(c.workers, receiver.URL)
+	}
+
+	for _, worker := range c.workers {
+		close(worker.queue)
+	}
+
+	c.workers = workers
+}
+
+// tick periodically evaluates rules without a reading, until the consumer is closed.
+func (c *AlertWebhookConsumer) tick() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(alertTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.enqueue(c.evaluator.Observe(alert.Observation{Time: now, Err: errNoReading}))
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// enqueue adds events to the notification queue of every receiver without blocking.
+func (c *AlertWebhookConsumer) enqueue(events []*alert.Event) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, event := range events {
+		log.Printf("alert: device=%s %s", c.identifier, event.Message)
+
+		if c.closed {
+			return
+		}
+
+		for url, worker := range c.workers {
+			select {
+			case worker.queue <- event:
+			default:
+				log.Printf("alert: notification queue full; dropping event: rule=%s receiver=%s", event.Rule, url)
+			}
+		}
+	}
+}
+
+// deliver delivers queued notifications to a single receiver until its queue is closed.
+func (c *AlertWebhookConsumer) deliver(worker *webhookWorker) {
+	defer c.wg.Done()
+
+	for event := range worker.queue {
+		// Suppress notifications that would repeat the last state delivered to this receiver.
+		if last, ok := worker.delivered[event.Rule]; ok && last == event.State {
+			continue
+		}
+
+		// A resolve for a rule never reported as firing carries no information.
+		if _, ok := worker.delivered[event.Rule]; !ok && event.State == alert.StateResolved {
+			continue
+		}
+
+		c.mutex.RLock()
+		receiver := worker.receiver
+		c.mutex.RUnlock()
+
+		if err := receiver.deliver(c.http, newWebhookPayload(c.identifier, event), c.done); err != nil {
+			log.Printf("alert: failed to deliver notification: rule=%s error=%v", event.Rule, err)
+			continue
+		}
+
+		worker.delivered[event.Rule] = event.State
+	}
+}
@@ -1,8 +1,6 @@
 package collector
 
 import (
-	"fmt"
-
 	"lib.kevinlin.info/aperture"
 )
 
@@ -16,25 +14,35 @@ type TemperatureStatsdConsumer struct {
 	client aperture.Statsd
 	// Device identifier to attach as a tag to all emitted metrics.
 	identifier string
+	// Whether the consumer owns, and should close, the statsd client.
+	owned bool
 }
 
-// NewTemperatureStatsdConsumer creates a new statsd consumer using the specified device identifier
-// and remote statsd address.
-func NewTemperatureStatsdConsumer(deviceIdentifier string, addr string) (*TemperatureStatsdConsumer, error) {
-	client, err := aperture.NewClient(&aperture.Config{
-		Address: addr,
-		Prefix:  GlobalMetricNamespace,
-	})
+// NewTemperatureStatsdConsumer creates a new statsd consumer using the specified device identifier,
+// remote statsd address, and tag format.
+func NewTemperatureStatsdConsumer(deviceIdentifier string, addr string, format TagFormat) (*TemperatureStatsdConsumer, error) {
+	client, err := newStatsdBackend(addr, format)
 	if err != nil {
-		return nil, fmt.Errorf("consumer: %v", err)
+		return nil, err
 	}
 
 	return &TemperatureStatsdConsumer{
 		client:     client,
 		identifier: deviceIdentifier,
+		owned:      true,
 	}, nil
 }
 
+// NewTemperatureStatsdConsumerWithClient creates a new statsd consumer using the specified device
+// identifier and a statsd client shared with other consumers. The client is not closed with the
+// consumer.
+func NewTemperatureStatsdConsumerWithClient(deviceIdentifier string, client aperture.Statsd) *TemperatureStatsdConsumer {
+	return &TemperatureStatsdConsumer{
+		client:     client,
+		identifier: deviceIdentifier,
+	}
+}
+
 // Consume ships the passed temperature to statsd as a gauge with properly formatted names and tags.
 func (c *TemperatureStatsdConsumer) Consume(temperature float64) error {
 	metric := "collector.temperature"
@@ -46,3 +54,13 @@ func (c *TemperatureStatsdConsumer) Consume(temperature float64) error {
 
 	return nil
 }
+
+// Close flushes and closes the underlying statsd client, if it is owned by the consumer and
+// supports doing so.
+func (c *TemperatureStatsdConsumer) Close() error {
+	if !c.owned {
+		return nil
+	}
+
+	return closeStatsd(c.client)
+}
@@ -0,0 +1,621 @@
+package collector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"lib.kevinlin.info/aperture"
+)
+
+const (
+	// Size of a queued reading on disk: a timestamp in nanoseconds and a temperature, both 64 bits.
+	diskQueueRecordSize = 16
+	// Number of segments among which the maximum size of a queue is divided. The oldest segment is
+	// dropped when the queue exceeds its maximum size.
+	diskQueueSegments = 8
+	// Lower bound of the size of a segment.
+	diskQueueMinSegmentSize = 256 * diskQueueRecordSize
+	// Maximum number of readings replayed to the sink at once.
+	diskQueueBatchSize = 100
+	// Interval at which queue metrics are emitted while the queue is idle or waiting to retry.
+	diskQueueMetricsInterval = 10 * time.Second
+	// Extension of segment files.
+	diskQueueSegmentExt = ".wal"
+	// Name of the file recording the position of the oldest pending reading.
+	diskQueueCursorFile = "cursor"
+	// Name of the file locked while the queue is open.
+	diskQueueLockFile = "lock"
+)
+
+// DefaultDiskQueueMaxSize is the default maximum size of each disk queue, in bytes.
+const DefaultDiskQueueMaxSize = 100 * 1024 * 1024
+
+// Reading is a temperature with the time at which it was consumed.
+type Reading struct {
+	// Temperature, in degrees Celsius.
+	Temperature float64
+	// Time at which the temperature was consumed.
+	Timestamp time.Time
+}
+
+// BatchConsumer is implemented by consumers that can deliver readings with their original
+// timestamps synchronously, reporting whether delivery succeeded, so that they can be fed from a
+// DiskQueue.
+type BatchConsumer interface {
+	// ConsumeBatch delivers the readings, in order, returning an error if they were not delivered.
+	ConsumeBatch(readings []Reading) error
+}
+
+// DiskQueueConfig describes a disk-backed write-ahead queue.
+type DiskQueueConfig struct {
+	// Directory under which queues are stored, in a subdirectory per sink, server, and device.
+	Dir string
+	// Size in bytes beyond which the oldest pending readings are dropped.
+	MaxSize int64
+	// Policy for retrying after the sink fails.
+	Retry RetryPolicy
+	// Address of the server supplying readings, attached as a tag to metrics.
+	Server string
+	// Client to which queue metrics are emitted. Optional.
+	Statsd aperture.Statsd
+}
+
+// Validate returns an error if the configuration is not usable.
+func (c *DiskQueueConfig) Validate() error {
+	if c.Dir == "" {
+		return errors.New("diskqueue: directory must be specified")
+	}
+
+	if c.MaxSize < diskQueueSegments*diskQueueMinSegmentSize {
+		return fmt.Errorf("diskqueue: maximum size must be at least %d bytes", diskQueueSegments*diskQueueMinSegmentSize)
+	}
+
+	return c.Retry.Validate()
+}
+
+// diskQueueSegment is a file of queued readings.
+type diskQueueSegment struct {
+	// Sequence number, from which the file is named.
+	seq uint64
+	// Size of the file.
+	size int64
+}
+
+// DiskQueue is a consumer implementing the client.TemperatureConsumer interface for delivering
+// consumed temperatures to a BatchConsumer through a write-ahead queue on disk. Every reading is
+// appended to the queue with its timestamp, and replayed to the sink in order until it is
+// delivered, retrying with backoff while the sink fails. Pending readings survive restarts, and
+// the oldest are dropped when the queue exceeds its maximum size.
+type DiskQueue struct {
+	// Device identifier and sink name, attached as tags to metrics.
+	identifier string
+	sink       string
+	// Directory of the queue.
+	dir string
+	// Queue configuration.
+	cfg DiskQueueConfig
+	// Size beyond which a new segment is started.
+	segmentSize int64
+	// Sink to which readings are replayed.
+	consumer BatchConsumer
+	// Segments, oldest first. The last is open for appending.
+	segments []diskQueueSegment
+	// Offset of the oldest pending reading in the first segment.
+	head int64
+	// Total size of all segments.
+	size int64
+	// Segment open for appending.
+	tail *os.File
+	// Lock file, held while the queue is open.
+	lock *os.File
+	// Mutex used to synchronize access to the segments.
+	mutex sync.Mutex
+	// Channel signaled when readings are appended.
+	pending chan bool
+	// Channel closed to stop replaying.
+	done chan bool
+	// Wait group tracking the replaying goroutine.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+}
+
+// diskQueuePathSanitizer replaces characters that would introduce path components.
+var diskQueuePathSanitizer = strings.NewReplacer("/", "_", `\`, "_", "..", "_")
+
+// NewDiskQueue creates a new disk queue for readings from the device with the specified
+// identifier to the named sink, replaying any readings left pending by a previous run.
+func NewDiskQueue(deviceIdentifier string, sink string, cfg *DiskQueueConfig, consumer BatchConsumer) (*DiskQueue, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	segmentSize := cfg.MaxSize / diskQueueSegments
+	segmentSize -= segmentSize % diskQueueRecordSize
+
+	// Servers may report the same device identifier, so each has its own queue.
+	dir := filepath.Join(
+		cfg.Dir,
+		diskQueuePathSanitizer.Replace(sink),
+		diskQueuePathSanitizer.Replace(cfg.Server),
+		diskQueuePathSanitizer.Replace(deviceIdentifier),
+	)
+
+	q := &DiskQueue{
+		identifier:  deviceIdentifier,
+		sink:        sink,
+		dir:         dir,
+		cfg:         *cfg,
+		segmentSize: segmentSize,
+		consumer:    consumer,
+		pending:     make(chan bool, 1),
+		done:        make(chan bool),
+	}
+
+	if err := q.open(); err != nil {
+		if q.lock != nil {
+			q.lock.Close()
+		}
+
+		return nil, fmt.Errorf("diskqueue: %v", err)
+	}
+
+	if depth := q.depth(); depth > 0 {
+		log.Printf("diskqueue: replaying pending readings: sink=%s device=%s pending=%d", sink, deviceIdentifier, depth)
+		q.pending <- true
+	}
+
+	q.wg.Add(1)
+	go q.run()
+
+	return q, nil
+}
+
+// Consume appends the passed temperature, timestamped now, to the queue.
+func (q *DiskQueue) Consume(temperature float64) error {
+	var record [diskQueueRecordSize]byte
+	binary.LittleEndian.PutUint64(record[:8], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(record[8:], math.Float64bits(temperature))
+
+	if err := q.append(record[:]); err != nil {
+		return fmt.Errorf("diskqueue: %v", err)
+	}
+
+	select {
+	case q.pending <- true:
+	default:
+	}
+
+	return nil
+}
+
+// SetStreamUp notifies the sink, if it observes the stream status.
+func (q *DiskQueue) SetStreamUp(up bool) {
+	if observer, ok := q.consumer.(StreamStatusObserver); ok {
+		observer.SetStreamUp(up)
+	}
+}
+
+// Close stops replaying and closes the queue, leaving pending readings on disk to be replayed on
+// the next run. The sink is closed if it supports it.
+func (q *DiskQueue) Close() error {
+	var err error
+
+	q.closeOnce.Do(func() {
+		close(q.done)
+		q.wg.Wait()
+
+		q.mutex.Lock()
+		if err = q.tail.Sync(); err == nil {
+			err = q.tail.Close()
+		}
+		q.lock.Close()
+		q.mutex.Unlock()
+
+		if closer, ok := q.consumer.(io.Closer); ok {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+
+	return err
+}
+
+// open locks the queue and loads its segments and cursor from disk, creating the directory and
+// first segment if necessary, and opens the last segment for appending.
+func (q *DiskQueue) open() error {
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+
+	if err := q.acquireLock(); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, diskQueueSegmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, diskQueueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// A partial record left by a crash is discarded.
+		size := file.Size() - file.Size()%diskQueueRecordSize
+		if size != file.Size() {
+			if err := os.Truncate(q.segmentPath(seq), size); err != nil {
+				return err
+			}
+		}
+
+		q.segments = append(q.segments, diskQueueSegment{seq: seq, size: size})
+		q.size += size
+	}
+
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+
+	seq, offset := q.readCursor()
+
+	// Segments preceding the cursor were delivered, but not deleted before the previous run ended.
+	for len(q.segments) > 1 && q.segments[0].seq < seq {
+		q.dropFirst()
+	}
+
+	if len(q.segments) > 0 && q.segments[0].seq == seq && offset <= q.segments[0].size {
+		q.head = offset
+	}
+
+	if len(q.segments) == 0 {
+		q.segments = []diskQueueSegment{{seq: 1}}
+	}
+
+	q.tail, err = os.OpenFile(q.segmentPath(q.segments[len(q.segments)-1].seq), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	return err
+}
+
+// append appends a record to the last segment, starting a new segment if it is full and dropping
+// the oldest segment if the queue exceeds its maximum size.
+func (q *DiskQueue) append(record []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	last := &q.segments[len(q.segments)-1]
+
+	if last.size >= q.segmentSize {
+		if err := q.tail.Sync(); err != nil {
+			return err
+		}
+
+		if err := q.tail.Close(); err != nil {
+			return err
+		}
+
+		seq := last.seq + 1
+
+		tail, err := os.OpenFile(q.segmentPath(seq), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			// Reopen the full segment, so that appending can be retried.
+			q.tail, _ = os.OpenFile(q.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0644)
+			return err
+		}
+
+		q.tail = tail
+		q.segments = append(q.segments, diskQueueSegment{seq: seq})
+		last = &q.segments[len(q.segments)-1]
+	}
+
+	n, err := q.tail.Write(record)
+	if err != nil {
+		// Discard a partial record, so that later records remain aligned.
+		if n > 0 {
+			q.tail.Truncate(last.size)
+		}
+
+		return err
+	}
+
+	last.size += diskQueueRecordSize
+	q.size += diskQueueRecordSize
+
+	for q.size > q.cfg.MaxSize && len(q.segments) > 1 {
+		dropped := (q.segments[0].size - q.head) / diskQueueRecordSize
+
+		log.Printf("diskqueue: queue full; dropping oldest readings: sink=%s device=%s dropped=%d", q.sink, q.identifier, dropped)
+		q.count("collector.queue.dropped", dropped)
+
+		q.dropFirst()
+		q.head = 0
+		q.writeCursor()
+	}
+
+	return nil
+}
+
+// peek reads up to the specified number of the oldest pending readings, returning them with the
+// sequence number of the segment from which they were read.
+func (q *DiskQueue) peek(max int) ([]Reading, uint64, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// The first segment may have been delivered before the next was started, or by a previous run.
+	if q.dropDelivered() {
+		q.writeCursor()
+	}
+
+	first := q.segments[0]
+
+	n := (first.size - q.head) / diskQueueRecordSize
+	if n > int64(max) {
+		n = int64(max)
+	}
+
+	if n == 0 {
+		return nil, first.seq, nil
+	}
+
+	file, err := os.Open(q.segmentPath(first.seq))
+	if err != nil {
+		return nil, first.seq, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, n*diskQueueRecordSize)
+	if _, err := file.ReadAt(buf, q.head); err != nil {
+		return nil, first.seq, err
+	}
+
+	readings := make([]Reading, n)
+	for i := range readings {
+		record := buf[i*diskQueueRecordSize:]
+		readings[i] = Reading{
+			Timestamp:   time.Unix(0, int64(binary.LittleEndian.Uint64(record[:8]))),
+			Temperature: math.Float64frombits(binary.LittleEndian.Uint64(record[8:16])),
+		}
+	}
+
+	return readings, first.seq, nil
+}
+
+// ack marks the specified number of readings, read from the segment with the specified sequence
+// number, as delivered, deleting the segment if it has been fully delivered and is not the last.
+func (q *DiskQueue) ack(seq uint64, n int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// The segment was dropped while the readings were delivered.
+	if q.segments[0].seq != seq {
+		return
+	}
+
+	q.head += int64(n) * diskQueueRecordSize
+	q.dropDelivered()
+	q.writeCursor()
+}
+
+// dropDelivered deletes leading segments that have been fully delivered, other than the last,
+// returning whether any were deleted. The mutex must be held.
+func (q *DiskQueue) dropDelivered() bool {
+	dropped := false
+
+	for len(q.segments) > 1 && q.head == q.segments[0].size {
+		q.dropFirst()
+		q.head = 0
+		dropped = true
+	}
+
+	return dropped
+}
+
+// depth returns the number of pending readings.
+func (q *DiskQueue) depth() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return (q.size - q.head) / diskQueueRecordSize
+}
+
+// run replays pending readings to the sink in batches, retrying with backoff while it fails, until
+// the queue is closed.
+func (q *DiskQueue) run() {
+	defer q.wg.Done()
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	failures := 0
+
+	ticker := time.NewTicker(diskQueueMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		readings, seq, err := q.peek(diskQueueBatchSize)
+		if err != nil {
+			log.Printf("diskqueue: failed to read queue: sink=%s device=%s error=%v", q.sink, q.identifier, err)
+		}
+
+		q.emitMetrics(readings)
+
+		if len(readings) == 0 {
+			select {
+			case <-q.pending:
+				continue
+			case <-ticker.C:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+
+		if err = q.consumer.ConsumeBatch(readings); err == nil {
+			if failures > 0 {
+				log.Printf("diskqueue: sink recovered; replaying pending readings: sink=%s device=%s pending=%d", q.sink, q.identifier, q.depth())
+				failures = 0
+			}
+
+			q.ack(seq, len(readings))
+
+			select {
+			case <-q.done:
+				return
+			default:
+				continue
+			}
+		}
+
+		if failures++; failures == 1 {
+			log.Printf("diskqueue: sink failed; queueing readings: sink=%s device=%s error=%v", q.sink, q.identifier, err)
+		}
+
+		retry := time.After(q.cfg.Retry.interval(failures, circuitClosed, rng))
+
+	wait:
+		for {
+			select {
+			case <-retry:
+				break wait
+			case <-ticker.C:
+				q.emitMetrics(readings)
+			case <-q.done:
+				return
+			}
+		}
+	}
+}
+
+// emitMetrics emits the queue depth and the age of the oldest pending reading, which is the first
+// of the specified pending readings, if any.
+func (q *DiskQueue) emitMetrics(pending []Reading) {
+	if q.cfg.Statsd == nil {
+		return
+	}
+
+	age := 0.0
+	if len(pending) > 0 {
+		age = time.Since(pending[0].Timestamp).Seconds()
+	}
+
+	q.cfg.Statsd.Gauge("collector.queue.depth", float64(q.depth()), q.tags())
+	q.cfg.Statsd.Gauge("collector.queue.oldest_age", age, q.tags())
+}
+
+// count emits a counter metric, if a statsd client is configured.
+func (q *DiskQueue) count(metric string, value int64) {
+	if q.cfg.Statsd != nil {
+		q.cfg.Statsd.Count(metric, value, q.tags())
+	}
+}
+
+// tags returns the tags attached to queue metrics.
+func (q *DiskQueue) tags() map[string]interface{} {
+	tags := map[string]interface{}{"sink": q.sink, "device": q.identifier}
+	if q.cfg.Server != "" {
+		tags["server"] = q.cfg.Server
+	}
+
+	return tags
+}
+
+// acquireLock takes an exclusive lock on the queue directory, failing if it is held by another
+// queue, e.g. of a collection that is still shutting down. The lock is released when the lock file
+// is closed, including when the process exits.
+func (q *DiskQueue) acquireLock() error {
+	lock, err := os.OpenFile(filepath.Join(q.dir, diskQueueLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lock.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			return fmt.Errorf("queue is already open: dir=%s", q.dir)
+		}
+
+		return err
+	}
+
+	q.lock = lock
+
+	return nil
+}
+
+// dropFirst deletes the first segment.
+func (q *DiskQueue) dropFirst() {
+	first := q.segments[0]
+
+	if err := os.Remove(q.segmentPath(first.seq)); err != nil && !os.IsNotExist(err) {
+		log.Printf("diskqueue: failed to delete segment: sink=%s device=%s error=%v", q.sink, q.identifier, err)
+	}
+
+	q.segments = q.segments[1:]
+	q.size -= first.size
+}
+
+// segmentPath returns the path of the segment with the specified sequence number.
+func (q *DiskQueue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, diskQueueSegmentExt))
+}
+
+// readCursor returns the sequence number of the first segment and the offset of the oldest pending
+// reading within it, as recorded on disk. Both are 0 if no cursor was recorded.
+func (q *DiskQueue) readCursor() (uint64, int64) {
+	data, err := ioutil.ReadFile(filepath.Join(q.dir, diskQueueCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, 0
+	}
+
+	seq, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0
+	}
+
+	offset, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || offset%diskQueueRecordSize != 0 {
+		return 0, 0
+	}
+
+	return seq, offset
+}
+
+// writeCursor records the position of the oldest pending reading on disk. The cursor is replaced
+// atomically, so that it is never partially written.
+func (q *DiskQueue) writeCursor() {
+	path := filepath.Join(q.dir, diskQueueCursorFile)
+	data := fmt.Sprintf("%d %d\n", q.segments[0].seq, q.head)
+
+	err := ioutil.WriteFile(path+".tmp", []byte(data), 0644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		log.Printf("diskqueue: failed to record cursor: sink=%s device=%s error=%v", q.sink, q.identifier, err)
+	}
+}
@@ -0,0 +1,230 @@
+package collector
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batchRecorder is a BatchConsumer recording the temperatures it delivers. It fails every batch
+// while failing is set.
+type batchRecorder struct {
+	// Whether batches fail.
+	failing bool
+	// Delivered temperatures, in order.
+	temperatures []float64
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func (r *batchRecorder) ConsumeBatch(readings []Reading) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failing {
+		return errors.New("sink unavailable")
+	}
+
+	for _, reading := range readings {
+		r.temperatures = append(r.temperatures, reading.Temperature)
+	}
+
+	return nil
+}
+
+func (r *batchRecorder) setFailing(failing bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.failing = failing
+}
+
+// delivered returns the delivered temperatures.
+func (r *batchRecorder) delivered() []float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]float64(nil), r.temperatures...)
+}
+
+// newTestDiskQueueConfig returns a configuration of the smallest queue in the directory, with
+// segments of 256 readings, retrying quickly.
+func newTestDiskQueueConfig(dir string) *DiskQueueConfig {
+	return &DiskQueueConfig{
+		Dir:     dir,
+		MaxSize: diskQueueSegments * diskQueueMinSegmentSize,
+		Retry: RetryPolicy{
+			InitialInterval:  10 * time.Millisecond,
+			MaxInterval:      10 * time.Millisecond,
+			Multiplier:       1,
+			FailureThreshold: 1,
+		},
+	}
+}
+
+func newTestDiskQueue(t *testing.T, cfg *DiskQueueConfig, consumer BatchConsumer) *DiskQueue {
+	q, err := NewDiskQueue("temper", "test", cfg, consumer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q
+}
+
+// consumeRange consumes the temperatures from start up to, but excluding, end.
+func consumeRange(t *testing.T, q *DiskQueue, start int, end int) {
+	for i := start; i < end; i++ {
+		if err := q.Consume(float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertDelivered checks that the temperatures from start up to, but excluding, end were
+// delivered, in order.
+func assertDelivered(t *testing.T, got []float64, start int, end int) {
+	t.Helper()
+
+	if len(got) != end-start {
+		t.Fatalf("got %d delivered readings, want %d", len(got), end-start)
+	}
+
+	for i, temperature := range got {
+		if temperature != float64(start+i) {
+			t.Fatalf("got reading %v at %d, want %d", temperature, i, start+i)
+		}
+	}
+}
+
+func TestDiskQueueReplaysAcrossSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recorder := &batchRecorder{}
+	q := newTestDiskQueue(t, newTestDiskQueueConfig(dir), recorder)
+	defer q.Close()
+
+	// The first segment is fully delivered while it is the only one.
+	consumeRange(t, q, 0, 256)
+	waitFor(t, "the first segment", func() bool { return len(recorder.delivered()) == 256 })
+
+	consumeRange(t, q, 256, 600)
+	waitFor(t, "the later segments", func() bool { return len(recorder.delivered()) == 600 })
+
+	assertDelivered(t, recorder.delivered(), 0, 600)
+}
+
+func TestDiskQueueReplaysAfterRestart(t *testing.T) {
+	tests := []struct {
+		name string
+		// Number of readings delivered, then queued, before restarting.
+		delivered int
+		queued    int
+	}{
+		{name: "within a segment", delivered: 5, queued: 5},
+		{name: "across segments", delivered: 200, queued: 300},
+		{name: "at the end of a segment", delivered: 256, queued: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "diskqueue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			cfg := newTestDiskQueueConfig(dir)
+
+			recorder := &batchRecorder{}
+			q := newTestDiskQueue(t, cfg, recorder)
+
+			consumeRange(t, q, 0, test.delivered)
+			waitFor(t, "delivery", func() bool { return len(recorder.delivered()) == test.delivered })
+
+			recorder.setFailing(true)
+			consumeRange(t, q, test.delivered, test.delivered+test.queued)
+			q.Close()
+
+			// Only the readings after the cursor are replayed, followed by new readings.
+			end := test.delivered + test.queued + 1
+
+			recorder = &batchRecorder{}
+			q = newTestDiskQueue(t, cfg, recorder)
+			defer q.Close()
+
+			consumeRange(t, q, end-1, end)
+			waitFor(t, "replay", func() bool { return len(recorder.delivered()) >= test.queued+1 })
+
+			assertDelivered(t, recorder.delivered(), test.delivered, end)
+		})
+	}
+}
+
+func TestDiskQueueDropsOldestWhenFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := newTestDiskQueueConfig(dir)
+	capacity := int(cfg.MaxSize / diskQueueRecordSize)
+
+	recorder := &batchRecorder{failing: true}
+	q := newTestDiskQueue(t, cfg, recorder)
+
+	consumeRange(t, q, 0, capacity+100)
+
+	if depth := q.depth(); depth > int64(capacity) {
+		t.Errorf("got depth %d, want at most %d", depth, capacity)
+	}
+
+	recorder.setFailing(false)
+	waitFor(t, "replay", func() bool { return q.depth() == 0 })
+	q.Close()
+
+	// The oldest segment is dropped, and the newest readings are retained.
+	assertDelivered(t, recorder.delivered(), 256, capacity+100)
+}
+
+func TestDiskQueueOpenedTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := newTestDiskQueueConfig(dir)
+	cfg.Server = "sensor-a:6840"
+
+	q := newTestDiskQueue(t, cfg, &batchRecorder{})
+
+	// The queue of the same sink, server, and device is locked while it is open.
+	if _, err := NewDiskQueue("temper", "test", cfg, &batchRecorder{}); err == nil {
+		t.Error("opened the queue twice, want an error")
+	}
+
+	// Another server reporting the same device identifier has its own queue.
+	other := *cfg
+	other.Server = "sensor-b:6840"
+
+	otherQueue := newTestDiskQueue(t, &other, &batchRecorder{})
+	defer otherQueue.Close()
+
+	if q.dir == otherQueue.dir {
+		t.Errorf("got the same directory %s for both servers", q.dir)
+	}
+
+	// The lock is released on close.
+	q.Close()
+
+	q = newTestDiskQueue(t, cfg, &batchRecorder{})
+	q.Close()
+}
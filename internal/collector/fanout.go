@@ -0,0 +1,315 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zephyrus/internal/client"
+
+	"lib.kevinlin.info/aperture"
+)
+
+// DefaultSinkQueueSize is the default number of readings queued for each sink.
+const DefaultSinkQueueSize = 1000
+
+// SinkErrorPolicy determines how a FanOutConsumer handles errors returned by its sinks.
+type SinkErrorPolicy string
+
+const (
+	// SinkErrorIgnore counts and logs sink errors without affecting the stream.
+	SinkErrorIgnore SinkErrorPolicy = "ignore"
+	// SinkErrorAbort additionally fails the stream on its next reading, so that it reconnects.
+	SinkErrorAbort SinkErrorPolicy = "abort"
+)
+
+// ParseSinkErrorPolicy parses the name of a sink error policy.
+func ParseSinkErrorPolicy(name string) (SinkErrorPolicy, error) {
+	switch policy := SinkErrorPolicy(strings.ToLower(name)); policy {
+	case SinkErrorIgnore, SinkErrorAbort:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("fanout: unknown sink error policy %q; use ignore or abort", name)
+	}
+}
+
+// Sink is a named consumer to which a FanOutConsumer dispatches readings.
+type Sink struct {
+	// Name of the sink, attached as a tag to its metrics.
+	Name string
+	// Consumer of readings. If it implements io.Closer, it is closed with the FanOutConsumer, and if
+	// it implements StreamStatusObserver, it is notified when the stream goes up or down.
+	Consumer client.TemperatureConsumer
+}
+
+// FanOutConfig describes how a FanOutConsumer dispatches readings to its sinks.
+type FanOutConfig struct {
+	// Number of readings queued for each sink, beyond which further readings are dropped.
+	QueueSize int
+	// How sink errors are handled.
+	ErrorPolicy SinkErrorPolicy
+	// Maximum amount of time to wait on close for sinks to consume their queued readings, after
+	// which the remaining readings are abandoned. Unlimited if 0.
+	DrainTimeout time.Duration
+	// Address of the server supplying readings, attached as a tag to metrics.
+	Server string
+	// Client to which drop and error counters are emitted. Optional.
+	Statsd aperture.Statsd
+}
+
+// Validate returns an error if the configuration is not usable.
+func (c *FanOutConfig) Validate() error {
+	if c.QueueSize < 1 {
+		return errors.New("fanout: queue size must be at least 1")
+	}
+
+	_, err := ParseSinkErrorPolicy(string(c.ErrorPolicy))
+
+	return err
+}
+
+// SinkStats are the cumulative counters of a sink.
+type SinkStats struct {
+	// Number of readings dropped because the sink's queue was full.
+	Dropped uint64
+	// Number of readings the sink failed to consume.
+	Errors uint64
+}
+
+// FanOutConsumer is a consumer implementing the client.TemperatureConsumer interface for
+// dispatching each consumed temperature to several sinks concurrently. Every sink consumes from
+// its own bounded queue in its own goroutine, so that a slow or failing sink delays neither the
+// stream nor the other sinks.
+type FanOutConsumer struct {
+	// Device identifier, attached as a tag to metrics.
+	identifier string
+	// Dispatch configuration.
+	cfg FanOutConfig
+	// Sinks, in the order in which they were specified.
+	sinks []*fanOutSink
+	// First sink error not yet returned from Consume, if the policy is SinkErrorAbort.
+	err error
+	// Whether the consumer is closed.
+	closed bool
+	// Mutex used to synchronize access to the pending error, closed state, and dropping state of
+	// sinks.
+	mutex sync.Mutex
+	// Channel closed to stop sinks from consuming queued readings, once the drain timeout elapses.
+	abandon chan bool
+	// Wait group tracking the sink goroutines.
+	wg sync.WaitGroup
+}
+
+// fanOutSink is a sink with its queue and counters.
+type fanOutSink struct {
+	Sink
+	// Queued readings.
+	queue chan float64
+	// Cumulative counters, accessed atomically.
+	dropped uint64
+	errors  uint64
+	// Number of readings dropped since the queue last filled up, if the last reading was dropped.
+	dropping uint64
+	// Whether the last reading failed. Only accessed by the sink goroutine.
+	failing bool
+}
+
+// NewFanOutConsumer creates a new fan-out consumer dispatching readings from the device with the
+// specified identifier to the specified sinks.
+func NewFanOutConsumer(deviceIdentifier string, cfg *FanOutConfig, sinks ...Sink) (*FanOutConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &FanOutConsumer{identifier: deviceIdentifier, cfg: *cfg, abandon: make(chan bool)}
+
+	for _, sink := range sinks {
+		s := &fanOutSink{Sink: sink, queue: make(chan float64, cfg.QueueSize)}
+		c.sinks = append(c.sinks, s)
+
+		c.wg.Add(1)
+		go c.run(s)
+	}
+
+	return c, nil
+}
+
+// Consume queues the passed temperature for every sink, dropping it for sinks whose queues are
+// full. Drops are logged when a sink's queue fills up and when it accepts readings again, rather
+// than for every reading. If the policy is SinkErrorAbort, it returns the first sink error since it
+// was last called.
+func (c *FanOutConsumer) Consume(temperature float64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errors.New("fanout: consumer is closed")
+	}
+
+	for _, s := range c.sinks {
+		select {
+		case s.queue <- temperature:
+			if s.dropping > 0 {
+				log.Printf("fanout: sink queue accepting readings again: sink=%s device=%s dropped=%d", s.Name, c.identifier, s.dropping)
+				s.dropping = 0
+			}
+		default:
+			if s.dropping == 0 {
+				log.Printf("fanout: sink queue full; dropping readings: sink=%s device=%s", s.Name, c.identifier)
+			}
+
+			s.dropping++
+			atomic.AddUint64(&s.dropped, 1)
+			c.count(s, "collector.sink.dropped")
+		}
+	}
+
+	err := c.err
+	c.err = nil
+
+	return err
+}
+
+// SetStreamUp notifies every sink that observes the stream status.
+func (c *FanOutConsumer) SetStreamUp(up bool) {
+	for _, s := range c.sinks {
+		if observer, ok := s.Consumer.(StreamStatusObserver); ok {
+			observer.SetStreamUp(up)
+		}
+	}
+}
+
+// Stats returns the cumulative counters of each sink, by name.
+func (c *FanOutConsumer) Stats() map[string]SinkStats {
+	stats := make(map[string]SinkStats, len(c.sinks))
+
+	for _, s := range c.sinks {
+		stats[s.Name] = SinkStats{
+			Dropped: atomic.LoadUint64(&s.dropped),
+			Errors:  atomic.LoadUint64(&s.errors),
+		}
+	}
+
+	return stats
+}
+
+// Close waits up to the drain timeout for every sink to consume its queued readings, then closes
+// every sink that supports it. Readings still queued after the timeout are abandoned, so that a
+// hung sink does not block closing.
+func (c *FanOutConsumer) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	for _, s := range c.sinks {
+		close(s.queue)
+	}
+	c.mutex.Unlock()
+
+	drained := make(chan bool)
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+
+	var timeout <-chan time.Time
+	if c.cfg.DrainTimeout > 0 {
+		timer := time.NewTimer(c.cfg.DrainTimeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case <-drained:
+	case <-timeout:
+		close(c.abandon)
+
+		for _, s := range c.sinks {
+			if abandoned := len(s.queue); abandoned > 0 {
+				log.Printf(
+					"fanout: sink did not drain within %v; abandoning queued readings: sink=%s device=%s abandoned=%d",
+					c.cfg.DrainTimeout,
+					s.Name,
+					c.identifier,
+					abandoned,
+				)
+			}
+		}
+	}
+
+	var result error
+
+	for _, s := range c.sinks {
+		if closer, ok := s.Consumer.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("fanout: failed to close sink: sink=%s device=%s error=%v", s.Name, c.identifier, err)
+				result = err
+			}
+		}
+	}
+
+	return result
+}
+
+// run passes queued readings to a sink until its queue is closed. Errors are logged when the sink
+// starts and stops failing, rather than for every reading.
+func (c *FanOutConsumer) run(s *fanOutSink) {
+	defer c.wg.Done()
+
+	for temperature := range s.queue {
+		select {
+		case <-c.abandon:
+			return
+		default:
+		}
+
+		err := s.Consumer.Consume(temperature)
+		if err == nil {
+			if s.failing {
+				log.Printf("fanout: sink recovered: sink=%s device=%s", s.Name, c.identifier)
+				s.failing = false
+			}
+
+			continue
+		}
+
+		atomic.AddUint64(&s.errors, 1)
+		c.count(s, "collector.sink.error")
+
+		if !s.failing {
+			log.Printf("fanout: sink failed: sink=%s device=%s error=%v", s.Name, c.identifier, err)
+			s.failing = true
+		}
+
+		if c.cfg.ErrorPolicy == SinkErrorAbort {
+			c.mutex.Lock()
+			if c.err == nil {
+				c.err = fmt.Errorf("fanout: sink %s: %v", s.Name, err)
+			}
+			c.mutex.Unlock()
+		}
+	}
+}
+
+// count emits a counter metric for a sink, if a statsd client is configured.
+func (c *FanOutConsumer) count(s *fanOutSink, metric string) {
+	if c.cfg.Statsd == nil {
+		return
+	}
+
+	tags := map[string]interface{}{"sink": s.Name, "device": c.identifier}
+	if c.cfg.Server != "" {
+		tags["server"] = c.cfg.Server
+	}
+
+	c.cfg.Statsd.Count(metric, 1, tags)
+}
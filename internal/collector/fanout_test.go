@@ -0,0 +1,297 @@
+package collector
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sinkRecorder is a consumer recording the temperatures it consumes. It fails every reading while
+// err is set, and if release is set, blocks on each reading until release is closed.
+type sinkRecorder struct {
+	err     error
+	release chan bool
+	// Consumed temperatures, in order, and the number consumed when the sink was closed.
+	temperatures []float64
+	closedAfter  int
+	closed       bool
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func (r *sinkRecorder) Consume(temperature float64) error {
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	r.temperatures = append(r.temperatures, temperature)
+
+	return nil
+}
+
+func (r *sinkRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	r.closedAfter = len(r.temperatures)
+
+	return nil
+}
+
+// consumed returns the consumed temperatures.
+func (r *sinkRecorder) consumed() []float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]float64(nil), r.temperatures...)
+}
+
+// statsdRecorder is a statsd client recording the sum of each counter per sink, and the values of
+// each gauge, in order.
+type statsdRecorder struct {
+	counts map[string]int64
+	gauges map[string][]float64
+	// Mutex used to synchronize access to the recorded metrics.
+	mutex sync.Mutex
+}
+
+func (s *statsdRecorder) Count(metric string, value int64, tags map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.counts == nil {
+		s.counts = make(map[string]int64)
+	}
+
+	sink, _ := tags["sink"].(string)
+	s.counts[metric+" sink="+sink] += value
+}
+
+func (s *statsdRecorder) Gauge(metric string, value float64, tags map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.gauges == nil {
+		s.gauges = make(map[string][]float64)
+	}
+
+	s.gauges[metric] = append(s.gauges[metric], value)
+}
+
+func (s *statsdRecorder) Timing(metric string, value int64, tags map[string]interface{}) {}
+
+// count returns the sum of the counter for the sink.
+func (s *statsdRecorder) count(metric string, sink string) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.counts[metric+" sink="+sink]
+}
+
+// gauge returns the values of the gauge, in order.
+func (s *statsdRecorder) gauge(metric string) []float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]float64(nil), s.gauges[metric]...)
+}
+
+func newTestFanOutConsumer(t *testing.T, cfg *FanOutConfig, sinks ...Sink) *FanOutConsumer {
+	if cfg.ErrorPolicy == "" {
+		cfg.ErrorPolicy = SinkErrorIgnore
+	}
+
+	c, err := NewFanOutConsumer("temper", cfg, sinks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestFanOutConsumerDropsWhenQueueFull(t *testing.T) {
+	statsd := &statsdRecorder{}
+	slow := &sinkRecorder{release: make(chan bool)}
+
+	c := newTestFanOutConsumer(t, &FanOutConfig{QueueSize: 2, Statsd: statsd}, Sink{Name: "slow", Consumer: slow})
+
+	// The first reading is taken from the queue by the blocked sink, and the next two fill it.
+	c.Consume(0)
+	waitFor(t, "the first reading", func() bool { return len(c.sinks[0].queue) == 0 })
+
+	for i := 1; i < 6; i++ {
+		if err := c.Consume(float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats := c.Stats()["slow"]; stats.Dropped != 3 {
+		t.Errorf("got %d dropped readings, want 3", stats.Dropped)
+	}
+
+	if n := statsd.count("collector.sink.dropped", "slow"); n != 3 {
+		t.Errorf("got collector.sink.dropped count %d, want 3", n)
+	}
+
+	close(slow.release)
+	waitFor(t, "the queued readings", func() bool { return len(slow.consumed()) == 3 })
+
+	// The sink accepts readings again once its queue has room.
+	c.Consume(6)
+
+	if c.sinks[0].dropping != 0 {
+		t.Errorf("sink is still dropping after its queue was drained")
+	}
+
+	c.Close()
+
+	got := slow.consumed()
+	if len(got) != 4 || got[0] != 0 || got[1] != 1 || got[2] != 2 || got[3] != 6 {
+		t.Errorf("got consumed readings %v, want [0 1 2 6]", got)
+	}
+}
+
+func TestFanOutConsumerErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy  SinkErrorPolicy
+		wantErr bool
+	}{
+		{policy: SinkErrorIgnore},
+		{policy: SinkErrorAbort, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			statsd := &statsdRecorder{}
+			failing := &sinkRecorder{err: errors.New("sink unavailable")}
+			healthy := &sinkRecorder{}
+
+			c := newTestFanOutConsumer(
+				t,
+				&FanOutConfig{QueueSize: 10, ErrorPolicy: test.policy, Statsd: statsd},
+				Sink{Name: "failing", Consumer: failing},
+				Sink{Name: "healthy", Consumer: healthy},
+			)
+			defer c.Close()
+
+			// Sink errors are returned from the next call after they occur.
+			if err := c.Consume(21.5); err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, "the sink error", func() bool { return c.Stats()["failing"].Errors == 1 })
+
+			if err := c.Consume(22); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error=%v", err, test.wantErr)
+			}
+
+			if n := statsd.count("collector.sink.error", "failing"); n < 1 {
+				t.Errorf("got collector.sink.error count %d, want at least 1", n)
+			}
+
+			if stats := c.Stats()["healthy"]; stats.Errors != 0 {
+				t.Errorf("got %d errors for the healthy sink, want 0", stats.Errors)
+			}
+		})
+	}
+}
+
+func TestFanOutConsumerSlowSinkDoesNotStallOthers(t *testing.T) {
+	slow := &sinkRecorder{release: make(chan bool)}
+	fast := &sinkRecorder{}
+
+	c := newTestFanOutConsumer(
+		t,
+		&FanOutConfig{QueueSize: 100},
+		Sink{Name: "slow", Consumer: slow},
+		Sink{Name: "fast", Consumer: fast},
+	)
+
+	for i := 0; i < 10; i++ {
+		c.Consume(float64(i))
+	}
+
+	waitFor(t, "the fast sink", func() bool { return len(fast.consumed()) == 10 })
+
+	if n := len(slow.consumed()); n != 0 {
+		t.Errorf("got %d readings consumed by the blocked sink, want 0", n)
+	}
+
+	close(slow.release)
+	c.Close()
+
+	if n := len(slow.consumed()); n != 10 {
+		t.Errorf("got %d readings consumed by the slow sink, want 10", n)
+	}
+}
+
+func TestFanOutConsumerCloseDrainsQueues(t *testing.T) {
+	release := make(chan bool)
+	sink := &sinkRecorder{release: release}
+
+	c := newTestFanOutConsumer(t, &FanOutConfig{QueueSize: 100, DrainTimeout: 5 * time.Second}, Sink{Name: "sink", Consumer: sink})
+
+	for i := 0; i < 50; i++ {
+		c.Consume(float64(i))
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	c.Close()
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if !sink.closed || sink.closedAfter != 50 {
+		t.Errorf("got sink closed=%v after %d readings, want closed after 50", sink.closed, sink.closedAfter)
+	}
+}
+
+func TestFanOutConsumerCloseAbandonsHungSink(t *testing.T) {
+	hung := &sinkRecorder{release: make(chan bool)}
+	defer close(hung.release)
+
+	healthy := &sinkRecorder{}
+
+	c := newTestFanOutConsumer(
+		t,
+		&FanOutConfig{QueueSize: 100, DrainTimeout: 50 * time.Millisecond},
+		Sink{Name: "hung", Consumer: hung},
+		Sink{Name: "healthy", Consumer: healthy},
+	)
+
+	for i := 0; i < 5; i++ {
+		c.Consume(float64(i))
+	}
+
+	start := time.Now()
+	c.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("close took %v, want about the drain timeout", elapsed)
+	}
+
+	hung.mutex.Lock()
+	closed := hung.closed
+	hung.mutex.Unlock()
+
+	if !closed {
+		t.Error("hung sink was not closed")
+	}
+
+	if n := len(healthy.consumed()); n != 5 {
+		t.Errorf("got %d readings consumed by the healthy sink, want 5", n)
+	}
+}
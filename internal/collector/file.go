@@ -0,0 +1,604 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// FileFormat is the format in which readings are written to files.
+type FileFormat string
+
+const (
+	// FileFormatCSV writes readings as CSV rows, following a header row.
+	FileFormatCSV FileFormat = "csv"
+	// FileFormatJSONL writes readings as JSON objects, one per line.
+	FileFormatJSONL FileFormat = "jsonl"
+)
+
+// FsyncPolicy determines when readings written to files are synced to disk.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs after every reading.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs periodically.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves syncing to the operating system, except when a file is rotated or closed.
+	FsyncNever FsyncPolicy = "never"
+)
+
+// Default file sink settings.
+const (
+	DefaultFileMaxSize       = 100 * 1024 * 1024
+	DefaultFileRotation      = 24 * time.Hour
+	DefaultFileMaxFiles      = 30
+	DefaultFileFsyncInterval = 1 * time.Second
+)
+
+// fileRotationTimeFormat is the format of the timestamp in the names of rotated files. It sorts
+// lexicographically in chronological order.
+const fileRotationTimeFormat = "20060102T150405.000000000Z"
+
+// csvHeader is the header row of CSV files.
+var csvHeader = []string{"timestamp", "device", "temperature", "unit"}
+
+// FileConfig describes files to which readings are archived.
+type FileConfig struct {
+	// Template of the path of the file to which readings are written, in text/template syntax. The
+	// template is executed with a FilePathData.
+	Path string
+	// Format of readings.
+	Format FileFormat
+	// Size in bytes beyond which the file is rotated. Unlimited if 0.
+	MaxSize int64
+	// Age beyond which the file is rotated. Unlimited if 0.
+	RotationInterval time.Duration
+	// Whether rotated files are compressed with gzip.
+	Compress bool
+	// Maximum number of rotated files retained, beyond which the oldest are deleted. Unlimited if 0.
+	MaxFiles int
+	// When readings are synced to disk.
+	Fsync FsyncPolicy
+	// Interval at which readings are synced to disk, if the policy is FsyncInterval.
+	FsyncInterval time.Duration
+	// Address of the server supplying readings. Optional.
+	Server string
+}
+
+// FilePathData is the data with which file path templates are executed.
+type FilePathData struct {
+	// Device identifier, with path separators replaced by underscores.
+	Device string
+	// Address of the server, with path separators replaced by underscores.
+	Server string
+}
+
+// filePathSanitizer replaces characters that would introduce path components.
+var filePathSanitizer = strings.NewReplacer("/", "_", `\`, "_", "..", "_")
+
+// ParseFileFormat parses the name of a file format.
+func ParseFileFormat(name string) (FileFormat, error) {
+	switch format := FileFormat(strings.ToLower(name)); format {
+	case FileFormatCSV, FileFormatJSONL:
+		return format, nil
+	default:
+		return "", fmt.Errorf("file: unknown format %q; use csv or jsonl", name)
+	}
+}
+
+// ParseFsyncPolicy parses the name of an fsync policy.
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch policy := FsyncPolicy(strings.ToLower(name)); policy {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("file: unknown fsync policy %q; use always, interval, or never", name)
+	}
+}
+
+// Validate returns an error if the configuration is not usable.
+func (c *FileConfig) Validate() error {
+	if _, err := c.path("device"); err != nil {
+		return err
+	}
+
+	if _, err := ParseFileFormat(string(c.Format)); err != nil {
+		return err
+	}
+
+	if _, err := ParseFsyncPolicy(string(c.Fsync)); err != nil {
+		return err
+	}
+
+	switch {
+	case c.MaxSize < 0:
+		return errors.New("file: maximum size must not be negative")
+	case c.RotationInterval < 0:
+		return errors.New("file: rotation interval must not be negative")
+	case c.MaxFiles < 0:
+		return errors.New("file: maximum number of files must not be negative")
+	case c.Fsync == FsyncInterval && c.FsyncInterval <= 0:
+		return errors.New("file: fsync interval must be positive")
+	}
+
+	return nil
+}
+
+// ValidateShared returns an error if the path template does not distinguish the files of devices of
+// several servers. Without both the device and the server in the path, devices of different
+// servers, including servers reporting the same device identifier, would write to the same file.
+func (c *FileConfig) ValidateShared() error {
+	byDevice := map[string]bool{}
+	byServer := map[string]bool{}
+
+	for _, value := range []string{"a", "b"} {
+		path, err := renderFilePath(c.Path, value, "server")
+		if err != nil {
+			return err
+		}
+		byDevice[path] = true
+
+		if path, err = renderFilePath(c.Path, "device", value); err != nil {
+			return err
+		}
+		byServer[path] = true
+	}
+
+	if len(byDevice) < 2 || len(byServer) < 2 {
+		return errors.New("file: path template must reference both {{.Device}} and {{.Server}} with more than one server")
+	}
+
+	return nil
+}
+
+// path renders the path of the file of readings from the specified device.
+func (c *FileConfig) path(identifier string) (string, error) {
+	return renderFilePath(c.Path, identifier, c.Server)
+}
+
+// renderFilePath renders a path template for the specified device and server.
+func renderFilePath(text string, identifier string, server string) (string, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("file: path template: %v", err)
+	}
+
+	data := &FilePathData{
+		Device: filePathSanitizer.Replace(identifier),
+		Server: filePathSanitizer.Replace(server),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("file: path template: %v", err)
+	}
+
+	if buf.Len() == 0 {
+		return "", errors.New("file: path template: empty path")
+	}
+
+	return buf.String(), nil
+}
+
+// FileConsumer is a consumer implementing the client.TemperatureConsumer interface for archiving
+// consumed temperatures to a local file, rotating it by size and age. Rotated files are renamed
+// with the time of rotation, optionally compressed, and deleted when they exceed the retention
+// limit.
+type FileConsumer struct {
+	// Device identifier included in all readings.
+	identifier string
+	// Path of the active file.
+	path string
+	// File configuration.
+	cfg FileConfig
+	// Active file, if open.
+	file *os.File
+	// Buffered writer of the active file.
+	writer *bufio.Writer
+	// Size of the active file, including buffered data.
+	size int64
+	// Time from which the age of the active file is measured: when it was created, or for an
+	// existing file, when it was last modified.
+	opened time.Time
+	// Whether data has been written since the last sync.
+	dirty bool
+	// Whether the consumer is closed.
+	closed bool
+	// Mutex used to synchronize access to the active file and closed state.
+	mutex sync.Mutex
+	// Mutex serializing the compression and pruning of rotated files, so that a file being
+	// compressed is not pruned concurrently.
+	rotatedMutex sync.Mutex
+	// Channel closed to stop periodic syncing.
+	done chan bool
+	// Wait group tracking background goroutines.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+}
+
+// NewFileConsumer creates a new file consumer using the specified device identifier and file
+// configuration, opening the file for appending.
+func NewFileConsumer(deviceIdentifier string, cfg *FileConfig) (*FileConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The template was validated above.
+	path, _ := cfg.path(deviceIdentifier)
+
+	c := &FileConsumer{
+		identifier: deviceIdentifier,
+		path:       path,
+		cfg:        *cfg,
+		done:       make(chan bool),
+	}
+
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Fsync == FsyncInterval {
+		c.wg.Add(1)
+		go c.syncPeriodically()
+	}
+
+	return c, nil
+}
+
+// Consume writes the passed temperature, timestamped now, rotating the file first if necessary.
+func (c *FileConsumer) Consume(temperature float64) error {
+	now := time.Now().UTC()
+
+	record, err := c.format(now, temperature)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errors.New("file: consumer is closed")
+	}
+
+	if c.shouldRotate(now, len(record)) {
+		if err := c.rotate(now); err != nil {
+			return err
+		}
+	}
+
+	if c.file == nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := c.writer.Write(record)
+	c.size += int64(n)
+	c.dirty = true
+	if err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	if c.cfg.Fsync == FsyncAlways {
+		return c.sync()
+	}
+
+	return nil
+}
+
+// Close syncs and closes the file, and waits for rotated files to be compressed.
+func (c *FileConsumer) Close() error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.mutex.Lock()
+		c.closed = true
+		err = c.closeFile()
+		c.mutex.Unlock()
+
+		c.wg.Wait()
+	})
+
+	return err
+}
+
+// format formats a reading in the configured format, including the trailing newline.
+func (c *FileConsumer) format(timestamp time.Time, temperature float64) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch c.cfg.Format {
+	case FileFormatJSONL:
+		if err := json.NewEncoder(&buf).Encode(&temperatureRecord{
+			Timestamp:   timestamp.Format(time.RFC3339Nano),
+			Device:      c.identifier,
+			Temperature: temperature,
+			Unit:        "celsius",
+		}); err != nil {
+			return nil, fmt.Errorf("file: %v", err)
+		}
+	default:
+		w := csv.NewWriter(&buf)
+		w.Write([]string{
+			timestamp.Format(time.RFC3339Nano),
+			c.identifier,
+			strconv.FormatFloat(temperature, 'f', -1, 64),
+			"celsius",
+		})
+		w.Flush()
+
+		if err := w.Error(); err != nil {
+			return nil, fmt.Errorf("file: %v", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// temperatureRecord is a reading in the JSON Lines format.
+type temperatureRecord struct {
+	Timestamp   string  `json:"timestamp"`
+	Device      string  `json:"device"`
+	Temperature float64 `json:"temperature"`
+	Unit        string  `json:"unit"`
+}
+
+// open opens the active file for appending, creating it and its directory if necessary, and writes
+// the CSV header to new files.
+func (c *FileConsumer) open() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	file, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("file: %v", err)
+	}
+
+	c.file = file
+	c.writer = bufio.NewWriter(file)
+	c.size = info.Size()
+	c.opened = time.Now()
+
+	// A file left by a previous run is not reset to a full rotation interval.
+	if c.size > 0 {
+		c.opened = info.ModTime()
+	}
+
+	if c.size == 0 && c.cfg.Format == FileFormatCSV {
+		// The header contains no characters that need quoting.
+		n, _ := c.writer.WriteString(strings.Join(csvHeader, ",") + "\n")
+		c.size = int64(n)
+		c.dirty = true
+	}
+
+	return nil
+}
+
+// shouldRotate returns whether the active file must be rotated before writing a record of the
+// specified size at the specified time.
+func (c *FileConsumer) shouldRotate(now time.Time, size int) bool {
+	if c.file == nil {
+		return false
+	}
+
+	if c.cfg.MaxSize > 0 && c.size+int64(size) > c.cfg.MaxSize && c.size > 0 {
+		return true
+	}
+
+	return c.cfg.RotationInterval > 0 && now.Sub(c.opened) >= c.cfg.RotationInterval
+}
+
+// rotate closes the active file and renames it with the time of rotation, then compresses it and
+// deletes files beyond the retention limit in the background. A new file is opened on the next
+// write.
+func (c *FileConsumer) rotate(now time.Time) error {
+	if err := c.closeFile(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(c.path)
+	rotated := strings.TrimSuffix(c.path, ext) + "-" + now.Format(fileRotationTimeFormat) + ext
+
+	if err := os.Rename(c.path, rotated); err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	log.Printf("file: rotated file: device=%s path=%s", c.identifier, rotated)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		c.rotatedMutex.Lock()
+		defer c.rotatedMutex.Unlock()
+
+		if c.cfg.Compress {
+			if err := compressFile(rotated); err != nil {
+				log.Printf("file: failed to compress rotated file: path=%s error=%v", rotated, err)
+			}
+		}
+
+		c.prune()
+	}()
+
+	return nil
+}
+
+// prune deletes the oldest rotated files beyond the retention limit.
+func (c *FileConsumer) prune() {
+	if c.cfg.MaxFiles == 0 {
+		return
+	}
+
+	ext := filepath.Ext(c.path)
+	prefix := strings.TrimSuffix(c.path, ext) + "-"
+	pattern := prefix + "*" + ext + "*"
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Printf("file: failed to list rotated files: pattern=%s error=%v", pattern, err)
+		return
+	}
+
+	// Rotated files are named with the time of rotation, so they sort chronologically. Matches
+	// without a valid time, such as the files of other devices or partially compressed files, are
+	// ignored. A file being compressed may briefly appear both compressed and uncompressed.
+	var rotated []string
+	for _, match := range matches {
+		name := strings.TrimSuffix(match, ".gz")
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if _, err := time.Parse(fileRotationTimeFormat, timestamp); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+	sort.Strings(rotated)
+	rotated = dedupeSorted(rotated)
+
+	for i := 0; i < len(rotated)-c.cfg.MaxFiles; i++ {
+		for _, path := range []string{rotated[i], rotated[i] + ".gz"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("file: failed to delete rotated file: path=%s error=%v", path, err)
+			}
+		}
+	}
+}
+
+// closeFile flushes, syncs, and closes the active file, if open.
+func (c *FileConsumer) closeFile() error {
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.sync()
+	if closeErr := c.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("file: %v", closeErr)
+	}
+
+	c.file = nil
+	c.writer = nil
+
+	return err
+}
+
+// sync flushes buffered data and syncs the active file to disk, if anything was written.
+func (c *FileConsumer) sync() error {
+	if c.file == nil || !c.dirty {
+		return nil
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("file: %v", err)
+	}
+
+	c.dirty = false
+
+	return nil
+}
+
+// syncPeriodically syncs the active file at the fsync interval until the consumer is closed.
+func (c *FileConsumer) syncPeriodically() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.cfg.FsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mutex.Lock()
+			if err := c.sync(); err != nil {
+				log.Printf("file: failed to sync: path=%s error=%v", c.path, err)
+			}
+			c.mutex.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// compressFile compresses a file with gzip, replacing it with a file of the same name with a .gz
+// extension.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// dedupeSorted returns the sorted values without repeated values.
+func dedupeSorted(values []string) []string {
+	var result []string
+
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
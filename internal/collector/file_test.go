@@ -0,0 +1,375 @@
+package collector
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFileConfig returns a configuration writing uncompressed CSV files in the directory, without
+// rotation.
+func newTestFileConfig(dir string) *FileConfig {
+	return &FileConfig{
+		Path:   filepath.Join(dir, "readings-{{.Device}}.csv"),
+		Format: FileFormatCSV,
+		Fsync:  FsyncNever,
+	}
+}
+
+// rotatedFiles returns the names of the rotated files of the device in the directory, in order.
+func rotatedFiles(t *testing.T, dir string, device string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "readings-"+device+"-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(matches)
+
+	return matches
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// timestampPattern matches the RFC 3339 timestamps of readings.
+var timestampPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T[\d:.]+Z`)
+
+func TestFileConsumerFormats(t *testing.T) {
+	tests := []struct {
+		format FileFormat
+		// Expected contents after two readings, with timestamps replaced by T.
+		want string
+	}{
+		{format: FileFormatCSV, want: "timestamp,device,temperature,unit\nT,a b,21.5,celsius\nT,a b,-3,celsius\n"},
+		{
+			format: FileFormatJSONL,
+			want: `{"timestamp":"T","device":"a b","temperature":21.5,"unit":"celsius"}` + "\n" +
+				`{"timestamp":"T","device":"a b","temperature":-3,"unit":"celsius"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			cfg := newTestFileConfig(dir)
+			cfg.Format = test.format
+
+			c, err := NewFileConsumer("a b", cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.Consume(21.5)
+			c.Consume(-3)
+			c.Close()
+
+			got := timestampPattern.ReplaceAllString(readFile(t, c.path), "T")
+			if got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFileConsumerAppendsAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		c, err := NewFileConsumer("temper", newTestFileConfig(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Consume(21.5)
+		c.Close()
+	}
+
+	contents := readFile(t, filepath.Join(dir, "readings-temper.csv"))
+	if n := strings.Count(contents, "timestamp,device"); n != 1 {
+		t.Errorf("got %d header rows, want 1:\n%s", n, contents)
+	}
+
+	if n := strings.Count(contents, "\n"); n != 3 {
+		t.Errorf("got %d rows, want 3:\n%s", n, contents)
+	}
+}
+
+func TestFileConsumerRotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := newTestFileConfig(dir)
+	cfg.Format = FileFormatJSONL
+	// Each reading is about 90 bytes, so two fit in a file.
+	cfg.MaxSize = 200
+
+	c, err := NewFileConsumer("temper", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := c.Consume(float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+
+	rotated := rotatedFiles(t, dir, "temper")
+	if len(rotated) != 2 {
+		t.Fatalf("got rotated files %v, want 2", rotated)
+	}
+
+	for _, path := range append(rotated, c.path) {
+		if info, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		} else if info.Size() > cfg.MaxSize {
+			t.Errorf("%s: got size %d, want at most %d", path, info.Size(), cfg.MaxSize)
+		}
+	}
+
+	if !strings.Contains(readFile(t, rotated[0]), `"temperature":0,`) {
+		t.Errorf("oldest rotated file does not contain the first reading")
+	}
+
+	if n := strings.Count(readFile(t, c.path), "\n"); n != 1 {
+		t.Errorf("got %d readings in the active file, want 1", n)
+	}
+}
+
+func TestFileConsumerRotatesByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := newTestFileConfig(dir)
+	cfg.RotationInterval = time.Hour
+
+	c, err := NewFileConsumer("temper", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Consume(21.5)
+
+	c.mutex.Lock()
+	c.opened = c.opened.Add(-time.Hour)
+	c.mutex.Unlock()
+
+	c.Consume(22)
+	c.Close()
+
+	if rotated := rotatedFiles(t, dir, "temper"); len(rotated) != 1 {
+		t.Fatalf("got rotated files %v, want 1", rotated)
+	}
+
+	// A file left by a previous run keeps its age.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewFileConsumer("temper", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Consume(22.5)
+	c.Close()
+
+	if rotated := rotatedFiles(t, dir, "temper"); len(rotated) != 2 {
+		t.Fatalf("got rotated files %v after restarting, want 2", rotated)
+	}
+}
+
+func TestFileConsumerCompressesAndPrunes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files of other devices sharing the prefix, and unrelated files, are not pruned.
+	others := []string{
+		filepath.Join(dir, "readings-temper-2-20200101T000000.000000000Z.csv"),
+		filepath.Join(dir, "readings-temper-notes.csv"),
+	}
+	for _, path := range others {
+		if err := ioutil.WriteFile(path, []byte("other\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := newTestFileConfig(dir)
+	cfg.Format = FileFormatJSONL
+	cfg.MaxSize = 1
+	cfg.Compress = true
+	cfg.MaxFiles = 2
+
+	c, err := NewFileConsumer("temper", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every reading after the first rotates the file.
+	for i := 0; i < 5; i++ {
+		c.Consume(float64(i))
+	}
+	c.Close()
+
+	for _, path := range others {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("unrelated file was deleted: %v", err)
+		}
+	}
+
+	var rotated []string
+	for _, path := range rotatedFiles(t, dir, "temper") {
+		if strings.HasSuffix(path, "Z.csv.gz") {
+			rotated = append(rotated, path)
+		} else if path != others[0] && path != others[1] {
+			t.Errorf("unexpected file %s", path)
+		}
+	}
+
+	if len(rotated) != 2 {
+		t.Fatalf("got compressed rotated files %v, want 2", rotated)
+	}
+
+	// The newest files are retained.
+	for i, path := range rotated {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(gz)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := `"temperature":` + strconv.Itoa(2+i) + ","; !strings.Contains(string(data), want) {
+			t.Errorf("%s: got %q, want a reading containing %s", path, data, want)
+		}
+	}
+}
+
+func TestFileConsumerClosed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewFileConsumer("temper", newTestFileConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+
+	if err := c.Consume(21.5); err == nil {
+		t.Error("consume succeeded after close, want an error")
+	}
+
+	if c.file != nil {
+		t.Error("file was reopened after close")
+	}
+}
+
+func TestFileConfigValidateShared(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{path: "/var/lib/zephyrus/{{.Server}}/readings-{{.Device}}.csv", valid: true},
+		{path: "readings-{{.Device}}-{{.Server}}.csv", valid: true},
+		{path: "readings-{{.Device}}.csv"},
+		{path: "readings-{{.Server}}.csv"},
+		{path: "readings.csv"},
+		{path: "{{.Missing}}"},
+	}
+
+	for _, test := range tests {
+		cfg := &FileConfig{Path: test.path}
+
+		if err := cfg.ValidateShared(); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid=%v", test.path, err, test.valid)
+		}
+	}
+}
+
+func TestFileConsumerPathPerServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := newTestFileConfig(dir)
+	cfg.Path = filepath.Join(dir, "{{.Server}}", "readings-{{.Device}}.csv")
+
+	// Servers reporting the same device identifier write to their own files.
+	var paths []string
+	for _, server := range []string{"sensor-a:6840", "unix:///run/zephyrus.sock"} {
+		cfg.Server = server
+
+		c, err := NewFileConsumer("temper", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Consume(21.5)
+		c.Close()
+
+		paths = append(paths, c.path)
+	}
+
+	want := []string{
+		filepath.Join(dir, "sensor-a:6840", "readings-temper.csv"),
+		filepath.Join(dir, "unix:___run_zephyrus.sock", "readings-temper.csv"),
+	}
+
+	for i, path := range paths {
+		if path != want[i] {
+			t.Errorf("got path %s, want %s", path, want[i])
+		}
+
+		if n := strings.Count(readFile(t, path), "\n"); n != 2 {
+			t.Errorf("%s: got %d rows, want 2", path, n)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// Timeout for connecting to and writing to a Carbon server.
+	graphiteTimeout = 10 * time.Second
+	// Delay before the first reconnection attempt, doubled after each further failure.
+	graphiteRetryDelay = 1 * time.Second
+	// Upper bound of the delay between reconnection attempts.
+	graphiteMaxRetryDelay = 30 * time.Second
+	// Maximum number of lines buffered while disconnected, beyond which the oldest are dropped.
+	graphiteMaxBuffered = 10000
+)
+
+// DefaultGraphitePath is the default template of the metric path of readings.
+const DefaultGraphitePath = "zephyrus.{{.Device}}.temperature"
+
+// GraphiteConfig describes a Carbon server to which readings are written in the Graphite plaintext
+// protocol.
+type GraphiteConfig struct {
+	// Address of the Carbon plaintext listener, as host:port.
+	Addr string
+	// Template of the metric path of readings, in text/template syntax. The template is executed
+	// with a GraphitePathData.
+	PathTemplate string
+}
+
+// GraphitePathData is the data with which metric path templates are executed.
+type GraphitePathData struct {
+	// Device identifier, with characters that are special in metric paths replaced by underscores.
+	Device string
+}
+
+// graphitePathSanitizer replaces characters that would introduce path components or break the
+// plaintext protocol.
+var graphitePathSanitizer = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "\n", "_", "/", "_")
+
+// Validate returns an error if the configuration is not usable.
+func (c *GraphiteConfig) Validate() error {
+	if c.Addr == "" {
+		return errors.New("graphite: address must be specified")
+	}
+
+	_, err := c.path("device")
+
+	return err
+}
+
+// path renders the metric path of readings from the specified device.
+func (c *GraphiteConfig) path(identifier string) (string, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(c.PathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("graphite: path template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &GraphitePathData{Device: graphitePathSanitizer.Replace(identifier)}); err != nil {
+		return "", fmt.Errorf("graphite: path template: %v", err)
+	}
+
+	path := buf.String()
+	if path == "" || strings.ContainsAny(path, " \t\n") {
+		return "", fmt.Errorf("graphite: path template: invalid metric path %q", path)
+	}
+
+	return path, nil
+}
+
+// GraphiteConsumer is a consumer implementing the client.TemperatureConsumer interface for writing
+// consumed temperatures to a Carbon server in the Graphite plaintext protocol. Readings are written
+// asynchronously over a persistent connection, and buffered while it is reestablished.
+type GraphiteConsumer struct {
+	// Address of the Carbon server.
+	addr string
+	// Metric path of readings.
+	path string
+	// Buffered lines.
+	lines []string
+	// Number of buffered lines dropped since the last successful write.
+	dropped int
+	// Mutex used to synchronize access to the buffered lines and the number dropped.
+	mutex sync.Mutex
+	// Channel signaled when lines are buffered.
+	pending chan bool
+	// Channel closed to stop the writer.
+	done chan bool
+	// Wait group tracking the writer goroutine.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+	// Connection to the Carbon server, if connected.
+	conn net.Conn
+	// Mutex used to synchronize access to the connection.
+	connMutex sync.Mutex
+}
+
+// NewGraphiteConsumer creates a new Graphite consumer using the specified device identifier and
+// server configuration. The connection is established when the first reading is written.
+func NewGraphiteConsumer(deviceIdentifier string, cfg *GraphiteConfig) (*GraphiteConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	path, err := cfg.path(deviceIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &GraphiteConsumer{
+		addr:    cfg.Addr,
+		path:    path,
+		pending: make(chan bool, 1),
+		done:    make(chan bool),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c, nil
+}
+
+// Consume buffers the passed temperature, timestamped now, for writing.
+func (c *GraphiteConsumer) Consume(temperature float64) error {
+	line := formatGraphiteLine(c.path, temperature, time.Now())
+
+	c.mutex.Lock()
+	c.lines = append(c.lines, line)
+	c.trim()
+	c.mutex.Unlock()
+
+	select {
+	case c.pending <- true:
+	default:
+	}
+
+	return nil
+}
+
+// ConsumeBatch writes the passed readings with their original timestamps immediately, bypassing
+// the buffer, and returns an error if they could not be written.
+func (c *GraphiteConsumer) ConsumeBatch(readings []Reading) error {
+	lines := make([]string, len(readings))
+	for i, reading := range readings {
+		lines[i] = formatGraphiteLine(c.path, reading.Temperature, reading.Timestamp)
+	}
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if _, err := c.write(strings.Join(lines, "")); err != nil {
+		c.disconnect()
+		return fmt.Errorf("graphite: %v", err)
+	}
+
+	return nil
+}
+
+// Close stops the writer after a final attempt to write any buffered readings, and closes the
+// connection.
+func (c *GraphiteConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+	})
+
+	return nil
+}
+
+// run writes buffered lines as they arrive, reconnecting with exponential backoff after errors,
+// until the consumer is closed.
+func (c *GraphiteConsumer) run() {
+	defer c.wg.Done()
+
+	delay := graphiteRetryDelay
+
+	for {
+		select {
+		case <-c.pending:
+		case <-c.done:
+			c.flush()
+			c.close()
+			return
+		}
+
+		if c.flush() {
+			delay = graphiteRetryDelay
+			continue
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			c.flush()
+			c.close()
+			return
+		}
+
+		if delay *= 2; delay > graphiteMaxRetryDelay {
+			delay = graphiteMaxRetryDelay
+		}
+
+		// Retry, even if no further readings arrive.
+		select {
+		case c.pending <- true:
+		default:
+		}
+	}
+}
+
+// flush writes all buffered lines, connecting first if necessary, and returns whether it succeeded.
+// Lines that were not completely written are returned to the buffer.
+func (c *GraphiteConsumer) flush() bool {
+	c.mutex.Lock()
+	lines := c.lines
+	c.lines = nil
+	c.mutex.Unlock()
+
+	if len(lines) == 0 {
+		return true
+	}
+
+	c.connMutex.Lock()
+	n, err := c.write(strings.Join(lines, ""))
+	if err != nil {
+		c.disconnect()
+	}
+	c.connMutex.Unlock()
+
+	if err == nil {
+		c.mutex.Lock()
+		if c.dropped > 0 {
+			log.Printf("graphite: write recovered; readings were dropped: server=%s dropped=%d", c.addr, c.dropped)
+			c.dropped = 0
+		}
+		c.mutex.Unlock()
+
+		return true
+	}
+
+	// Skip the lines that were written completely. A partially written line is incomplete on the
+	// closed connection, so it is written again.
+	for len(lines) > 0 && n >= len(lines[0]) {
+		n -= len(lines[0])
+		lines = lines[1:]
+	}
+
+	log.Printf("graphite: write failed; buffering readings: server=%s buffered=%d error=%v", c.addr, len(lines), err)
+
+	// Lines buffered while writing are more recent, so they follow the lines that failed.
+	c.mutex.Lock()
+	c.lines = append(lines, c.lines...)
+	c.trim()
+	c.mutex.Unlock()
+
+	return false
+}
+
+// trim drops the oldest buffered lines beyond the maximum, logging when lines start being dropped.
+// The mutex must be held.
+func (c *GraphiteConsumer) trim() {
+	dropped := len(c.lines) - graphiteMaxBuffered
+	if dropped <= 0 {
+		return
+	}
+
+	if c.dropped == 0 {
+		log.Printf("graphite: buffer full; dropping oldest readings: server=%s buffered=%d", c.addr, graphiteMaxBuffered)
+	}
+
+	c.lines = c.lines[dropped:]
+	c.dropped += dropped
+}
+
+// write writes data to the server, connecting first if necessary, and returns the number of bytes
+// written. The connection mutex must be held.
+func (c *GraphiteConsumer) write(data string) (int, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, graphiteTimeout)
+		if err != nil {
+			return 0, err
+		}
+
+		log.Printf("graphite: connected: server=%s", c.addr)
+		c.conn = conn
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(graphiteTimeout))
+
+	return c.conn.Write([]byte(data))
+}
+
+// disconnect closes the connection, if any. The connection mutex must be held.
+func (c *GraphiteConsumer) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// close closes the connection, if any.
+func (c *GraphiteConsumer) close() {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	c.disconnect()
+}
+
+// formatGraphiteLine formats a reading as a line of the plaintext protocol, including the trailing
+// newline.
+func formatGraphiteLine(path string, temperature float64, timestamp time.Time) string {
+	return path + " " +
+		strconv.FormatFloat(temperature, 'f', -1, 64) + " " +
+		strconv.FormatInt(timestamp.Unix(), 10) + "\n"
+}
@@ -0,0 +1,134 @@
+package collector
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// partialConn is a connection accepting a limited number of bytes, after which writes fail.
+type partialConn struct {
+	net.Conn
+	// Number of bytes accepted before writes fail.
+	limit int
+	// Bytes written.
+	written strings.Builder
+}
+
+func (c *partialConn) Write(b []byte) (int, error) {
+	n := len(b)
+	if remaining := c.limit - c.written.Len(); n > remaining {
+		n = remaining
+	}
+
+	c.written.Write(b[:n])
+	if n < len(b) {
+		return n, errors.New("connection reset")
+	}
+
+	return n, nil
+}
+
+func (c *partialConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (c *partialConn) Close() error {
+	return nil
+}
+
+func TestGraphiteFlushRebuffersUnsentLines(t *testing.T) {
+	lines := []string{"a 1 1\n", "b 2 2\n", "c 3 3\n", "d 4 4\n"}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{name: "nothing written", limit: 0, want: lines},
+		{name: "line boundary", limit: 12, want: lines[2:]},
+		{name: "within a line", limit: 14, want: lines[2:]},
+		{name: "all but the last byte", limit: 23, want: lines[3:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &partialConn{limit: test.limit}
+			c := &GraphiteConsumer{addr: "carbon:2003", conn: conn}
+			c.lines = append([]string(nil), lines...)
+
+			if c.flush() {
+				t.Fatal("flush succeeded, want failure")
+			}
+
+			if c.conn != nil {
+				t.Error("connection was not closed after the failure")
+			}
+
+			if strings.Join(c.lines, "") != strings.Join(test.want, "") {
+				t.Errorf("got buffered lines %q, want %q", c.lines, test.want)
+			}
+		})
+	}
+}
+
+func TestGraphiteConsumeDropsOldestLines(t *testing.T) {
+	c := &GraphiteConsumer{addr: "carbon:2003", path: "zephyrus.temper.temperature", pending: make(chan bool, 1)}
+
+	for i := 0; i < graphiteMaxBuffered+5; i++ {
+		c.Consume(float64(i))
+	}
+
+	if len(c.lines) != graphiteMaxBuffered || c.dropped != 5 {
+		t.Fatalf("got %d buffered and %d dropped lines, want %d and 5", len(c.lines), c.dropped, graphiteMaxBuffered)
+	}
+
+	if !strings.HasPrefix(c.lines[0], "zephyrus.temper.temperature 5 ") {
+		t.Errorf("got oldest buffered line %q, want the reading of 5", c.lines[0])
+	}
+
+	// The count of dropped lines is reset by a successful write.
+	c.conn = &partialConn{limit: 1 << 30}
+	if !c.flush() {
+		t.Fatal("flush failed")
+	}
+
+	if len(c.lines) != 0 || c.dropped != 0 {
+		t.Errorf("got %d buffered and %d dropped lines after flushing, want 0", len(c.lines), c.dropped)
+	}
+}
+
+func TestGraphiteConfigPath(t *testing.T) {
+	tests := []struct {
+		template   string
+		identifier string
+		want       string
+		valid      bool
+	}{
+		{template: DefaultGraphitePath, identifier: "temper", want: "zephyrus.temper.temperature", valid: true},
+		{template: DefaultGraphitePath, identifier: "living room/1.2", want: "zephyrus.living_room_1_2.temperature", valid: true},
+		{template: "home.{{.Device}}", identifier: "a\tb\nc", want: "home.a_b_c", valid: true},
+		{template: "{{.Missing}}", identifier: "temper"},
+		{template: "{{.Device", identifier: "temper"},
+		{template: "home {{.Device}}", identifier: "temper"},
+		{template: "", identifier: "temper"},
+	}
+
+	for _, test := range tests {
+		cfg := &GraphiteConfig{Addr: "carbon:2003", PathTemplate: test.template}
+
+		path, err := cfg.path(test.identifier)
+		if (err == nil) != test.valid || path != test.want {
+			t.Errorf("template %q: got %q, %v; want %q, valid=%v", test.template, path, err, test.want, test.valid)
+		}
+	}
+}
+
+func TestFormatGraphiteLine(t *testing.T) {
+	got := formatGraphiteLine("zephyrus.temper.temperature", 21.0625, time.Unix(1577836800, 999999999))
+	if want := "zephyrus.temper.temperature 21.0625 1577836800\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,368 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeout of a single InfluxDB HTTP write request.
+	influxDBRequestTimeout = 10 * time.Second
+	// Maximum number of attempts to write a batch over HTTP before it is dropped.
+	influxDBMaxAttempts = 4
+	// Delay before the first retry of a failed write, doubled after each further failure.
+	influxDBRetryDelay = 1 * time.Second
+	// Maximum size of a single UDP datagram of line protocol, chosen to fit within a typical MTU.
+	influxDBMaxDatagramSize = 1400
+	// Name of the measurement to which readings are written.
+	influxDBMeasurement = "temperature"
+	// Name of the field holding each reading, in degrees Celsius.
+	influxDBField = "celsius"
+)
+
+// Default InfluxDB batching settings.
+const (
+	DefaultInfluxDBBatchSize     = 100
+	DefaultInfluxDBFlushInterval = 10 * time.Second
+)
+
+// InfluxDBConfig describes an InfluxDB server to which readings are written in line protocol.
+//
+// Over HTTP, readings are written with the InfluxDB 2 write API if a bucket is specified, or the
+// InfluxDB 1 write API otherwise.
+type InfluxDBConfig struct {
+	// URL of the server: http:// or https:// for the HTTP write API, or udp://host:port for the UDP
+	// listener.
+	URL string
+	// Database to write to, for the InfluxDB 1 write API.
+	Database string
+	// Retention policy to write to, for the InfluxDB 1 write API. Optional.
+	RetentionPolicy string
+	// Username and password, for the InfluxDB 1 write API. Optional.
+	Username string
+	Password string
+	// Organization and bucket to write to, for the InfluxDB 2 write API.
+	Org    string
+	Bucket string
+	// API token, for the InfluxDB 2 write API or InfluxDB 1 token authentication. Optional.
+	Token string
+	// Number of readings buffered before they are written.
+	BatchSize int
+	// Maximum amount of time a reading is buffered before it is written.
+	FlushInterval time.Duration
+}
+
+// Validate returns an error if the configuration is not usable.
+func (c *InfluxDBConfig) Validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("influxdb: %v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if c.Bucket == "" && c.Database == "" {
+			return errors.New("influxdb: a database (InfluxDB 1) or bucket (InfluxDB 2) must be specified")
+		}
+
+		if c.Bucket != "" && c.Org == "" {
+			return errors.New("influxdb: an organization must be specified with a bucket")
+		}
+	case "udp":
+		if u.Host == "" {
+			return errors.New("influxdb: UDP URL must specify a host and port")
+		}
+	default:
+		return fmt.Errorf("influxdb: unsupported URL scheme %q; use http, https, or udp", u.Scheme)
+	}
+
+	if c.BatchSize < 1 {
+		return errors.New("influxdb: batch size must be at least 1")
+	}
+
+	if c.FlushInterval <= 0 {
+		return errors.New("influxdb: flush interval must be positive")
+	}
+
+	return nil
+}
+
+// InfluxDBConsumer is a consumer implementing the client.TemperatureConsumer interface for writing
+// consumed temperatures to InfluxDB in line protocol, with nanosecond timestamps and a device tag.
+// Readings are batched and written asynchronously so that a slow server never blocks the stream.
+type InfluxDBConsumer struct {
+	// Device identifier to attach as a tag to all points.
+	identifier string
+	// Writer of line protocol batches, making a single attempt and returning whether a failed write
+	// may be retried.
+	write func(batch []byte) (bool, error)
+	// Number of readings buffered before they are written.
+	batchSize int
+	// Maximum amount of time a reading is buffered before it is written.
+	flushInterval time.Duration
+	// Buffered points, in line protocol.
+	points []string
+	// Mutex used to synchronize access to the buffered points.
+	mutex sync.Mutex
+	// Channel signaled when a full batch is buffered.
+	flush chan bool
+	// Channel closed to stop the writer.
+	done chan bool
+	// Wait group tracking the writer goroutine.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+	// Connection used for UDP writes, if any.
+	conn net.Conn
+}
+
+// NewInfluxDBConsumer creates a new InfluxDB consumer using the specified device identifier and
+// server configuration.
+func NewInfluxDBConsumer(deviceIdentifier string, cfg *InfluxDBConfig) (*InfluxDBConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &InfluxDBConsumer{
+		identifier:    deviceIdentifier,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		flush:         make(chan bool, 1),
+		done:          make(chan bool),
+	}
+
+	// The URL was validated above.
+	u, _ := url.Parse(cfg.URL)
+
+	if u.Scheme == "udp" {
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("influxdb: %v", err)
+		}
+
+		c.conn = conn
+		c.write = c.writeUDP
+	} else {
+		c.write = newInfluxDBHTTPWriter(cfg, u)
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c, nil
+}
+
+// Consume buffers the passed temperature as a point timestamped now.
+func (c *InfluxDBConsumer) Consume(temperature float64) error {
+	point := formatInfluxDBPoint(c.identifier, temperature, time.Now())
+
+	c.mutex.Lock()
+	c.points = append(c.points, point)
+	full := len(c.points) >= c.batchSize
+	c.mutex.Unlock()
+
+	if full {
+		select {
+		case c.flush <- true:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// ConsumeBatch writes the passed readings with their original timestamps immediately, bypassing
+// the buffer, and returns an error if they could not be written. Failed writes are not retried, as
+// the caller retries with its own backoff; readings rejected by the server are dropped.
+func (c *InfluxDBConsumer) ConsumeBatch(readings []Reading) error {
+	points := make([]string, len(readings))
+	for i, reading := range readings {
+		points[i] = formatInfluxDBPoint(c.identifier, reading.Temperature, reading.Timestamp)
+	}
+
+	retryable, err := c.write([]byte(strings.Join(points, "\n") + "\n"))
+	if err != nil && !retryable {
+		log.Printf("influxdb: dropping batch: device=%s points=%d error=%v", c.identifier, len(points), err)
+		return nil
+	}
+
+	return err
+}
+
+// Close stops the writer and writes any buffered readings.
+func (c *InfluxDBConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+
+	return nil
+}
+
+// run writes buffered readings when a batch is full or the flush interval elapses, until the
+// consumer is closed, then writes any readings that remain buffered.
+func (c *InfluxDBConsumer) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.flush:
+		case <-ticker.C:
+		case <-c.done:
+			c.writeBuffered()
+			return
+		}
+
+		c.writeBuffered()
+	}
+}
+
+// writeBuffered writes all buffered readings in batches. Batches that cannot be written are
+// dropped.
+func (c *InfluxDBConsumer) writeBuffered() {
+	c.mutex.Lock()
+	points := c.points
+	c.points = nil
+	c.mutex.Unlock()
+
+	for len(points) > 0 {
+		n := c.batchSize
+		if n > len(points) {
+			n = len(points)
+		}
+
+		batch := []byte(strings.Join(points[:n], "\n") + "\n")
+		if err := c.writeRetrying(batch); err != nil {
+			log.Printf("influxdb: dropping batch: device=%s points=%d error=%v", c.identifier, n, err)
+		}
+
+		points = points[n:]
+	}
+}
+
+// writeRetrying writes a batch, retrying with exponential backoff on network errors and server
+// errors. Retries stop once the consumer is closed.
+func (c *InfluxDBConsumer) writeRetrying(batch []byte) error {
+	delay := influxDBRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := c.write(batch)
+		if err == nil || !retryable || attempt == influxDBMaxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return fmt.Errorf("%v; not retrying after shutdown", err)
+		}
+
+		delay *= 2
+	}
+}
+
+// writeUDP writes a batch as datagrams of whole lines.
+func (c *InfluxDBConsumer) writeUDP(batch []byte) (bool, error) {
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > influxDBMaxDatagramSize {
+			// Split after the last complete line that fits. A single line longer than the maximum
+			// is sent alone.
+			if n = bytes.LastIndexByte(batch[:influxDBMaxDatagramSize], '\n') + 1; n == 0 {
+				n = bytes.IndexByte(batch, '\n') + 1
+			}
+		}
+
+		if _, err := c.conn.Write(batch[:n]); err != nil {
+			return true, fmt.Errorf("influxdb: %v", err)
+		}
+
+		batch = batch[n:]
+	}
+
+	return false, nil
+}
+
+// newInfluxDBHTTPWriter returns a function writing batches to the HTTP write API. Network errors and
+// server errors may be retried.
+func newInfluxDBHTTPWriter(cfg *InfluxDBConfig, u *url.URL) func(batch []byte) (bool, error) {
+	client := &http.Client{Timeout: influxDBRequestTimeout}
+
+	query := url.Values{"precision": {"ns"}}
+	endpoint := *u
+
+	if cfg.Bucket != "" {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/api/v2/write"
+		query.Set("org", cfg.Org)
+		query.Set("bucket", cfg.Bucket)
+	} else {
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/write"
+		query.Set("db", cfg.Database)
+		if cfg.RetentionPolicy != "" {
+			query.Set("rp", cfg.RetentionPolicy)
+		}
+	}
+
+	endpoint.RawQuery = query.Encode()
+	target := endpoint.String()
+
+	return func(batch []byte) (bool, error) {
+		req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(batch))
+		if err != nil {
+			return false, fmt.Errorf("influxdb: %v", err)
+		}
+
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if cfg.Token != "" {
+			req.Header.Set("Authorization", "Token "+cfg.Token)
+		} else if cfg.Username != "" {
+			req.SetBasicAuth(cfg.Username, cfg.Password)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return true, fmt.Errorf("influxdb: %v", err)
+		}
+
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return false, nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return true, fmt.Errorf("influxdb: unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+		default:
+			return false, fmt.Errorf("influxdb: unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+		}
+	}
+}
+
+// influxDBTagEscaper escapes characters that are special in line protocol tag keys and values.
+var influxDBTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// formatInfluxDBPoint formats a reading as a line protocol point, without a trailing newline.
+func formatInfluxDBPoint(identifier string, temperature float64, timestamp time.Time) string {
+	return influxDBMeasurement +
+		",device=" + influxDBTagEscaper.Replace(identifier) +
+		" " + influxDBField + "=" + strconv.FormatFloat(temperature, 'f', -1, 64) +
+		" " + strconv.FormatInt(timestamp.UnixNano(), 10)
+}
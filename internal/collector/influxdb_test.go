@@ -0,0 +1,290 @@
+package collector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxDBRecorder is an HTTP handler recording the write requests it receives. It fails the first
+// requests with the configured status.
+type influxDBRecorder struct {
+	// Number of requests to fail, and the status with which they are failed.
+	failures int
+	status   int
+	// Received requests and their bodies, including failed ones.
+	requests []*http.Request
+	bodies   []string
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func (r *influxDBRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "failed", r.status)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *influxDBRecorder) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.requests)
+}
+
+func TestInfluxDBHTTPWriterRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  InfluxDBConfig
+		// Expected path, query, and authorization header.
+		path          string
+		query         url.Values
+		authorization string
+	}{
+		{
+			name:  "v1",
+			cfg:   InfluxDBConfig{Database: "weather"},
+			path:  "/write",
+			query: url.Values{"db": {"weather"}, "precision": {"ns"}},
+		},
+		{
+			name:          "v1 with retention policy and basic auth",
+			cfg:           InfluxDBConfig{Database: "weather", RetentionPolicy: "week", Username: "user", Password: "secret"},
+			path:          "/write",
+			query:         url.Values{"db": {"weather"}, "rp": {"week"}, "precision": {"ns"}},
+			authorization: "Basic dXNlcjpzZWNyZXQ=",
+		},
+		{
+			name:          "v1 with token auth",
+			cfg:           InfluxDBConfig{Database: "weather", Username: "user", Password: "secret", Token: "user:secret"},
+			path:          "/write",
+			query:         url.Values{"db": {"weather"}, "precision": {"ns"}},
+			authorization: "Token user:secret",
+		},
+		{
+			name:          "v2",
+			cfg:           InfluxDBConfig{Org: "home", Bucket: "weather/autogen", Token: "token"},
+			path:          "/api/v2/write",
+			query:         url.Values{"org": {"home"}, "bucket": {"weather/autogen"}, "precision": {"ns"}},
+			authorization: "Token token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &influxDBRecorder{}
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			// Paths of URLs are preserved, e.g. for servers behind a reverse proxy.
+			u, _ := url.Parse(server.URL + "/influx/")
+			write := newInfluxDBHTTPWriter(&test.cfg, u)
+
+			if _, err := write([]byte("temperature,device=a celsius=21 1\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			req := recorder.requests[0]
+
+			if req.Method != http.MethodPost || req.URL.Path != "/influx"+test.path {
+				t.Errorf("got %s %s, want POST /influx%s", req.Method, req.URL.Path, test.path)
+			}
+
+			if query := req.URL.Query(); query.Encode() != test.query.Encode() {
+				t.Errorf("got query %v, want %v", query, test.query)
+			}
+
+			if authorization := req.Header.Get("Authorization"); authorization != test.authorization {
+				t.Errorf("got authorization %q, want %q", authorization, test.authorization)
+			}
+
+			if recorder.bodies[0] != "temperature,device=a celsius=21 1\n" {
+				t.Errorf("got body %q", recorder.bodies[0])
+			}
+		})
+	}
+}
+
+func TestInfluxDBHTTPWriterStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{status: http.StatusInternalServerError, retryable: true},
+		{status: http.StatusServiceUnavailable, retryable: true},
+		{status: http.StatusTooManyRequests, retryable: true},
+		{status: http.StatusBadRequest, retryable: false},
+		{status: http.StatusUnauthorized, retryable: false},
+		{status: http.StatusNotFound, retryable: false},
+	}
+
+	for _, test := range tests {
+		t.Run(http.StatusText(test.status), func(t *testing.T) {
+			server := httptest.NewServer(&influxDBRecorder{failures: 1, status: test.status})
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL)
+			write := newInfluxDBHTTPWriter(&InfluxDBConfig{Database: "weather"}, u)
+
+			retryable, err := write([]byte("temperature,device=a celsius=21 1\n"))
+			if err == nil {
+				t.Fatal("write succeeded, want an error")
+			}
+
+			if retryable != test.retryable {
+				t.Errorf("got retryable=%v, want %v", retryable, test.retryable)
+			}
+		})
+	}
+}
+
+// newTestInfluxDBConsumer creates a consumer writing each reading immediately to the server.
+func newTestInfluxDBConsumer(t *testing.T, server *httptest.Server) *InfluxDBConsumer {
+	c, err := NewInfluxDBConsumer("temper", &InfluxDBConfig{
+		URL:           server.URL,
+		Database:      "weather",
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestInfluxDBConsumerRetriesServerErrors(t *testing.T) {
+	recorder := &influxDBRecorder{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestInfluxDBConsumer(t, server)
+	c.Consume(21.5)
+
+	deadline := time.Now().Add(5 * influxDBRetryDelay)
+	for recorder.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Close()
+
+	if n := recorder.count(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestInfluxDBConsumerDropsClientErrors(t *testing.T) {
+	recorder := &influxDBRecorder{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestInfluxDBConsumer(t, server)
+	c.Consume(21.5)
+	c.Close()
+
+	if n := recorder.count(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestInfluxDBConsumerCloseStopsRetries(t *testing.T) {
+	recorder := &influxDBRecorder{failures: influxDBMaxAttempts, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestInfluxDBConsumer(t, server)
+	c.Consume(21.5)
+
+	for recorder.count() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	c.Close()
+
+	if elapsed := time.Since(start); elapsed > influxDBRetryDelay {
+		t.Errorf("close took %v, want less than %v", elapsed, influxDBRetryDelay)
+	}
+}
+
+func TestInfluxDBConsumerConsumeBatch(t *testing.T) {
+	readings := []Reading{
+		{Timestamp: time.Unix(1, 0), Temperature: 21},
+		{Timestamp: time.Unix(2, 0), Temperature: 21.5},
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		wantErr  bool
+		requests int
+	}{
+		{name: "success", requests: 1},
+		{name: "server error is returned without retrying", status: http.StatusServiceUnavailable, wantErr: true, requests: 1},
+		{name: "client error is dropped", status: http.StatusBadRequest, requests: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &influxDBRecorder{status: test.status}
+			if test.status != 0 {
+				recorder.failures = 1
+			}
+
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			c := newTestInfluxDBConsumer(t, server)
+			defer c.Close()
+
+			if err := c.ConsumeBatch(readings); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error=%v", err, test.wantErr)
+			}
+
+			if n := recorder.count(); n != test.requests {
+				t.Errorf("got %d requests, want %d", n, test.requests)
+			}
+
+			want := "temperature,device=temper celsius=21 1000000000\ntemperature,device=temper celsius=21.5 2000000000\n"
+			if recorder.bodies[0] != want {
+				t.Errorf("got body %q, want %q", recorder.bodies[0], want)
+			}
+		})
+	}
+}
+
+func TestFormatInfluxDBPoint(t *testing.T) {
+	timestamp := time.Unix(1577836800, 123456789)
+
+	tests := []struct {
+		identifier  string
+		temperature float64
+		want        string
+	}{
+		{identifier: "temper", temperature: 21.5, want: "temperature,device=temper celsius=21.5 1577836800123456789"},
+		{identifier: "living room", temperature: -3, want: `temperature,device=living\ room celsius=-3 1577836800123456789`},
+		{identifier: "a,b=c", temperature: 0.0625, want: `temperature,device=a\,b\=c celsius=0.0625 1577836800123456789`},
+		{identifier: "temper", temperature: 1e21, want: "temperature,device=temper celsius=1000000000000000000000 1577836800123456789"},
+	}
+
+	for _, test := range tests {
+		if got := formatInfluxDBPoint(test.identifier, test.temperature, timestamp); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// Timeout for connecting to the broker and for publishing on close.
+	mqttTimeout = 10 * time.Second
+	// Delay before the first retry of a failed initial connection, doubled after each failure.
+	mqttRetryDelay = 1 * time.Second
+	// Upper bound of the delay between connection attempts.
+	mqttMaxRetryDelay = 1 * time.Minute
+	// Interval at which a connection attempt in progress is checked for completion.
+	mqttPollInterval = 100 * time.Millisecond
+	// Availability payloads, as expected by Home Assistant by default.
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+// Default MQTT settings.
+const (
+	DefaultMQTTTopic             = "zephyrus/{{.Device}}/temperature"
+	DefaultMQTTAvailabilityTopic = "zephyrus/{{.Device}}/availability"
+	DefaultMQTTClientID          = "zephyrus-collector"
+	DefaultMQTTDiscoveryPrefix   = "homeassistant"
+)
+
+// MQTTConfig describes an MQTT broker to which readings are published.
+type MQTTConfig struct {
+	// URL of the broker, e.g. tcp://host:1883 or ssl://host:8883.
+	Broker string
+	// Prefix of the client identifier. The device identifier and a hash of the server are appended,
+	// so that the connection for each device is distinct.
+	ClientID string
+	// Address of the server supplying readings. Optional.
+	Server string
+	// Username and password. Optional.
+	Username string
+	Password string
+	// Template of the topic to which readings are published, in text/template syntax. The template
+	// is executed with an MQTTTopicData.
+	Topic string
+	// Template of the topic to which device availability is published, in text/template syntax.
+	AvailabilityTopic string
+	// Quality of service of published messages: 0, 1, or 2.
+	QoS byte
+	// Whether readings are published as retained messages.
+	Retain bool
+	// Whether Home Assistant MQTT discovery configuration is published.
+	Discovery bool
+	// Topic prefix of Home Assistant MQTT discovery configuration.
+	DiscoveryPrefix string
+}
+
+// MQTTTopicData is the data with which topic templates are executed.
+type MQTTTopicData struct {
+	// Device identifier, with characters that are special in topics replaced by underscores.
+	Device string
+}
+
+// mqttTopicSanitizer replaces characters that would introduce topic levels or wildcards.
+var mqttTopicSanitizer = strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_")
+
+// Validate returns an error if the configuration is not usable.
+func (c *MQTTConfig) Validate() error {
+	if c.Broker == "" {
+		return errors.New("mqtt: broker must be specified")
+	}
+
+	if c.QoS > 2 {
+		return errors.New("mqtt: QoS must be 0, 1, or 2")
+	}
+
+	if _, err := renderTopic(c.Topic, "device"); err != nil {
+		return err
+	}
+
+	if _, err := renderTopic(c.AvailabilityTopic, "device"); err != nil {
+		return err
+	}
+
+	if c.Discovery && c.DiscoveryPrefix == "" {
+		return errors.New("mqtt: discovery prefix must be specified")
+	}
+
+	return nil
+}
+
+// renderTopic renders a topic template for the specified device.
+func renderTopic(text string, identifier string) (string, error) {
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("mqtt: topic template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &MQTTTopicData{Device: mqttTopicSanitizer.Replace(identifier)}); err != nil {
+		return "", fmt.Errorf("mqtt: topic template: %v", err)
+	}
+
+	topic := buf.String()
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return "", fmt.Errorf("mqtt: topic template: invalid topic %q", topic)
+	}
+
+	return topic, nil
+}
+
+// mqttReading is the JSON payload of a published reading.
+type mqttReading struct {
+	Device    string  `json:"device"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// mqttDiscoveryDevice describes a device in Home Assistant MQTT discovery configuration.
+type mqttDiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// mqttDiscoveryConfig is the Home Assistant MQTT discovery configuration of a temperature sensor.
+type mqttDiscoveryConfig struct {
+	Name                string              `json:"name"`
+	UniqueID            string              `json:"unique_id"`
+	StateTopic          string              `json:"state_topic"`
+	AvailabilityTopic   string              `json:"availability_topic"`
+	PayloadAvailable    string              `json:"payload_available"`
+	PayloadNotAvailable string              `json:"payload_not_available"`
+	DeviceClass         string              `json:"device_class"`
+	UnitOfMeasurement   string              `json:"unit_of_measurement"`
+	ValueTemplate       string              `json:"value_template"`
+	Device              mqttDiscoveryDevice `json:"device"`
+}
+
+// MQTTConsumer is a consumer implementing the client.TemperatureConsumer interface for publishing
+// consumed temperatures to an MQTT broker as JSON. It implements StreamStatusObserver to publish
+// the availability of the device, which the broker also marks as offline if the collector
+// disconnects unexpectedly.
+type MQTTConsumer struct {
+	// Device identifier included in all readings.
+	identifier string
+	// MQTT client.
+	client mqtt.Client
+	// Topic to which readings are published.
+	topic string
+	// Topic to which device availability is published.
+	availabilityTopic string
+	// Topic and payload of Home Assistant MQTT discovery configuration, if enabled.
+	discoveryTopic string
+	discovery      []byte
+	// Quality of service and retain flag of readings.
+	qos    byte
+	retain bool
+	// Whether the stream is up, as last reported.
+	up bool
+	// Mutex used to synchronize access to the stream status.
+	mutex sync.Mutex
+	// Channel closed to stop connecting.
+	done chan bool
+	// Initial connection attempt, which may still be in progress after connecting was stopped.
+	connecting mqtt.Token
+	// Wait group tracking the connecting goroutine.
+	wg sync.WaitGroup
+	// Guards against closing more than once.
+	closeOnce sync.Once
+}
+
+// NewMQTTConsumer creates a new MQTT consumer using the specified device identifier and broker
+// configuration. The connection is established in the background, and readings consumed while
+// disconnected are dropped with an error.
+func NewMQTTConsumer(deviceIdentifier string, cfg *MQTTConfig) (*MQTTConsumer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// The templates were validated above.
+	topic, _ := renderTopic(cfg.Topic, deviceIdentifier)
+	availabilityTopic, _ := renderTopic(cfg.AvailabilityTopic, deviceIdentifier)
+
+	c := &MQTTConsumer{
+		identifier:        deviceIdentifier,
+		topic:             topic,
+		availabilityTopic: availabilityTopic,
+		qos:               cfg.QoS,
+		retain:            cfg.Retain,
+		done:              make(chan bool),
+	}
+
+	if cfg.Discovery {
+		objectID := "zephyrus_" + mqttTopicSanitizer.Replace(deviceIdentifier)
+		c.discoveryTopic = cfg.DiscoveryPrefix + "/sensor/" + objectID + "/temperature/config"
+
+		discovery, err := json.Marshal(&mqttDiscoveryConfig{
+			Name:                "Zephyrus " + deviceIdentifier + " temperature",
+			UniqueID:            objectID + "_temperature",
+			StateTopic:          topic,
+			AvailabilityTopic:   availabilityTopic,
+			PayloadAvailable:    mqttOnline,
+			PayloadNotAvailable: mqttOffline,
+			DeviceClass:         "temperature",
+			UnitOfMeasurement:   "°C",
+			ValueTemplate:       "{{ value_json.value }}",
+			Device: mqttDiscoveryDevice{
+				Identifiers:  []string{objectID},
+				Name:         "Zephyrus " + deviceIdentifier,
+				Manufacturer: "PCsensor",
+				Model:        "TEMPer",
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("mqtt: %v", err)
+		}
+
+		c.discovery = discovery
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(mqttClientID(cfg, deviceIdentifier)).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetMaxReconnectInterval(mqttMaxRetryDelay).
+		SetAutoReconnect(true).
+		SetWill(availabilityTopic, mqttOffline, cfg.QoS, true).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt: connection lost; reconnecting: device=%s error=%v", c.identifier, err)
+		})
+
+	c.client = mqtt.NewClient(opts)
+
+	c.wg.Add(1)
+	go c.connect(cfg.Broker)
+
+	return c, nil
+}
+
+// Consume publishes the passed temperature, timestamped now, and returns an error if it could not be
+// published. With a QoS above 0, it waits for the broker to acknowledge the reading.
+func (c *MQTTConsumer) Consume(temperature float64) error {
+	payload, err := json.Marshal(&mqttReading{
+		Device:    c.identifier,
+		Value:     temperature,
+		Unit:      "celsius",
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+
+	if !c.client.IsConnected() {
+		return errors.New("mqtt: not connected to the broker")
+	}
+
+	token := c.client.Publish(c.topic, c.qos, c.retain, payload)
+	if !token.WaitTimeout(mqttTimeout) {
+		return errors.New("mqtt: timed out publishing reading")
+	}
+
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+
+	return nil
+}
+
+// SetStreamUp publishes the availability of the device.
+func (c *MQTTConsumer) SetStreamUp(up bool) {
+	c.mutex.Lock()
+	c.up = up
+	c.mutex.Unlock()
+
+	if c.client.IsConnected() {
+		c.client.Publish(c.availabilityTopic, c.qos, true, availability(up))
+	}
+}
+
+// Close publishes the device as offline and disconnects from the broker.
+func (c *MQTTConsumer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+
+		// A connection attempt abandoned on close may still succeed, so it is waited for before
+		// disconnecting, which also stops the client from reconnecting.
+		if c.connecting != nil {
+			for deadline := time.Now().Add(mqttTimeout); time.Now().Before(deadline); {
+				// Tokens cannot fail while they are waited on, so they are waited on briefly at a time.
+				if c.connecting.WaitTimeout(mqttPollInterval) {
+					break
+				}
+			}
+		}
+
+		if c.client.IsConnected() {
+			c.client.Publish(c.availabilityTopic, c.qos, true, mqttOffline).WaitTimeout(mqttTimeout)
+		}
+
+		c.client.Disconnect(uint(mqttTimeout / time.Millisecond))
+	})
+
+	return nil
+}
+
+// connect establishes the initial connection to the broker, retrying with exponential backoff until
+// it succeeds or the consumer is closed. Once connected, the client reconnects automatically.
+func (c *MQTTConsumer) connect(broker string) {
+	defer c.wg.Done()
+
+	delay := mqttRetryDelay
+
+	for {
+		token := c.client.Connect()
+
+		// Tokens cannot be selected on, so the connection is polled to stop promptly on close.
+		for !token.WaitTimeout(mqttPollInterval) {
+			select {
+			case <-c.done:
+				c.connecting = token
+				return
+			default:
+			}
+		}
+
+		if token.Error() == nil {
+			return
+		}
+
+		log.Printf("mqtt: failed to connect: broker=%s device=%s error=%v", broker, c.identifier, token.Error())
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+
+		if delay *= 2; delay > mqttMaxRetryDelay {
+			delay = mqttMaxRetryDelay
+		}
+	}
+}
+
+// onConnect publishes the discovery configuration, if enabled, and the availability of the device
+// whenever a connection is established.
+func (c *MQTTConsumer) onConnect(client mqtt.Client) {
+	log.Printf("mqtt: connected: device=%s", c.identifier)
+
+	if c.discovery != nil {
+		client.Publish(c.discoveryTopic, c.qos, true, c.discovery)
+	}
+
+	c.mutex.Lock()
+	up := c.up
+	c.mutex.Unlock()
+
+	client.Publish(c.availabilityTopic, c.qos, true, availability(up))
+}
+
+// mqttClientID returns the client identifier of the connection for the specified device. Servers
+// may report the same device identifier, so a short hash of the server is included to keep their
+// connections from taking over each other's session.
+func mqttClientID(cfg *MQTTConfig, identifier string) string {
+	clientID := cfg.ClientID + "-" + mqttTopicSanitizer.Replace(identifier)
+
+	if cfg.Server != "" {
+		h := fnv.New32a()
+		h.Write([]byte(cfg.Server))
+		clientID += fmt.Sprintf("-%08x", h.Sum32())
+	}
+
+	return clientID
+}
+
+// availability returns the availability payload corresponding to the stream status.
+func availability(up bool) string {
+	if up {
+		return mqttOnline
+	}
+
+	return mqttOffline
+}
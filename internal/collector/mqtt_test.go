@@ -0,0 +1,331 @@
+package collector
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// mqttMessage is a message published to a testBroker.
+type mqttMessage struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// testBroker is an in-process stand-in for an MQTT broker. It accepts every connection and records
+// the CONNECT packets and messages it receives, acknowledging messages published with QoS 1.
+type testBroker struct {
+	listener net.Listener
+	// Delay before connections are acknowledged.
+	connackDelay time.Duration
+	// Received CONNECT packets, published messages, and number of DISCONNECT packets.
+	connects    []*packets.ConnectPacket
+	messages    []mqttMessage
+	disconnects int
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testBroker{listener: listener}
+	go b.serve()
+
+	return b
+}
+
+// url returns the broker URL of the stand-in.
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *testBroker) close() {
+	b.listener.Close()
+}
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go b.handle(conn)
+	}
+}
+
+func (b *testBroker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mutex.Lock()
+			b.connects = append(b.connects, p)
+			b.mutex.Unlock()
+
+			time.Sleep(b.connackDelay)
+
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			connack.ReturnCode = packets.Accepted
+			connack.Write(conn)
+		case *packets.PublishPacket:
+			b.mutex.Lock()
+			b.messages = append(b.messages, mqttMessage{topic: p.TopicName, payload: string(p.Payload), qos: p.Qos, retain: p.Retain})
+			b.mutex.Unlock()
+
+			if p.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				puback.Write(conn)
+			}
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			b.mutex.Lock()
+			b.disconnects++
+			b.mutex.Unlock()
+
+			return
+		}
+	}
+}
+
+// published returns the messages published to the topic.
+func (b *testBroker) published(topic string) []mqttMessage {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var messages []mqttMessage
+	for _, message := range b.messages {
+		if message.topic == topic {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+// waitFor waits until the condition holds, failing the test if it does not hold within a timeout.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func newTestMQTTConfig(broker string) *MQTTConfig {
+	return &MQTTConfig{
+		Broker:            broker,
+		ClientID:          DefaultMQTTClientID,
+		Topic:             DefaultMQTTTopic,
+		AvailabilityTopic: DefaultMQTTAvailabilityTopic,
+		QoS:               1,
+		DiscoveryPrefix:   DefaultMQTTDiscoveryPrefix,
+	}
+}
+
+func TestMQTTConsumerPublishes(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.close()
+
+	cfg := newTestMQTTConfig(broker.url())
+	cfg.Retain = true
+	cfg.Discovery = true
+
+	c, err := NewMQTTConsumer("living room", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The availability is published on connection, asynchronously.
+	waitFor(t, "availability", func() bool {
+		return len(broker.published("zephyrus/living_room/availability")) > 0
+	})
+
+	c.SetStreamUp(true)
+	if err := c.Consume(21.5); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+
+	broker.mutex.Lock()
+	connect := broker.connects[0]
+	broker.mutex.Unlock()
+
+	if connect.ClientIdentifier != "zephyrus-collector-living_room" {
+		t.Errorf("got client identifier %q", connect.ClientIdentifier)
+	}
+
+	if !connect.WillFlag || !connect.WillRetain || connect.WillTopic != "zephyrus/living_room/availability" || string(connect.WillMessage) != "offline" {
+		t.Errorf("got will %q: %q (retain=%v), want an offline availability", connect.WillTopic, connect.WillMessage, connect.WillRetain)
+	}
+
+	var availability []string
+	for _, message := range broker.published("zephyrus/living_room/availability") {
+		if !message.retain {
+			t.Errorf("availability %q is not retained", message.payload)
+		}
+
+		availability = append(availability, message.payload)
+	}
+
+	if len(availability) != 3 || availability[0] != "offline" || availability[1] != "online" || availability[2] != "offline" {
+		t.Errorf("got availability %v, want [offline online offline]", availability)
+	}
+
+	readings := broker.published("zephyrus/living_room/temperature")
+	if len(readings) != 1 || readings[0].qos != 1 || !readings[0].retain {
+		t.Fatalf("got readings %+v, want a single retained reading with QoS 1", readings)
+	}
+
+	var reading mqttReading
+	if err := json.Unmarshal([]byte(readings[0].payload), &reading); err != nil {
+		t.Fatal(err)
+	}
+
+	if reading.Device != "living room" || reading.Value != 21.5 || reading.Unit != "celsius" {
+		t.Errorf("got reading %+v", reading)
+	}
+
+	discovery := broker.published("homeassistant/sensor/zephyrus_living_room/temperature/config")
+	if len(discovery) != 1 || !discovery[0].retain {
+		t.Fatalf("got discovery configuration %+v, want a single retained message", discovery)
+	}
+
+	var config mqttDiscoveryConfig
+	if err := json.Unmarshal([]byte(discovery[0].payload), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.StateTopic != "zephyrus/living_room/temperature" || config.AvailabilityTopic != "zephyrus/living_room/availability" {
+		t.Errorf("got discovery topics %q and %q", config.StateTopic, config.AvailabilityTopic)
+	}
+}
+
+func TestMQTTConsumerDisconnected(t *testing.T) {
+	// A broker that is no longer listening refuses connections.
+	broker := newTestBroker(t)
+	broker.close()
+
+	c, err := NewMQTTConsumer("temper", newTestMQTTConfig(broker.url()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Consume(21.5); err == nil {
+		t.Error("consume succeeded while disconnected, want an error")
+	}
+}
+
+func TestMQTTConsumerCloseWhileConnecting(t *testing.T) {
+	broker := newTestBroker(t)
+	broker.connackDelay = 500 * time.Millisecond
+	defer broker.close()
+
+	c, err := NewMQTTConsumer("temper", newTestMQTTConfig(broker.url()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "connection", func() bool {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+
+		return len(broker.connects) > 0
+	})
+
+	// The connection completes after close, and is disconnected rather than left reconnecting.
+	c.Close()
+
+	waitFor(t, "disconnection", func() bool {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+
+		return broker.disconnects == 1
+	})
+}
+
+func TestMQTTConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*MQTTConfig)
+		valid  bool
+	}{
+		{name: "default", modify: func(*MQTTConfig) {}, valid: true},
+		{name: "no broker", modify: func(c *MQTTConfig) { c.Broker = "" }},
+		{name: "QoS above 2", modify: func(c *MQTTConfig) { c.QoS = 3 }},
+		{name: "invalid topic template", modify: func(c *MQTTConfig) { c.Topic = "{{.Device" }},
+		{name: "unknown template field", modify: func(c *MQTTConfig) { c.Topic = "{{.Missing}}" }},
+		{name: "wildcard in topic", modify: func(c *MQTTConfig) { c.AvailabilityTopic = "zephyrus/+/availability" }},
+		{name: "discovery without a prefix", modify: func(c *MQTTConfig) { c.Discovery, c.DiscoveryPrefix = true, "" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newTestMQTTConfig("tcp://broker:1883")
+			test.modify(cfg)
+
+			if err := cfg.Validate(); (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid=%v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestRenderTopic(t *testing.T) {
+	topic, err := renderTopic(DefaultMQTTTopic, "a/b+c#d e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if topic != "zephyrus/a_b_c_d_e/temperature" {
+		t.Errorf("got topic %q", topic)
+	}
+}
+
+func TestMQTTClientID(t *testing.T) {
+	cfg := newTestMQTTConfig("tcp://broker:1883")
+
+	if clientID := mqttClientID(cfg, "living room"); clientID != "zephyrus-collector-living_room" {
+		t.Errorf("got client identifier %q without a server", clientID)
+	}
+
+	// Servers reporting the same device identifier connect with distinct client identifiers.
+	clientIDs := make(map[string]bool)
+	for _, server := range []string{"sensor-a:6840", "sensor-b:6840"} {
+		cfg.Server = server
+
+		clientID := mqttClientID(cfg, "temper")
+		if !strings.HasPrefix(clientID, "zephyrus-collector-temper-") {
+			t.Errorf("got client identifier %q for server %s", clientID, server)
+		}
+
+		clientIDs[clientID] = true
+	}
+
+	if len(clientIDs) != 2 {
+		t.Errorf("got client identifiers %v, want one per server", clientIDs)
+	}
+}
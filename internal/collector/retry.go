@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryPolicy is the retry policy used by streams unless otherwise configured.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval:  1 * time.Second,
+	MaxInterval:      1 * time.Minute,
+	Multiplier:       2,
+	Jitter:           0.2,
+	FailureThreshold: 5,
+}
+
+// RetryPolicy describes how a stream retries after connection and stream errors. The interval
+// between retries grows exponentially from the initial interval up to the maximum interval, and is
+// randomized by the jitter so that collectors do not retry in lockstep. After the failure threshold
+// of consecutive failures, the stream's circuit opens: errors are no longer logged individually, and
+// retries are made at the maximum interval until one succeeds.
+type RetryPolicy struct {
+	// Interval before the first retry.
+	InitialInterval time.Duration
+	// Upper bound of the interval between retries.
+	MaxInterval time.Duration
+	// Factor by which the interval grows after each consecutive failure.
+	Multiplier float64
+	// Fraction of the interval, between 0 and 1, by which it is randomly lengthened or shortened.
+	Jitter float64
+	// Number of consecutive failures after which the circuit opens.
+	FailureThreshold int
+}
+
+// Validate returns an error if the policy is not usable.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.InitialInterval <= 0:
+		return errors.New("retry: initial interval must be positive")
+	case p.MaxInterval < p.InitialInterval:
+		return errors.New("retry: maximum interval must not be less than the initial interval")
+	case p.Multiplier < 1:
+		return errors.New("retry: multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("retry: jitter must be between 0 and 1")
+	case p.FailureThreshold < 1:
+		return errors.New("retry: failure threshold must be at least 1")
+	}
+
+	return nil
+}
+
+// interval returns the randomized interval to wait after the specified number of consecutive
+// failures, which must be at least 1. While the circuit is open, retries are made at the maximum
+// interval regardless of the number of failures.
+func (p RetryPolicy) interval(failures int, state circuitState, rng *rand.Rand) time.Duration {
+	interval := float64(p.MaxInterval)
+	if state == circuitClosed {
+		interval = math.Min(float64(p.InitialInterval)*math.Pow(p.Multiplier, float64(failures-1)), interval)
+	}
+
+	// Scale by a uniformly random factor in [1 - jitter, 1 + jitter).
+	interval *= 1 + p.Jitter*(2*rng.Float64()-1)
+	if interval > float64(p.MaxInterval) {
+		interval = float64(p.MaxInterval)
+	}
+
+	return time.Duration(interval)
+}
+
+// circuitState is the state of a stream's circuit breaker.
+type circuitState int
+
+const (
+	// circuitClosed is the state of a healthy stream, whose errors are retried with backoff.
+	circuitClosed circuitState = iota
+	// circuitOpen is the state of a stream that has failed repeatedly, which is retried only at the
+	// maximum interval.
+	circuitOpen
+	// circuitHalfOpen is the state of an open stream while a retry is in progress. The circuit
+	// closes if the retry succeeds, and reopens otherwise.
+	circuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker tracks consecutive failures of a stream. It is not safe for concurrent use.
+type circuitBreaker struct {
+	// Number of consecutive failures since the last success.
+	failures int
+	// Current state.
+	state circuitState
+}
+
+// failure records a failure against the threshold, returning whether the state changed.
+func (b *circuitBreaker) failure(threshold int) bool {
+	b.failures++
+
+	switch {
+	case b.state == circuitHalfOpen, b.state == circuitClosed && b.failures >= threshold:
+		b.state = circuitOpen
+		return true
+	default:
+		return false
+	}
+}
+
+// retry records that a retry is starting, returning whether the state changed.
+func (b *circuitBreaker) retry() bool {
+	if b.state != circuitOpen {
+		return false
+	}
+
+	b.state = circuitHalfOpen
+	return true
+}
+
+// success records a success, clearing all failures, and returns whether the state changed.
+func (b *circuitBreaker) success() bool {
+	b.failures = 0
+
+	if b.state == circuitClosed {
+		return false
+	}
+
+	b.state = circuitClosed
+	return true
+}
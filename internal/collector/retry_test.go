@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyInterval(t *testing.T) {
+	policy := DefaultRetryPolicy
+
+	tests := []struct {
+		name     string
+		failures int
+		state    circuitState
+		// Bounds of the randomized interval.
+		min time.Duration
+		max time.Duration
+	}{
+		{name: "first failure", failures: 1, state: circuitClosed, min: 800 * time.Millisecond, max: 1200 * time.Millisecond},
+		{name: "exponential growth", failures: 3, state: circuitClosed, min: 3200 * time.Millisecond, max: 4800 * time.Millisecond},
+		{name: "capped at the maximum", failures: 10, state: circuitClosed, min: 48 * time.Second, max: time.Minute},
+		{name: "open circuit", failures: 5, state: circuitOpen, min: 48 * time.Second, max: time.Minute},
+		{name: "half-open circuit", failures: 6, state: circuitHalfOpen, min: 48 * time.Second, max: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+
+			for i := 0; i < 1000; i++ {
+				interval := policy.interval(test.failures, test.state, rng)
+				if interval < test.min || interval > test.max {
+					t.Fatalf("got interval %v, want between %v and %v", interval, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyIntervalWithoutJitter(t *testing.T) {
+	policy := RetryPolicy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 3, FailureThreshold: 3}
+	rng := rand.New(rand.NewSource(1))
+
+	want := []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := policy.interval(i+1, circuitClosed, rng); got != w {
+			t.Errorf("failure %d: got interval %v, want %v", i+1, got, w)
+		}
+	}
+
+	if got := policy.interval(1, circuitOpen, rng); got != 10*time.Second {
+		t.Errorf("got interval %v with the circuit open, want %v", got, 10*time.Second)
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*RetryPolicy)
+		valid  bool
+	}{
+		{name: "default", modify: func(*RetryPolicy) {}, valid: true},
+		{name: "zero initial interval", modify: func(p *RetryPolicy) { p.InitialInterval = 0 }},
+		{name: "maximum below initial", modify: func(p *RetryPolicy) { p.MaxInterval = p.InitialInterval / 2 }},
+		{name: "multiplier below 1", modify: func(p *RetryPolicy) { p.Multiplier = 0.5 }},
+		{name: "negative jitter", modify: func(p *RetryPolicy) { p.Jitter = -0.1 }},
+		{name: "jitter above 1", modify: func(p *RetryPolicy) { p.Jitter = 1.5 }},
+		{name: "zero failure threshold", modify: func(p *RetryPolicy) { p.FailureThreshold = 0 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := DefaultRetryPolicy
+			test.modify(&policy)
+
+			if err := policy.Validate(); (err == nil) != test.valid {
+				t.Errorf("got error %v, want valid=%v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	// Each step applies an event to the breaker, with the expected result and resulting state.
+	steps := []struct {
+		event   string
+		changed bool
+		state   circuitState
+	}{
+		{event: "failure", changed: false, state: circuitClosed},
+		{event: "retry", changed: false, state: circuitClosed},
+		{event: "failure", changed: false, state: circuitClosed},
+		{event: "failure", changed: true, state: circuitOpen},
+		{event: "failure", changed: false, state: circuitOpen},
+		{event: "retry", changed: true, state: circuitHalfOpen},
+		{event: "failure", changed: true, state: circuitOpen},
+		{event: "retry", changed: true, state: circuitHalfOpen},
+		{event: "success", changed: true, state: circuitClosed},
+		{event: "success", changed: false, state: circuitClosed},
+		{event: "failure", changed: false, state: circuitClosed},
+	}
+
+	var b circuitBreaker
+	for i, step := range steps {
+		var changed bool
+		switch step.event {
+		case "failure":
+			changed = b.failure(3)
+		case "retry":
+			changed = b.retry()
+		case "success":
+			changed = b.success()
+		}
+
+		if changed != step.changed || b.state != step.state {
+			t.Fatalf("step %d (%s): got changed=%v state=%v, want changed=%v state=%v",
+				i, step.event, changed, b.state, step.changed, step.state)
+		}
+	}
+
+	// Failures restart from zero after a success.
+	if b.failures != 1 {
+		t.Errorf("got %d failures, want 1", b.failures)
+	}
+}
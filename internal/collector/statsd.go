@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"lib.kevinlin.info/aperture"
+)
+
+// StatsdClient is an aperture.Statsd client whose remote address and tag format may be changed at
+// runtime, so that many consumers can share one client that follows configuration changes. It is
+// safe for concurrent use.
+type StatsdClient struct {
+	// Remote statsd address.
+	addr string
+	// Format in which tags are encoded.
+	format TagFormat
+	// Backing statsd client for the current address.
+	client aperture.Statsd
+	// Mutex used to synchronize access to the backing client across address changes.
+	mutex sync.RWMutex
+}
+
+// NewStatsdClient creates a client emitting metrics to the specified remote statsd address, with
+// tags in the specified format.
+func NewStatsdClient(addr string, format TagFormat) (*StatsdClient, error) {
+	client, err := newStatsdBackend(addr, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsdClient{addr: addr, format: format, client: client}, nil
+}
+
+// SetTarget directs all subsequent metrics to a remote statsd address, with tags in the specified
+// format. Metrics buffered for the previous address are flushed to it before it is closed.
+func (c *StatsdClient) SetTarget(addr string, format TagFormat) error {
+	client, err := newStatsdBackend(addr, format)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	previous, previousAddr, previousFormat := c.client, c.addr, c.format
+	c.client, c.addr, c.format = client, addr, format
+	c.mutex.Unlock()
+
+	log.Printf(
+		"statsd: changed target: from=%s (%s) to=%s (%s)",
+		previousAddr,
+		previousFormat,
+		addr,
+		format,
+	)
+
+	return closeStatsd(previous)
+}
+
+// Count emits a counter metric.
+func (c *StatsdClient) Count(metric string, value int64, tags map[string]interface{}) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	c.client.Count(metric, value, tags)
+}
+
+// Gauge emits a gauge metric.
+func (c *StatsdClient) Gauge(metric string, value float64, tags map[string]interface{}) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	c.client.Gauge(metric, value, tags)
+}
+
+// Timing emits a timing metric.
+func (c *StatsdClient) Timing(metric string, value int64, tags map[string]interface{}) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	c.client.Timing(metric, value, tags)
+}
+
+// Close flushes and closes the backing client.
+func (c *StatsdClient) Close() error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return closeStatsd(c.client)
+}
+
+// newStatsdBackend creates a client for the specified address and tag format under the global
+// metric namespace. InfluxDB-style tags are emitted by aperture; other formats by a UDPStatsd.
+func newStatsdBackend(addr string, format TagFormat) (aperture.Statsd, error) {
+	if format == TagFormatInfluxDB {
+		return newApertureClient(addr)
+	}
+
+	return NewUDPStatsd(addr, GlobalMetricNamespace, format)
+}
+
+// newApertureClient creates an aperture client for the specified address under the global metric
+// namespace.
+func newApertureClient(addr string) (aperture.Statsd, error) {
+	client, err := aperture.NewClient(&aperture.Config{
+		Address: addr,
+		Prefix:  GlobalMetricNamespace,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+
+	return client, nil
+}
+
+// closeStatsd flushes and closes an aperture client, if it supports doing so.
+func closeStatsd(client aperture.Statsd) error {
+	if closer, ok := client.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
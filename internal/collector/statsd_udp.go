@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// UDPStatsd is an aperture.Statsd client that sends each metric in its own UDP datagram, with tags
+// in a configurable format. It is safe for concurrent use.
+type UDPStatsd struct {
+	// Connection to the remote statsd server.
+	conn net.Conn
+	// Prefix prepended to every metric name, if any.
+	prefix string
+	// Format in which tags are encoded.
+	format TagFormat
+	// Mutex used to serialize writes to the connection.
+	mutex sync.Mutex
+}
+
+// NewUDPStatsd creates a client sending metrics to the specified remote statsd address, prefixing
+// every metric name with the prefix, if nonempty, followed by a dot.
+func NewUDPStatsd(addr string, prefix string, format TagFormat) (*UDPStatsd, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+
+	return &UDPStatsd{conn: conn, prefix: prefix, format: format}, nil
+}
+
+// Count emits a counter metric.
+func (s *UDPStatsd) Count(metric string, value int64, tags map[string]interface{}) {
+	s.send(metric, formatInt(value), statsdCount, tags)
+}
+
+// Gauge emits a gauge metric.
+func (s *UDPStatsd) Gauge(metric string, value float64, tags map[string]interface{}) {
+	s.send(metric, formatFloat(value), statsdGauge, tags)
+}
+
+// Timing emits a timing metric, in milliseconds.
+func (s *UDPStatsd) Timing(metric string, value int64, tags map[string]interface{}) {
+	s.send(metric, formatInt(value), statsdTiming, tags)
+}
+
+// Close closes the connection.
+func (s *UDPStatsd) Close() error {
+	return s.conn.Close()
+}
+
+// send formats and sends a metric. Metrics are sent on a best-effort basis, so write errors, which
+// are typically caused by the server not listening, are ignored.
+func (s *UDPStatsd) send(metric string, value string, metricType string, tags map[string]interface{}) {
+	if s.prefix != "" {
+		metric = s.prefix + "." + metric
+	}
+
+	line := formatMetric(s.format, metric, value, metricType, tags)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conn.Write([]byte(line))
+}
@@ -0,0 +1,387 @@
+package collector
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"zephyrus/internal/client"
+	"zephyrus/schemas"
+
+	"lib.kevinlin.info/aperture"
+)
+
+// ConsumerFactory creates the consumer of temperatures read from the device with the specified
+// identifier. If the consumer implements io.Closer, it is closed when the stream stops, and if it
+// implements StreamStatusObserver, it is notified when the stream goes up or down.
+type ConsumerFactory func(identifier string) (client.TemperatureConsumer, error)
+
+// StreamStatusObserver is implemented by consumers that track whether the stream supplying them
+// with readings is up, e.g. to publish the availability of the device.
+type StreamStatusObserver interface {
+	// SetStreamUp is called with true when the stream starts delivering readings, and with false
+	// when it fails.
+	SetStreamUp(up bool)
+}
+
+// StreamConfig describes a stream of temperatures from a server.
+type StreamConfig struct {
+	// Server-side sample rate requested for the stream.
+	SampleRate float64
+	// Policy for retrying after errors.
+	Retry RetryPolicy
+	// Factory creating the consumer of streamed temperatures.
+	Factory ConsumerFactory
+	// Client to which the stream's own metrics, such as reconnections, are emitted. Optional.
+	Statsd aperture.Statsd
+}
+
+// streamClient is the subset of the Zephyrus client used by a stream.
+type streamClient interface {
+	GetIdentifier() (string, error)
+	GetStatus() (schemas.Status, error)
+	StreamTemperatureContext(ctx context.Context, sampleRate float64, consumer client.TemperatureConsumer) error
+	Close() error
+}
+
+// zephyrusStreamClient adapts a ZephyrusClient to the streamClient interface.
+type zephyrusStreamClient struct {
+	*client.ZephyrusClient
+}
+
+// GetIdentifier reads the identifier of the device attached to the server.
+func (c zephyrusStreamClient) GetIdentifier() (string, error) {
+	return c.DeviceInfo.GetIdentifier()
+}
+
+// GetStatus reads the status of the device attached to the server.
+func (c zephyrusStreamClient) GetStatus() (schemas.Status, error) {
+	return c.DeviceInfo.GetStatus()
+}
+
+// StreamTemperatureContext streams temperatures to the consumer until the stream fails or the
+// context is done.
+func (c zephyrusStreamClient) StreamTemperatureContext(ctx context.Context, sampleRate float64, consumer client.TemperatureConsumer) error {
+	return c.Weather.StreamTemperatureContext(ctx, sampleRate, consumer)
+}
+
+// Stream supervises the temperature stream from a single Zephyrus server, reconnecting after errors
+// until it is stopped. It is safe for concurrent use.
+type Stream struct {
+	// Address of the server.
+	addr string
+	// Client connected to the server.
+	client streamClient
+	// Factory creating the consumer of streamed temperatures.
+	factory ConsumerFactory
+	// Client to which the stream's own metrics are emitted, if any.
+	statsd aperture.Statsd
+	// Server-side sample rate requested for the stream.
+	sampleRate float64
+	// Policy for retrying after errors.
+	retry RetryPolicy
+	// Mutex used to synchronize access to the sample rate and retry policy.
+	mutex sync.Mutex
+	// Channel signaled to restart the active stream, e.g. to apply a new sample rate.
+	restart chan bool
+	// Circuit breaker tracking consecutive failures. Only accessed by the goroutine calling Run.
+	breaker circuitBreaker
+	// Source of retry jitter. Only accessed by the goroutine calling Run.
+	rng *rand.Rand
+	// Identifier of the device attached to the server, once known. Only accessed by the goroutine
+	// calling Run.
+	identifier string
+	// Status of the device attached to the server, as of the last time the stream was established.
+	// Only accessed by the goroutine calling Run.
+	status schemas.Status
+	// Consumer of streamed temperatures, once created. Only accessed by the goroutine calling Run.
+	consumer client.TemperatureConsumer
+	// Whether the stream is delivering readings to the consumer. Only accessed by the goroutine
+	// calling Run.
+	up bool
+}
+
+// NewStream creates a stream of temperatures from the server at the specified address, passing
+// them to a consumer created by the configured factory once the device identifier is known.
+func NewStream(addr string, cfg *StreamConfig, opts ...client.Option) (*Stream, error) {
+	if err := cfg.Retry.Validate(); err != nil {
+		return nil, err
+	}
+
+	zephyrus, err := client.NewZephyrusClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStream(addr, cfg, zephyrusStreamClient{zephyrus}), nil
+}
+
+// newStream creates a stream of temperatures from the server at the specified address, read using
+// the client.
+func newStream(addr string, cfg *StreamConfig, c streamClient) *Stream {
+	return &Stream{
+		addr:       addr,
+		client:     c,
+		factory:    cfg.Factory,
+		statsd:     cfg.Statsd,
+		sampleRate: cfg.SampleRate,
+		retry:      cfg.Retry,
+		restart:    make(chan bool, 1),
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Addr returns the address of the server.
+func (s *Stream) Addr() string {
+	return s.addr
+}
+
+// SampleRate returns the server-side sample rate requested for the stream.
+func (s *Stream) SampleRate() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sampleRate
+}
+
+// SetSampleRate changes the server-side sample rate, restarting the active stream to apply it.
+func (s *Stream) SetSampleRate(sampleRate float64) {
+	s.mutex.Lock()
+	changed := s.sampleRate != sampleRate
+	s.sampleRate = sampleRate
+	s.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	select {
+	case s.restart <- true:
+	default:
+	}
+}
+
+// RetryPolicy returns the policy for retrying after errors.
+func (s *Stream) RetryPolicy() RetryPolicy {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.retry
+}
+
+// SetRetryPolicy changes the policy for retrying after errors, taking effect from the next retry.
+func (s *Stream) SetRetryPolicy(policy RetryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.retry = policy
+
+	return nil
+}
+
+// Run streams temperatures until the context is done, then closes the consumer, flushing any
+// pending readings, and the connection to the server. Errors are retried according to the retry
+// policy.
+//
+// The device identifier and status are read each time the stream is established, so that a server
+// restarted with a different identifier or attached to a different device is detected. When the
+// identifier changes, the consumer is closed and a new one is created for the new identifier.
+func (s *Stream) Run(ctx context.Context) {
+	defer s.client.Close()
+
+	defer func() {
+		s.closeConsumer(s.consumer)
+	}()
+
+	for ctx.Err() == nil {
+		identifier, err := s.client.GetIdentifier()
+		if err != nil {
+			s.fail(ctx, "failed to read device identifier", err)
+			continue
+		}
+
+		status, err := s.client.GetStatus()
+		if err != nil {
+			s.fail(ctx, "failed to read device status", err)
+			continue
+		}
+
+		if s.identifier != "" && identifier != s.identifier {
+			log.Printf("stream: device identifier changed: server=%s from=%s to=%s", s.addr, s.identifier, identifier)
+
+			s.closeConsumer(s.consumer)
+			s.consumer = nil
+		}
+
+		if identifier != s.identifier || status != s.status {
+			log.Printf("stream: device metadata: server=%s device=%s status=%s", s.addr, identifier, status)
+		}
+
+		s.identifier = identifier
+		s.status = status
+
+		if s.consumer == nil {
+			consumer, err := s.factory(identifier)
+			if err != nil {
+				s.fail(ctx, "failed to create consumer", err)
+				continue
+			}
+
+			s.consumer = consumer
+			s.up = false
+
+			log.Printf("stream: starting collection: server=%s device=%s", s.addr, identifier)
+		}
+
+		if err := s.stream(ctx, s.consumer); err != nil && ctx.Err() == nil {
+			s.fail(ctx, "temperature stream error", err)
+		}
+	}
+}
+
+// closeConsumer closes the consumer, if it supports doing so, flushing any pending readings.
+func (s *Stream) closeConsumer(consumer client.TemperatureConsumer) {
+	if closer, ok := consumer.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("stream: failed to close consumer: server=%s device=%s error=%v", s.addr, s.identifier, err)
+		}
+	}
+}
+
+// stream streams temperatures to the consumer until the stream fails, the context is done, or the
+// stream is restarted. A restarted stream returns a nil error.
+func (s *Stream) stream(ctx context.Context, consumer client.TemperatureConsumer) error {
+	// The sample rate read below is current, so pending restarts are obsolete.
+	select {
+	case <-s.restart:
+	default:
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	restarted := make(chan bool)
+	go func() {
+		select {
+		case <-s.restart:
+			close(restarted)
+			cancel()
+		case <-streamCtx.Done():
+		}
+	}()
+
+	sampleRate := s.SampleRate()
+	err := s.client.StreamTemperatureContext(streamCtx, sampleRate, &streamConsumer{s, consumer})
+
+	select {
+	case <-restarted:
+		log.Printf("stream: restarting stream to apply sample rate: server=%s sample rate=%f", s.addr, s.SampleRate())
+		return nil
+	default:
+		return err
+	}
+}
+
+// succeed records that data was received, resetting the backoff and closing the circuit.
+func (s *Stream) succeed() {
+	s.setUp(true)
+
+	if s.breaker.success() {
+		log.Printf("stream: circuit closed; stream recovered: server=%s device=%s", s.addr, s.identifier)
+		s.gauge("collector.stream.circuit_open", 0)
+	}
+}
+
+// fail records a failure, then waits before retrying according to the retry policy, returning early
+// if the context is done. Failures are logged individually only while the circuit is closed.
+func (s *Stream) fail(ctx context.Context, msg string, err error) {
+	policy := s.RetryPolicy()
+
+	s.setUp(false)
+	s.count("collector.stream.error")
+
+	opened := s.breaker.failure(policy.FailureThreshold)
+	if opened {
+		log.Printf(
+			"stream: circuit opened; retrying every %v: server=%s consecutive failures=%d error=%v",
+			policy.MaxInterval,
+			s.addr,
+			s.breaker.failures,
+			err,
+		)
+		s.gauge("collector.stream.circuit_open", 1)
+	} else if s.breaker.state == circuitClosed {
+		log.Printf("stream: %s: server=%s error=%v", msg, s.addr, err)
+	}
+
+	select {
+	case <-time.After(policy.interval(s.breaker.failures, s.breaker.state, s.rng)):
+	case <-ctx.Done():
+		return
+	}
+
+	if s.breaker.retry() {
+		log.Printf("stream: circuit half-open; retrying: server=%s", s.addr)
+	}
+
+	s.count("collector.stream.reconnect")
+}
+
+// setUp records whether the stream is delivering readings, notifying the consumer of changes if
+// it is a StreamStatusObserver.
+func (s *Stream) setUp(up bool) {
+	if up == s.up {
+		return
+	}
+
+	s.up = up
+
+	if observer, ok := s.consumer.(StreamStatusObserver); ok {
+		observer.SetStreamUp(up)
+	}
+}
+
+// count emits a counter self-metric, if a statsd client is configured.
+func (s *Stream) count(metric string) {
+	if s.statsd != nil {
+		s.statsd.Count(metric, 1, s.tags())
+	}
+}
+
+// gauge emits a gauge self-metric, if a statsd client is configured.
+func (s *Stream) gauge(metric string, value float64) {
+	if s.statsd != nil {
+		s.statsd.Gauge(metric, value, s.tags())
+	}
+}
+
+// tags returns the tags attached to the stream's self-metrics.
+func (s *Stream) tags() map[string]interface{} {
+	tags := map[string]interface{}{"server": s.addr}
+	if s.identifier != "" {
+		tags["device"] = s.identifier
+	}
+
+	return tags
+}
+
+// streamConsumer passes streamed temperatures to a consumer, recording each as a success of the
+// stream.
+type streamConsumer struct {
+	stream   *Stream
+	consumer client.TemperatureConsumer
+}
+
+// Consume records the success and passes the temperature to the consumer.
+func (c *streamConsumer) Consume(temperature float64) error {
+	c.stream.succeed()
+
+	return c.consumer.Consume(temperature)
+}
@@ -0,0 +1,220 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"zephyrus/internal/client"
+	"zephyrus/schemas"
+)
+
+// fakeAttempt describes the server as seen by one attempt to establish a stream.
+type fakeAttempt struct {
+	// Error reading the device identifier, e.g. because the server is unreachable.
+	err error
+	// Identifier of the attached device, and the temperatures streamed from it. The stream then
+	// fails, unless this is the last attempt, whose stream continues until the context is done.
+	identifier   string
+	temperatures []float64
+}
+
+// fakeStreamClient is a streamClient replaying a sequence of attempts.
+type fakeStreamClient struct {
+	attempts []fakeAttempt
+	// Number of attempts made, each starting by reading the identifier, and whether the client was
+	// closed.
+	calls  int
+	closed bool
+	// Mutex used to synchronize access to the attempt and closed state.
+	mutex sync.Mutex
+}
+
+// current returns the current attempt, and whether it is the last. The last attempt is repeated.
+func (c *fakeStreamClient) current() (fakeAttempt, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.calls >= len(c.attempts) {
+		return c.attempts[len(c.attempts)-1], true
+	}
+
+	return c.attempts[c.calls-1], c.calls == len(c.attempts)
+}
+
+func (c *fakeStreamClient) GetIdentifier() (string, error) {
+	c.mutex.Lock()
+	c.calls++
+	c.mutex.Unlock()
+
+	attempt, _ := c.current()
+
+	return attempt.identifier, attempt.err
+}
+
+func (c *fakeStreamClient) GetStatus() (schemas.Status, error) {
+	return schemas.Status_OPENED, nil
+}
+
+func (c *fakeStreamClient) StreamTemperatureContext(ctx context.Context, sampleRate float64, consumer client.TemperatureConsumer) error {
+	attempt, last := c.current()
+
+	for _, temperature := range attempt.temperatures {
+		if err := consumer.Consume(temperature); err != nil {
+			return err
+		}
+	}
+
+	if !last {
+		return errors.New("connection reset")
+	}
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func (c *fakeStreamClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+// streamRecorder is a consumer recording the temperatures it consumes and the stream status
+// notifications it receives.
+type streamRecorder struct {
+	identifier   string
+	temperatures []float64
+	ups          []bool
+	closed       bool
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func (r *streamRecorder) Consume(temperature float64) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.temperatures = append(r.temperatures, temperature)
+
+	return nil
+}
+
+func (r *streamRecorder) SetStreamUp(up bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.ups = append(r.ups, up)
+}
+
+func (r *streamRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+
+	return nil
+}
+
+func TestStreamReconnectsWithNewIdentifier(t *testing.T) {
+	fake := &fakeStreamClient{
+		attempts: []fakeAttempt{
+			{identifier: "temper-a", temperatures: []float64{1, 2}},
+			{err: errors.New("connection refused")},
+			{identifier: "temper-b", temperatures: []float64{3, 4}},
+		},
+	}
+
+	var consumers []*streamRecorder
+	var mutex sync.Mutex
+
+	statsd := &statsdRecorder{}
+	s := newStream("sensor:6840", &StreamConfig{
+		Retry: RetryPolicy{
+			InitialInterval:  10 * time.Millisecond,
+			MaxInterval:      10 * time.Millisecond,
+			Multiplier:       1,
+			FailureThreshold: 2,
+		},
+		Factory: func(identifier string) (client.TemperatureConsumer, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			consumer := &streamRecorder{identifier: identifier}
+			consumers = append(consumers, consumer)
+
+			return consumer, nil
+		},
+		Statsd: statsd,
+	}, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	waitFor(t, "readings from the new device", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if len(consumers) < 2 {
+			return false
+		}
+
+		consumers[1].mutex.Lock()
+		defer consumers[1].mutex.Unlock()
+
+		return len(consumers[1].temperatures) == 2
+	})
+
+	cancel()
+	<-done
+
+	if len(consumers) != 2 || consumers[0].identifier != "temper-a" || consumers[1].identifier != "temper-b" {
+		t.Fatalf("got consumers %+v, want consumers for temper-a and temper-b", consumers)
+	}
+
+	tests := []struct {
+		consumer     *streamRecorder
+		temperatures []float64
+		ups          []bool
+	}{
+		// The first consumer is closed once the new identifier is read, after its stream failed.
+		{consumer: consumers[0], temperatures: []float64{1, 2}, ups: []bool{true, false}},
+		// The second consumer is closed when the stream stops.
+		{consumer: consumers[1], temperatures: []float64{3, 4}, ups: []bool{true}},
+	}
+
+	for _, test := range tests {
+		c := test.consumer
+		if !c.closed {
+			t.Errorf("consumer for %s was not closed", c.identifier)
+		}
+
+		if len(c.temperatures) != len(test.temperatures) || c.temperatures[0] != test.temperatures[0] || c.temperatures[1] != test.temperatures[1] {
+			t.Errorf("got temperatures %v for %s, want %v", c.temperatures, c.identifier, test.temperatures)
+		}
+
+		if len(c.ups) != len(test.ups) || c.ups[0] != test.ups[0] || c.ups[len(c.ups)-1] != test.ups[len(test.ups)-1] {
+			t.Errorf("got stream status notifications %v for %s, want %v", c.ups, c.identifier, test.ups)
+		}
+	}
+
+	// The circuit opens after the second consecutive failure, and closes once the new device
+	// delivers readings.
+	if got := statsd.gauge("collector.stream.circuit_open"); len(got) != 2 || got[0] != 1 || got[1] != 0 {
+		t.Errorf("got collector.stream.circuit_open values %v, want [1 0]", got)
+	}
+
+	if !fake.closed {
+		t.Error("client was not closed")
+	}
+}
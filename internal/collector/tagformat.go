@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TagFormat is a dialect in which statsd metric tags are encoded.
+type TagFormat string
+
+const (
+	// TagFormatInfluxDB appends tags to the metric name, as in name,tag=value:1|g. This is the format
+	// understood by the Telegraf statsd input.
+	TagFormatInfluxDB TagFormat = "influxdb"
+	// TagFormatDogStatsD appends tags after the metric type, as in name:1|g|#tag:value. This is the
+	// format understood by the Datadog agent.
+	TagFormatDogStatsD TagFormat = "dogstatsd"
+	// TagFormatGraphite appends tags to the metric name, as in name;tag=value:1|g. This is the format
+	// understood by Graphite 1.1 and later.
+	TagFormatGraphite TagFormat = "graphite"
+	// TagFormatNone appends tag values to the metric name as dotted path components, in order of tag
+	// name, as in name.value:1|g. This is the format understood by statsd servers without tag
+	// support.
+	TagFormatNone TagFormat = "none"
+)
+
+// Statsd metric types.
+const (
+	statsdCount  = "c"
+	statsdGauge  = "g"
+	statsdTiming = "ms"
+)
+
+// ParseTagFormat parses the name of a tag format.
+func ParseTagFormat(name string) (TagFormat, error) {
+	switch format := TagFormat(strings.ToLower(name)); format {
+	case TagFormatInfluxDB, TagFormatDogStatsD, TagFormatGraphite, TagFormatNone:
+		return format, nil
+	default:
+		return "", fmt.Errorf("statsd: unknown tag format %q; use influxdb, dogstatsd, graphite, or none", name)
+	}
+}
+
+// tagSanitizer replaces characters that are reserved by any of the tag formats.
+var tagSanitizer = strings.NewReplacer(
+	",", "_",
+	"=", "_",
+	":", "_",
+	"|", "_",
+	"#", "_",
+	";", "_",
+	"@", "_",
+	" ", "_",
+	"\t", "_",
+	"\n", "_",
+)
+
+// formatMetric formats a single statsd metric line in the tag format, e.g. name:1|g. Tags are
+// written in order of name so that the output is deterministic.
+func formatMetric(format TagFormat, name string, value string, metricType string, tags map[string]interface{}) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	name = tagSanitizer.Replace(name)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = tagSanitizer.Replace(fmt.Sprint(tags[key]))
+		keys[i] = tagSanitizer.Replace(key)
+	}
+
+	var b strings.Builder
+	b.WriteString(name)
+
+	switch format {
+	case TagFormatInfluxDB, TagFormatGraphite:
+		separator := ","
+		if format == TagFormatGraphite {
+			separator = ";"
+		}
+
+		for i, key := range keys {
+			b.WriteString(separator + key + "=" + values[i])
+		}
+	case TagFormatNone:
+		for _, value := range values {
+			// Dots within a value would otherwise introduce additional path components.
+			b.WriteString("." + strings.Replace(value, ".", "_", -1))
+		}
+	}
+
+	b.WriteString(":" + value + "|" + metricType)
+
+	if format == TagFormatDogStatsD && len(keys) > 0 {
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + ":" + values[i]
+		}
+
+		b.WriteString("|#" + strings.Join(pairs, ","))
+	}
+
+	return b.String()
+}
+
+// formatInt formats an integer metric value.
+func formatInt(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
+
+// formatFloat formats a floating point metric value without loss of precision.
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,132 @@
+package collector
+
+import "testing"
+
+func TestFormatMetric(t *testing.T) {
+	tags := map[string]interface{}{"server": "pi:9000", "device": "temper", "attempt": 2}
+
+	tests := []struct {
+		name       string
+		format     TagFormat
+		metric     string
+		value      string
+		metricType string
+		tags       map[string]interface{}
+		want       string
+	}{
+		{
+			name:       "influxdb orders tags by name",
+			format:     TagFormatInfluxDB,
+			metric:     "zephyrus.temperature",
+			value:      "21.5",
+			metricType: statsdGauge,
+			tags:       tags,
+			want:       "zephyrus.temperature,attempt=2,device=temper,server=pi_9000:21.5|g",
+		},
+		{
+			name:       "dogstatsd appends tags after the type",
+			format:     TagFormatDogStatsD,
+			metric:     "zephyrus.temperature",
+			value:      "21.5",
+			metricType: statsdGauge,
+			tags:       tags,
+			want:       "zephyrus.temperature:21.5|g|#attempt:2,device:temper,server:pi_9000",
+		},
+		{
+			name:       "graphite separates tags with semicolons",
+			format:     TagFormatGraphite,
+			metric:     "zephyrus.temperature",
+			value:      "21.5",
+			metricType: statsdGauge,
+			tags:       tags,
+			want:       "zephyrus.temperature;attempt=2;device=temper;server=pi_9000:21.5|g",
+		},
+		{
+			name:       "none appends values as path components",
+			format:     TagFormatNone,
+			metric:     "zephyrus.temperature",
+			value:      "21.5",
+			metricType: statsdGauge,
+			tags:       map[string]interface{}{"server": "10.0.0.1:9000", "device": "temper"},
+			want:       "zephyrus.temperature.temper.10_0_0_1_9000:21.5|g",
+		},
+		{
+			name:       "influxdb without tags",
+			format:     TagFormatInfluxDB,
+			metric:     "collector.stream.error",
+			value:      "1",
+			metricType: statsdCount,
+			want:       "collector.stream.error:1|c",
+		},
+		{
+			name:       "dogstatsd without tags",
+			format:     TagFormatDogStatsD,
+			metric:     "collector.stream.latency",
+			value:      "12",
+			metricType: statsdTiming,
+			want:       "collector.stream.latency:12|ms",
+		},
+		{
+			name:       "reserved characters in names and tags",
+			format:     TagFormatInfluxDB,
+			metric:     "name with|pipe",
+			value:      "1",
+			metricType: statsdCount,
+			tags:       map[string]interface{}{"k=e,y": "v:a|l#u;e@ \t\n"},
+			want:       "name_with_pipe,k_e_y=v_a_l_u_e____:1|c",
+		},
+		{
+			name:       "reserved characters in dogstatsd tags",
+			format:     TagFormatDogStatsD,
+			metric:     "temperature",
+			value:      "1",
+			metricType: statsdGauge,
+			tags:       map[string]interface{}{"device": "a,b:c|d#e"},
+			want:       "temperature:1|g|#device:a_b_c_d_e",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := formatMetric(test.format, test.metric, test.value, test.metricType, test.tags)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTagFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		want  TagFormat
+		valid bool
+	}{
+		{name: "influxdb", want: TagFormatInfluxDB, valid: true},
+		{name: "DogStatsD", want: TagFormatDogStatsD, valid: true},
+		{name: "graphite", want: TagFormatGraphite, valid: true},
+		{name: "none", want: TagFormatNone, valid: true},
+		{name: "prometheus"},
+	}
+
+	for _, test := range tests {
+		got, err := ParseTagFormat(test.name)
+		if (err == nil) != test.valid || got != test.want {
+			t.Errorf("ParseTagFormat(%q): got %q, %v; want %q, valid=%v", test.name, got, err, test.want, test.valid)
+		}
+	}
+}
+
+func TestFormatValues(t *testing.T) {
+	if got := formatInt(-42); got != "-42" {
+		t.Errorf("formatInt: got %q, want -42", got)
+	}
+
+	if got := formatFloat(21.0625); got != "21.0625" {
+		t.Errorf("formatFloat: got %q, want 21.0625", got)
+	}
+
+	if got := formatFloat(1e21); got != "1000000000000000000000" {
+		t.Errorf("formatFloat: got %q, want no exponent", got)
+	}
+}
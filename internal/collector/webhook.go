@@ -0,0 +1,176 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	"zephyrus/internal/alert"
+)
+
+const (
+	// Maximum number of delivery attempts for a single webhook notification.
+	webhookMaxAttempts = 5
+	// Delay before the first delivery retry; doubled on each subsequent retry.
+	webhookRetryDelay = 1 * time.Second
+	// Timeout for a single webhook HTTP request.
+	webhookRequestTimeout = 10 * time.Second
+)
+
+const (
+	// WebhookFormatGeneric posts a JSON object describing the alert event.
+	WebhookFormatGeneric = "generic"
+	// WebhookFormatSlack posts a Slack-compatible incoming webhook message.
+	WebhookFormatSlack = "slack"
+)
+
+// webhookTemplates are the built-in body templates for each webhook format.
+var webhookTemplates = map[string]string{
+	WebhookFormatGeneric: `{"device":{{json .Device}},"rule":{{json .Rule}},"kind":{{json .Kind}},` +
+		`"state":{{json .State}},"value":{{json .Value}},"timestamp":{{json .Timestamp}},` +
+		`"message":{{json .Message}}}`,
+	WebhookFormatSlack: `{"text":{{json (printf "[%s] %s: %s" .State .Device .Message)}}}`,
+}
+
+// WebhookReceiver describes a single webhook endpoint to which alert notifications are posted.
+type WebhookReceiver struct {
+	// URL to which notifications are posted.
+	URL string `json:"url"`
+	// Built-in body format, one of generic or slack. Ignored if Template is set.
+	Format string `json:"format"`
+	// Optional custom text/template for the request body. The template is executed against a
+	// WebhookPayload; the json function is available for escaping values.
+	Template string `json:"template"`
+
+	// Parsed body template.
+	template *template.Template
+}
+
+// WebhookPayload is the data against which webhook body templates are executed.
+type WebhookPayload struct {
+	Device    string
+	Rule      string
+	Kind      string
+	State     string
+	Value     float64
+	Timestamp int64
+	Message   string
+}
+
+// init validates the receiver and parses its body template.
+func (r *WebhookReceiver) init() error {
+	if r.URL == "" {
+		return fmt.Errorf("webhook: receiver URL must be specified")
+	}
+
+	body := r.Template
+	if body == "" {
+		if r.Format == "" {
+			r.Format = WebhookFormatGeneric
+		}
+
+		builtin, ok := webhookTemplates[r.Format]
+		if !ok {
+			return fmt.Errorf("webhook: unknown format %q for receiver %s", r.Format, r.URL)
+		}
+
+		body = builtin
+	}
+
+	tmpl, err := template.New(r.URL).Funcs(template.FuncMap{"json": marshalJSON}).Parse(body)
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+
+	r.template = tmpl
+
+	return nil
+}
+
+// render executes the receiver's body template against the payload.
+func (r *WebhookReceiver) render(payload *WebhookPayload) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("webhook: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// deliver posts the payload to the receiver, retrying with exponential backoff on network errors
+// and retryable HTTP status codes until the stop channel is closed.
+func (r *WebhookReceiver) deliver(client *http.Client, payload *WebhookPayload, stop <-chan bool) error {
+	body, err := r.render(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := r.post(client, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retryable || attempt == webhookMaxAttempts {
+			return fmt.Errorf("webhook: %s: %v", r.URL, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return fmt.Errorf("webhook: %s: %v; not retrying after shutdown", r.URL, err)
+		}
+
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt. Returns whether a failed attempt may be retried.
+func (r *WebhookReceiver) post(client *http.Client, body []byte) (bool, error) {
+	resp, err := client.Post(r.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+// newWebhookPayload creates a template payload from an alert event.
+func newWebhookPayload(identifier string, event *alert.Event) *WebhookPayload {
+	return &WebhookPayload{
+		Device:    identifier,
+		Rule:      event.Rule,
+		Kind:      string(event.Kind),
+		State:     string(event.State),
+		Value:     event.Value,
+		Timestamp: event.Time.Unix(),
+		Message:   event.Message,
+	}
+}
+
+// marshalJSON is a template function that serializes a value as JSON.
+func marshalJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
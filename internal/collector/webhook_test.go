@@ -0,0 +1,203 @@
+package collector
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"zephyrus/internal/alert"
+)
+
+// webhookRecorder is an HTTP handler recording the generic webhook notifications it receives. It
+// fails the first requests with the configured status.
+type webhookRecorder struct {
+	// Number of requests to fail, and the status with which they are failed.
+	failures int
+	status   int
+	// Number of requests received, including failed ones.
+	requests int
+	// Received notifications.
+	received []map[string]interface{}
+	// Mutex used to synchronize access to the recorded state.
+	mutex sync.Mutex
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests++
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(r.status)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+
+	var notification map[string]interface{}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.received = append(r.received, notification)
+}
+
+// states returns the rule and state of each received notification.
+func (r *webhookRecorder) states() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var states []string
+	for _, notification := range r.received {
+		states = append(states, notification["rule"].(string)+":"+notification["state"].(string))
+	}
+
+	return states
+}
+
+// newTestAlertConsumer creates an alert consumer notifying the specified servers of a single rule
+// firing above 30 degrees.
+func newTestAlertConsumer(t *testing.T, servers ...*httptest.Server) *AlertWebhookConsumer {
+	cfg := &AlertConfig{Rules: []*alert.Rule{{Name: "hot", Kind: alert.KindAbove, Threshold: 30}}}
+
+	for _, server := range servers {
+		receiver := &WebhookReceiver{URL: server.URL}
+		if err := receiver.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg.Webhooks = append(cfg.Webhooks, receiver)
+	}
+
+	return NewAlertWebhookConsumer("device", cfg)
+}
+
+func assertStates(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got notifications %v, want %v", got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got notifications %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAlertWebhookFireAndResolve(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestAlertConsumer(t, server)
+	c.Consume(31)
+	c.Consume(32)
+	c.Consume(29)
+	c.Close()
+
+	assertStates(t, recorder.states(), "hot:firing", "hot:resolved")
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if device := recorder.received[0]["device"]; device != "device" {
+		t.Errorf("got device %v, want device", device)
+	}
+
+	if value := recorder.received[0]["value"]; value != 31.0 {
+		t.Errorf("got value %v, want 31", value)
+	}
+}
+
+func TestAlertWebhookDeduplicates(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestAlertConsumer(t, server)
+
+	firing := &alert.Event{Rule: "hot", Kind: alert.KindAbove, State: alert.StateFiring, Time: time.Now()}
+	resolved := &alert.Event{Rule: "hot", Kind: alert.KindAbove, State: alert.StateResolved, Time: time.Now()}
+	other := &alert.Event{Rule: "cold", Kind: alert.KindBelow, State: alert.StateResolved, Time: time.Now()}
+
+	// The resolve of a rule never reported as firing, and repeated states, are not delivered.
+	c.enqueue([]*alert.Event{other, firing, firing, resolved, resolved})
+	c.Close()
+
+	assertStates(t, recorder.states(), "hot:firing", "hot:resolved")
+}
+
+func TestAlertWebhookRetriesServerErrors(t *testing.T) {
+	recorder := &webhookRecorder{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestAlertConsumer(t, server)
+	c.Consume(31)
+
+	deadline := time.Now().Add(5 * webhookRetryDelay)
+	for len(recorder.states()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Close()
+
+	assertStates(t, recorder.states(), "hot:firing")
+
+	if recorder.requests != 2 {
+		t.Errorf("got %d requests, want 2", recorder.requests)
+	}
+}
+
+func TestAlertWebhookDoesNotRetryClientErrors(t *testing.T) {
+	recorder := &webhookRecorder{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestAlertConsumer(t, server)
+	c.Consume(31)
+	c.Close()
+
+	assertStates(t, recorder.states())
+
+	if recorder.requests != 1 {
+		t.Errorf("got %d requests, want 1", recorder.requests)
+	}
+}
+
+func TestAlertWebhookIsolatesReceivers(t *testing.T) {
+	// A receiver failing every request would be retried with backoff for several seconds.
+	failing := &webhookRecorder{failures: webhookMaxAttempts, status: http.StatusServiceUnavailable}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	c := newTestAlertConsumer(t, failingServer, server)
+	c.Consume(31)
+
+	deadline := time.Now().Add(webhookRetryDelay / 2)
+	for len(recorder.states()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertStates(t, recorder.states(), "hot:firing")
+
+	// Closing abandons the failing receiver's retries rather than waiting for them.
+	start := time.Now()
+	c.Close()
+
+	if elapsed := time.Since(start); elapsed > webhookRetryDelay {
+		t.Errorf("close took %v, want less than %v", elapsed, webhookRetryDelay)
+	}
+}
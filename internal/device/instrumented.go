@@ -0,0 +1,118 @@
+package device
+
+import (
+	"sort"
+	"time"
+
+	"zephyrus/internal/metrics"
+	"zephyrus/schemas"
+)
+
+// readLatencyBuckets are the upper bounds, in seconds, of device read latency histogram buckets.
+var readLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// InstrumentedSensor implements the Sensor interface and wraps another Sensor, recording metrics
+// about each temperature read.
+type InstrumentedSensor struct {
+	sensor Sensor
+	// Device identifier used to label all recorded metrics.
+	identifier string
+
+	reads   *metrics.Counter
+	errors  *metrics.Counter
+	latency *metrics.Histogram
+}
+
+// NewInstrumentedSensor creates an instrumented sensor that records metrics to the registry.
+func NewInstrumentedSensor(sensor Sensor, registry *metrics.Registry) (*InstrumentedSensor, error) {
+	identifier, err := sensor.GetIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &InstrumentedSensor{
+		sensor:     sensor,
+		identifier: identifier,
+		reads: registry.NewCounter(
+			"zephyrus_device_reads_total",
+			"Total number of temperature reads made to the device.",
+			"device",
+		),
+		errors: registry.NewCounter(
+			"zephyrus_device_read_errors_total",
+			"Total number of failed temperature reads made to the device.",
+			"device",
+		),
+		latency: registry.NewHistogram(
+			"zephyrus_device_read_duration_seconds",
+			"Latency of temperature reads made to the device.",
+			readLatencyBuckets,
+			"device",
+		),
+	}
+
+	// Initialize counters so that they are exported before the first read or error.
+	s.reads.Add(0, identifier)
+	s.errors.Add(0, identifier)
+
+	status := registry.NewGauge(
+		"zephyrus_device_status",
+		"Current device status; 1 for the reported status and 0 otherwise.",
+		"device",
+		"status",
+	)
+	var values []int
+	for value := range schemas.Status_name {
+		values = append(values, int(value))
+	}
+	sort.Ints(values)
+
+	registry.OnCollect(func() {
+		current := s.GetStatus()
+		for _, value := range values {
+			active := 0.0
+			if schemas.Status(value) == current {
+				active = 1.0
+			}
+
+			status.Set(active, identifier, schemas.Status_name[int32(value)])
+		}
+	})
+
+	return s, nil
+}
+
+// Open is proxied directly to the sensor.
+func (s *InstrumentedSensor) Open() error {
+	return s.sensor.Open()
+}
+
+// Close is proxied directly to the sensor.
+func (s *InstrumentedSensor) Close() error {
+	return s.sensor.Close()
+}
+
+// GetIdentifier is proxied directly to the sensor.
+func (s *InstrumentedSensor) GetIdentifier() (string, error) {
+	return s.sensor.GetIdentifier()
+}
+
+// GetStatus is proxied directly to the sensor.
+func (s *InstrumentedSensor) GetStatus() schemas.Status {
+	return s.sensor.GetStatus()
+}
+
+// GetTemperature reads from the sensor, recording the read's latency and outcome.
+func (s *InstrumentedSensor) GetTemperature() (float64, error) {
+	start := time.Now()
+	temperature, err := s.sensor.GetTemperature()
+
+	s.reads.Inc(s.identifier)
+	s.latency.Observe(time.Since(start).Seconds(), s.identifier)
+
+	if err != nil {
+		s.errors.Inc(s.identifier)
+	}
+
+	return temperature, err
+}
@@ -1,9 +1,11 @@
 package device
 
 import (
+	"sync/atomic"
 	"time"
 
 	"zephyrus/internal/cache"
+	"zephyrus/internal/metrics"
 	"zephyrus/schemas"
 )
 
@@ -17,6 +19,11 @@ const (
 // ThrottledSensor implements the Sensor interface and wraps another Sensor, throttling request
 // volume to the actual device by protecting reads with an in-memory cache.
 type ThrottledSensor struct {
+	// Number of temperature reads served from and missing the cache, respectively. These are
+	// accessed atomically and must remain first in the struct for 64-bit alignment on 32-bit ARM.
+	hits   uint64
+	misses uint64
+
 	sensor Sensor
 	cache  cache.TTLCache
 }
@@ -54,9 +61,12 @@ func (s *ThrottledSensor) GetStatus() schemas.Status {
 func (s *ThrottledSensor) GetTemperature() (float64, error) {
 	cached := s.cache.Get(temperatureCacheKey)
 	if cached != nil {
+		atomic.AddUint64(&s.hits, 1)
 		return cached.(float64), nil
 	}
 
+	atomic.AddUint64(&s.misses, 1)
+
 	temperature, err := s.sensor.GetTemperature()
 	defer func() {
 		if err == nil {
@@ -66,3 +76,41 @@ func (s *ThrottledSensor) GetTemperature() (float64, error) {
 
 	return temperature, err
 }
+
+// CacheStats returns the number of temperature reads served from the cache and the number that
+// required a read from the underlying sensor.
+func (s *ThrottledSensor) CacheStats() (uint64, uint64) {
+	return atomic.LoadUint64(&s.hits), atomic.LoadUint64(&s.misses)
+}
+
+// RegisterMetrics registers cache effectiveness metrics with the registry.
+func (s *ThrottledSensor) RegisterMetrics(registry *metrics.Registry) {
+	registry.NewCounterFunc(
+		"zephyrus_sensor_cache_hits_total",
+		"Total number of temperature reads served from the cache.",
+		func() float64 {
+			hits, _ := s.CacheStats()
+			return float64(hits)
+		},
+	)
+	registry.NewCounterFunc(
+		"zephyrus_sensor_cache_misses_total",
+		"Total number of temperature reads that missed the cache.",
+		func() float64 {
+			_, misses := s.CacheStats()
+			return float64(misses)
+		},
+	)
+	registry.NewGaugeFunc(
+		"zephyrus_sensor_cache_hit_ratio",
+		"Fraction of temperature reads served from the cache.",
+		func() float64 {
+			hits, misses := s.CacheStats()
+			if hits+misses == 0 {
+				return 0
+			}
+
+			return float64(hits) / float64(hits+misses)
+		},
+	)
+}
@@ -0,0 +1,264 @@
+package flagconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileFlag is the name of the flag specifying the path to a configuration file.
+	FileFlag = "config"
+	// PrintFlag is the name of the flag requesting that the effective configuration be printed.
+	PrintFlag = "print-config"
+)
+
+// Parse parses command-line arguments into the flag set, then applies configuration from the file
+// named by the -config flag (if any) and from environment variables to all flags not set on the
+// command line. Flags take precedence over the file, which takes precedence over the environment.
+//
+// Configuration file keys are flag names (e.g. sample-rate), and the file format is determined by
+// its extension: .yaml or .yml, .toml, or .json. Environment variable names are flag names in
+// upper case with dashes replaced by underscores, following the prefix (e.g. with the prefix
+// ZEPHYRUS_COLLECTOR_, the variable ZEPHYRUS_COLLECTOR_SAMPLE_RATE sets -sample-rate).
+//
+// The flag set must define the -config flag.
+func Parse(fs *flag.FlagSet, args []string, envPrefix string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if path := fs.Lookup(FileFlag).Value.String(); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return err
+		}
+
+		// Keys are applied in sorted order so that errors are deterministic.
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			f := fs.Lookup(key)
+			if f == nil || key == FileFlag || key == PrintFlag {
+				return fmt.Errorf("flagconfig: %s: unknown option %q", path, key)
+			}
+
+			if set[key] {
+				continue
+			}
+
+			if err := setFileValue(f, values[key]); err != nil {
+				return fmt.Errorf("flagconfig: %s: %s: %v", path, key, err)
+			}
+
+			set[key] = true
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == FileFlag || f.Name == PrintFlag {
+			return
+		}
+
+		name := EnvName(envPrefix, f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		values := []string{value}
+		if isList(f) {
+			values = strings.Split(value, ",")
+		}
+
+		for _, v := range values {
+			if setErr := f.Value.Set(strings.TrimSpace(v)); setErr != nil {
+				err = fmt.Errorf("flagconfig: %s: invalid value %q: %v", name, v, setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// EnvName returns the name of the environment variable corresponding to a flag.
+func EnvName(prefix string, flagName string) string {
+	return prefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Print writes the effective value of every flag in the flag set as YAML, in a form that may be
+// used as a configuration file.
+func Print(out io.Writer, fs *flag.FlagSet) error {
+	values := yaml.MapSlice{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == FileFlag || f.Name == PrintFlag {
+			return
+		}
+
+		var value interface{} = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			value = getter.Get()
+		}
+
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case fmt.Stringer:
+			value = v.String()
+		}
+
+		values = append(values, yaml.MapItem{Key: f.Name, Value: value})
+	})
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("flagconfig: %v", err)
+	}
+
+	_, err = out.Write(data)
+
+	return err
+}
+
+// readFile reads a configuration file into a map of option names to values.
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("flagconfig: %v", err)
+	}
+
+	values := make(map[string]interface{})
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		var raw map[string]yamlValue
+		if err = yaml.UnmarshalStrict(data, &raw); err == nil {
+			for key, value := range raw {
+				values[key] = value.value
+			}
+		}
+	case ".toml":
+		_, err = toml.Decode(string(data), &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("flagconfig: %s: unsupported file extension %q; use .yaml, .yml, .toml, or .json", path, ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("flagconfig: %s: %v", path, err)
+	}
+
+	return values, nil
+}
+
+// yamlValue is a YAML configuration file value. Integers are kept in the form in which they are
+// written, since YAML decodes e.g. a Unix socket mode of 0660 as the octal number 432, which flags
+// parsing their own numbers would misinterpret.
+type yamlValue struct {
+	value interface{}
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (v *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&v.value); err != nil {
+		return err
+	}
+
+	switch value := v.value.(type) {
+	case int, int64, uint64:
+		var text string
+		if err := unmarshal(&text); err != nil {
+			return err
+		}
+
+		v.value = text
+	case []interface{}:
+		var elements []yamlValue
+		if err := unmarshal(&elements); err != nil {
+			return err
+		}
+
+		for i := range value {
+			value[i] = elements[i].value
+		}
+	}
+
+	return nil
+}
+
+// setFileValue sets a flag to a value decoded from a configuration file. Lists are permitted only
+// for flags accepting multiple values.
+func setFileValue(f *flag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		if !isList(f) {
+			return fmt.Errorf("expected a single value, not a list")
+		}
+
+		for _, element := range list {
+			if err := setFileValue(f, element); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case bool:
+		str = strconv.FormatBool(v)
+	case int:
+		str = strconv.Itoa(v)
+	case int64:
+		str = strconv.FormatInt(v, 10)
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("expected a string, number, or boolean, not %T", value)
+	}
+
+	if err := f.Value.Set(str); err != nil {
+		return fmt.Errorf("invalid value %q: %v", str, err)
+	}
+
+	return nil
+}
+
+// isList reports whether a flag accepts multiple values, i.e. whether its value is a slice.
+func isList(f *flag.Flag) bool {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+
+	value := getter.Get()
+
+	return value != nil && reflect.TypeOf(value).Kind() == reflect.Slice
+}
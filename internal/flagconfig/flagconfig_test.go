@@ -0,0 +1,248 @@
+package flagconfig
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPrefix is the environment variable prefix used by tests.
+const testPrefix = "FLAGCONFIG_TEST_"
+
+// testFlags are the flags defined by newTestFlagSet.
+type testFlags struct {
+	address    *string
+	sampleRate *float64
+	retries    *int
+	timeout    *time.Duration
+	verbose    *bool
+	mode       *string
+	servers    *StringList
+}
+
+func newTestFlagSet() (*flag.FlagSet, *testFlags) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.String(FileFlag, "", "Configuration file")
+	fs.Bool(PrintFlag, false, "Print configuration")
+
+	return fs, &testFlags{
+		address:    fs.String("address", "localhost", "Address"),
+		sampleRate: fs.Float64("sample-rate", 1, "Sample rate"),
+		retries:    fs.Int("retries", 3, "Retries"),
+		timeout:    fs.Duration("timeout", time.Second, "Timeout"),
+		verbose:    fs.Bool("verbose", false, "Verbose"),
+		mode:       fs.String("mode", "0600", "Octal file mode"),
+		servers:    NewStringList(fs, "server", "Servers"),
+	}
+}
+
+// writeConfig writes a configuration file with the specified name to a temporary directory,
+// returning its path.
+func writeConfig(t *testing.T, name string, data string) string {
+	dir, err := ioutil.TempDir("", "flagconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// setEnv sets environment variables for the duration of a test.
+func setEnv(t *testing.T, env map[string]string) func() {
+	for name, value := range env {
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "address: file\nsample-rate: 2\nretries: 5\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	defer setEnv(t, map[string]string{
+		testPrefix + "ADDRESS":     "env",
+		testPrefix + "SAMPLE_RATE": "4",
+		testPrefix + "TIMEOUT":     "1m",
+		testPrefix + "SERVER":      "a:1, b:2",
+	})()
+
+	fs, flags := newTestFlagSet()
+	if err := Parse(fs, []string{"-config", path, "-address", "flag"}, testPrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	if *flags.address != "flag" {
+		t.Errorf("got address %q, want the flag's value", *flags.address)
+	}
+
+	if *flags.sampleRate != 2 || *flags.retries != 5 {
+		t.Errorf("got sample rate %v and retries %v, want the file's values", *flags.sampleRate, *flags.retries)
+	}
+
+	if *flags.timeout != time.Minute {
+		t.Errorf("got timeout %v, want the environment's value", *flags.timeout)
+	}
+
+	if got := flags.servers.String(); got != "a:1,b:2" {
+		t.Errorf("got servers %q, want a:1,b:2", got)
+	}
+
+	if *flags.verbose {
+		t.Error("got verbose, want the default")
+	}
+}
+
+func TestParseFileFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "config.yaml", data: "address: file\nretries: 5\nverbose: true\ntimeout: 30s\nserver: [a:1, b:2]\n"},
+		{name: "config.toml", data: "address = \"file\"\nretries = 5\nverbose = true\ntimeout = \"30s\"\nserver = [\"a:1\", \"b:2\"]\n"},
+		{name: "config.json", data: `{"address": "file", "retries": 5, "verbose": true, "timeout": "30s", "server": ["a:1", "b:2"]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, test.name, test.data)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			fs, flags := newTestFlagSet()
+			if err := Parse(fs, []string{"-config", path}, testPrefix); err != nil {
+				t.Fatal(err)
+			}
+
+			if *flags.address != "file" || *flags.retries != 5 || !*flags.verbose || *flags.timeout != 30*time.Second {
+				t.Errorf("got address %q, retries %v, verbose %v, timeout %v",
+					*flags.address, *flags.retries, *flags.verbose, *flags.timeout)
+			}
+
+			if got := flags.servers.String(); got != "a:1,b:2" {
+				t.Errorf("got servers %q, want a:1,b:2", got)
+			}
+		})
+	}
+}
+
+func TestParseScalars(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		// Expected values of the mode, retries, and sample rate flags.
+		mode       string
+		retries    int
+		sampleRate float64
+	}{
+		{name: "unquoted octal", data: "mode: 0660\n", mode: "0660", retries: 3, sampleRate: 1},
+		{name: "quoted octal", data: "mode: \"0660\"\n", mode: "0660", retries: 3, sampleRate: 1},
+		{name: "decimal integer", data: "mode: 660\n", mode: "660", retries: 3, sampleRate: 1},
+		{name: "hexadecimal integer", data: "retries: 0x10\n", mode: "0600", retries: 16, sampleRate: 1},
+		{name: "integer for a float", data: "sample-rate: 2\n", mode: "0600", retries: 3, sampleRate: 2},
+		{name: "float", data: "sample-rate: 0.5\n", mode: "0600", retries: 3, sampleRate: 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, "config.yaml", test.data)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			fs, flags := newTestFlagSet()
+			if err := Parse(fs, []string{"-config", path}, testPrefix); err != nil {
+				t.Fatal(err)
+			}
+
+			if *flags.mode != test.mode || *flags.retries != test.retries || *flags.sampleRate != test.sampleRate {
+				t.Errorf("got mode %q, retries %v, sample rate %v; want %q, %v, %v",
+					*flags.mode, *flags.retries, *flags.sampleRate, test.mode, test.retries, test.sampleRate)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		env  map[string]string
+		// Substring of the expected error.
+		want string
+	}{
+		{name: "unknown key", file: "config.yaml", data: "adress: file\n", want: `unknown option "adress"`},
+		{name: "config key", file: "config.yaml", data: "config: other.yaml\n", want: `unknown option "config"`},
+		{name: "print-config key", file: "config.json", data: `{"print-config": true}`, want: `unknown option "print-config"`},
+		{name: "duplicate key", file: "config.yaml", data: "address: a\naddress: b\n", want: "already set"},
+		{name: "list for a single value", file: "config.yaml", data: "address: [a, b]\n", want: "expected a single value"},
+		{name: "map value", file: "config.yaml", data: "address: {host: a}\n", want: "expected a string, number, or boolean"},
+		{name: "invalid value", file: "config.toml", data: "retries = \"many\"\n", want: "retries: invalid value"},
+		{name: "unsupported extension", file: "config.ini", data: "address=file\n", want: "unsupported file extension"},
+		{
+			name: "invalid environment value",
+			file: "config.yaml",
+			data: "address: file\n",
+			env:  map[string]string{testPrefix + "RETRIES": "many"},
+			want: testPrefix + "RETRIES: invalid value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, test.file, test.data)
+			defer os.RemoveAll(filepath.Dir(path))
+			defer setEnv(t, test.env)()
+
+			fs, _ := newTestFlagSet()
+			err := Parse(fs, []string{"-config", path}, testPrefix)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	fs, _ := newTestFlagSet()
+	if err := Parse(fs, []string{"-retries", "7", "-timeout", "2m", "-server", "a:1,b:2"}, testPrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Print(&buf, fs); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, "config.yaml", buf.String())
+	defer os.RemoveAll(filepath.Dir(path))
+
+	printed, flags := newTestFlagSet()
+	if err := Parse(printed, []string{"-config", path}, testPrefix); err != nil {
+		t.Fatalf("parsing printed configuration:\n%s\n%v", buf.String(), err)
+	}
+
+	if *flags.retries != 7 || *flags.timeout != 2*time.Minute || *flags.mode != "0600" {
+		t.Errorf("got retries %v, timeout %v, mode %q from printed configuration:\n%s",
+			*flags.retries, *flags.timeout, *flags.mode, buf.String())
+	}
+
+	if got := flags.servers.String(); got != "a:1,b:2" {
+		t.Errorf("got servers %q, want a:1,b:2", got)
+	}
+}
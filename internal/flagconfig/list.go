@@ -0,0 +1,43 @@
+package flagconfig
+
+import (
+	"flag"
+	"strings"
+)
+
+// StringList is a flag.Getter accepting multiple string values, either by repeating the flag or as
+// a comma-separated list. In configuration files, it may also be set to a list.
+type StringList []string
+
+// NewStringList defines a StringList flag in the flag set, returning a pointer to its values.
+func NewStringList(fs *flag.FlagSet, name string, usage string) *StringList {
+	list := &StringList{}
+	fs.Var(list, name, usage)
+
+	return list
+}
+
+// Set appends the comma-separated values, ignoring empty values.
+func (l *StringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+
+	return nil
+}
+
+// String returns the values as a comma-separated list.
+func (l *StringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+// Get returns the values as a []string.
+func (l *StringList) Get() interface{} {
+	return []string(*l)
+}
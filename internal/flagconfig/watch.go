@@ -0,0 +1,48 @@
+package flagconfig
+
+import (
+	"os"
+	"time"
+)
+
+// Watch reports changes to the file at the specified path, checking its modification time at the
+// specified interval until done is closed. Changes are coalesced if the receiver falls behind.
+func Watch(path string, interval time.Duration, done <-chan bool) <-chan bool {
+	changes := make(chan bool, 1)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		modTime := fileModTime(path)
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+
+			if current := fileModTime(path); !current.Equal(modTime) {
+				modTime = current
+
+				select {
+				case changes <- true:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// fileModTime returns the modification time of a file, or the zero time if it cannot be read.
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
@@ -0,0 +1,78 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixScheme is the prefix of addresses describing Unix domain sockets.
+const unixScheme = "unix:"
+
+// Amount of time to wait for a connection when probing whether an existing socket is in use.
+const probeTimeout = 1 * time.Second
+
+// ParseAddress splits an address into its network and network-specific address. Addresses of the
+// form unix:///path/to/socket or unix:path/to/socket describe Unix domain sockets; all others are
+// TCP addresses of the form host:port, where the host may be empty to listen on all interfaces.
+func ParseAddress(addr string) (network string, address string) {
+	if strings.HasPrefix(addr, unixScheme) {
+		return "unix", strings.TrimPrefix(strings.TrimPrefix(addr, unixScheme), "//")
+	}
+
+	return "tcp", addr
+}
+
+// Listen listens on the specified TCP or Unix domain socket address (see ParseAddress). Unix sockets
+// are created with the specified permissions, replacing any stale socket left behind by a previous
+// process; the socket is removed when the listener is closed.
+func Listen(addr string, socketMode os.FileMode) (net.Listener, error) {
+	network, address := ParseAddress(addr)
+
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listener: %v", err)
+	}
+
+	if network == "unix" {
+		if err := os.Chmod(address, socketMode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("listener: %v", err)
+		}
+	}
+
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file at the path if no process is accepting connections on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("listener: %v", err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listener: %s exists and is not a socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, probeTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("listener: %s is in use by another process", path)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("listener: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor passed by systemd socket activation.
+const systemdFirstFD = 3
+
+// SystemdListeners returns the listening sockets passed to the process by systemd socket activation
+// (see sd_listen_fds(3)), keyed by their FileDescriptorName. Socket units without an explicit name
+// are keyed by the socket unit name. If the process was not socket-activated, the map is empty.
+//
+// The activation environment variables are unset so that they are not inherited by child
+// processes, so this should be called at most once.
+func SystemdListeners() (map[string][]net.Listener, error) {
+	listeners := make(map[string][]net.Listener)
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		// The sockets, if any, were intended for a different process.
+		return listeners, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return listeners, nil
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	for i := 0; i < count; i++ {
+		fd := systemdFirstFD + i
+
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+
+		// FileListener duplicates the descriptor, so the original is closed once it is wrapped.
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			for _, named := range listeners {
+				for _, l := range named {
+					l.Close()
+				}
+			}
+
+			return nil, fmt.Errorf("listener: socket-activated file descriptor %d (%s): %v", fd, name, err)
+		}
+
+		listeners[name] = append(listeners[name], listener)
+	}
+
+	return listeners, nil
+}
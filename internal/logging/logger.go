@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level describes the severity of a log entry.
+type Level int
+
+const (
+	// LevelDebug is for verbose diagnostic entries.
+	LevelDebug Level = iota
+	// LevelInfo is for routine entries.
+	LevelInfo
+	// LevelWarn is for entries describing recoverable failures.
+	LevelWarn
+	// LevelError is for entries describing unexpected failures.
+	LevelError
+)
+
+// levelNames maps each level to its serialized name.
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// String returns the name of the level.
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level from its name.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("logging: unknown level %q", name)
+}
+
+// Format describes the serialization of log entries.
+type Format string
+
+const (
+	// FormatText serializes entries as a timestamp and message followed by key=value pairs.
+	FormatText Format = "text"
+	// FormatJSON serializes entries as one JSON object per line.
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses a format from its name.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+
+	return FormatText, fmt.Errorf("logging: unknown format %q", name)
+}
+
+// Logger writes structured, leveled log entries. It is safe for concurrent use.
+type Logger struct {
+	out    io.Writer
+	format Format
+	// Minimum level of entries that are written.
+	level Level
+	// Mutex used to serialize writes.
+	mutex sync.Mutex
+}
+
+// NewLogger creates a logger writing entries at or above the specified level.
+func NewLogger(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{out: out, format: format, level: level}
+}
+
+// Enabled reports whether entries at the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes an entry with a message and alternating field keys and values.
+func (l *Logger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var buf bytes.Buffer
+	now := time.Now()
+
+	switch l.format {
+	case FormatJSON:
+		buf.WriteString("{")
+		writeJSONField(&buf, "time", now.Format(time.RFC3339Nano))
+		buf.WriteString(",")
+		writeJSONField(&buf, "level", level.String())
+		buf.WriteString(",")
+		writeJSONField(&buf, "msg", msg)
+
+		for i := 0; i+1 < len(keysAndValues); i += 2 {
+			buf.WriteString(",")
+			writeJSONField(&buf, fmt.Sprint(keysAndValues[i]), jsonValue(keysAndValues[i+1]))
+		}
+
+		buf.WriteString("}\n")
+	default:
+		fmt.Fprintf(&buf, "%s level=%s %s:", now.Format("2006/01/02 15:04:05"), level, msg)
+
+		for i := 0; i+1 < len(keysAndValues); i += 2 {
+			fmt.Fprintf(&buf, " %v=%s", keysAndValues[i], textValue(keysAndValues[i+1]))
+		}
+
+		buf.WriteString("\n")
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.out.Write(buf.Bytes())
+}
+
+// Debug writes an entry at LevelDebug.
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelDebug, msg, keysAndValues...)
+}
+
+// Info writes an entry at LevelInfo.
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelInfo, msg, keysAndValues...)
+}
+
+// Warn writes an entry at LevelWarn.
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelWarn, msg, keysAndValues...)
+}
+
+// Error writes an entry at LevelError.
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.Log(LevelError, msg, keysAndValues...)
+}
+
+// writeJSONField writes a single "key":value pair.
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	buf.Write(encodedKey)
+	buf.WriteString(":")
+	buf.Write(encodedValue)
+}
+
+// jsonValue converts field values without a natural JSON representation to strings.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return value
+}
+
+// textValue formats a field value, quoting it if it contains whitespace or quotes.
+func textValue(value interface{}) string {
+	str := fmt.Sprint(value)
+	if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+		return fmt.Sprintf("%q", str)
+	}
+
+	return str
+}
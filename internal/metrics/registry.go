@@ -0,0 +1,354 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric describes a single named metric family that can be written in text exposition format.
+type metric interface {
+	// write serializes all samples of the metric family.
+	write(w io.Writer)
+}
+
+// Registry is a collection of metrics that can be exposed in the Prometheus text exposition format.
+// It is safe for concurrent use.
+type Registry struct {
+	// Registered metric families, in registration order.
+	metrics []metric
+	// Functions invoked immediately before each collection, used to refresh point-in-time values.
+	hooks []func()
+	// Mutex used to synchronize access to the registered metrics and hooks.
+	mutex sync.Mutex
+}
+
+// NewRegistry creates a new, empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a new counter metric family with the specified label names.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{family: newFamily(name, help, "counter", labels)}
+	r.register(c)
+
+	return c
+}
+
+// NewGauge registers a new gauge metric family with the specified label names.
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{family: newFamily(name, help, "gauge", labels)}
+	r.register(g)
+
+	return g
+}
+
+// NewGaugeFunc registers a new unlabeled gauge whose value is computed on each collection.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	g := r.NewGauge(name, help)
+	r.OnCollect(func() {
+		g.Set(fn())
+	})
+}
+
+// NewCounterFunc registers a new unlabeled counter whose value is computed on each collection. The
+// function must return monotonically non-decreasing values.
+func (r *Registry) NewCounterFunc(name string, help string, fn func() float64) {
+	c := r.NewCounter(name, help)
+	r.OnCollect(func() {
+		c.set(fn())
+	})
+}
+
+// NewHistogram registers a new histogram metric family with the specified upper bucket bounds and
+// label names.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), buckets: sorted}
+	r.register(h)
+
+	return h
+}
+
+// OnCollect registers a function that is invoked immediately before every collection.
+func (r *Registry) OnCollect(hook func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// WriteText writes all registered metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+
+	return buf.Flush()
+}
+
+// ServeHTTP serves the registry's metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := r.WriteText(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// register adds a metric family to the registry.
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// family holds metadata and per-label-set state common to all metric types.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// Label value tuples, in first-observed order, keyed by their joined representation.
+	keys   []string
+	values map[string][]string
+	mutex  sync.Mutex
+}
+
+// newFamily creates metric family metadata.
+func newFamily(name string, help string, kind string, labels []string) *family {
+	return &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string][]string),
+	}
+}
+
+// key returns the map key for a set of label values, recording it if previously unseen. The
+// caller must hold the family mutex.
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := f.values[key]; !ok {
+		f.keys = append(f.keys, key)
+		f.values[key] = append([]string{}, labelValues...)
+	}
+
+	return key
+}
+
+// header writes the HELP and TYPE lines for the family.
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// labelString formats a label set, with optional additional trailing label, in exposition format.
+func (f *family) labelString(key string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, value := range f.values[key] {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeLabel(value)))
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, escapeLabel(extraValue)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing metric, partitioned by label values.
+type Counter struct {
+	*family
+	samples map[string]float64
+}
+
+// Inc increments the counter for the specified label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the specified label values by a non-negative amount.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.samples == nil {
+		c.samples = make(map[string]float64)
+	}
+
+	c.samples[c.key(labelValues)] += value
+}
+
+// set overwrites the counter's unlabeled value; used only by counters computed on collection.
+func (c *Counter) set(value float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.samples == nil {
+		c.samples = make(map[string]float64)
+	}
+
+	c.samples[c.key(nil)] = value
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.header(w)
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(key, "", ""), formatFloat(c.samples[key]))
+	}
+}
+
+// Gauge is a metric that can arbitrarily increase or decrease, partitioned by label values.
+type Gauge struct {
+	*family
+	samples map[string]float64
+}
+
+// Set sets the gauge for the specified label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.samples == nil {
+		g.samples = make(map[string]float64)
+	}
+
+	g.samples[g.key(labelValues)] = value
+}
+
+// Add adds a (possibly negative) amount to the gauge for the specified label values.
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.samples == nil {
+		g.samples = make(map[string]float64)
+	}
+
+	g.samples[g.key(labelValues)] += value
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.header(w)
+	for _, key := range g.keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(key, "", ""), formatFloat(g.samples[key]))
+	}
+}
+
+// Histogram samples observations into cumulative buckets, partitioned by label values.
+type Histogram struct {
+	*family
+	buckets []float64
+	samples map[string]*histogramSample
+}
+
+// histogramSample holds the bucket counts, sum, and count for a single label set.
+type histogramSample struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe records a single observation for the specified label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.samples == nil {
+		h.samples = make(map[string]*histogramSample)
+	}
+
+	key := h.key(labelValues)
+	sample, ok := h.samples[key]
+	if !ok {
+		sample = &histogramSample{counts: make([]uint64, len(h.buckets))}
+		h.samples[key] = sample
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			sample.counts[i]++
+		}
+	}
+
+	sample.sum += value
+	sample.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.header(w)
+	for _, key := range h.keys {
+		sample := h.samples[key]
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", formatFloat(bound)), sample.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, "le", "+Inf"), sample.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(key, "", ""), formatFloat(sample.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(key, "", ""), sample.count)
+	}
+}
+
+// formatFloat formats a sample value in exposition format.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeHelp escapes a HELP docstring.
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// escapeLabel escapes a label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
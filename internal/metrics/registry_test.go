@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Total requests.\nIncludes failures.", "method", "code")
+	requests.Inc("Get", "OK")
+	requests.Add(2, `path\with"quotes`, "line\nbreak")
+	requests.Inc("Get", "OK")
+
+	temperature := r.NewGauge("temperature_celsius", `Current temperature, in \celsius.`)
+	temperature.Set(21.5)
+
+	r.NewGaugeFunc("up", "Whether the device is up.", func() float64 { return 1 })
+	r.NewCounterFunc("reads_total", "Total reads.", func() float64 { return 42 })
+
+	latency := r.NewHistogram("latency_seconds", "Call latency.", []float64{1, 0.1, 0.5}, "method")
+	latency.Observe(0.05, "Get")
+	latency.Observe(0.3, "Get")
+	latency.Observe(0.1, "Get")
+	latency.Observe(2, "Get")
+	latency.Observe(0.7, "Stream")
+
+	special := r.NewGauge("special", "Special values.", "kind")
+	special.Set(math.Inf(1), "inf")
+	special.Set(math.NaN(), "nan")
+
+	want := `# HELP requests_total Total requests.\nIncludes failures.
+# TYPE requests_total counter
+requests_total{method="Get",code="OK"} 2
+requests_total{method="path\\with\"quotes",code="line\nbreak"} 2
+# HELP temperature_celsius Current temperature, in \\celsius.
+# TYPE temperature_celsius gauge
+temperature_celsius 21.5
+# HELP up Whether the device is up.
+# TYPE up gauge
+up 1
+# HELP reads_total Total reads.
+# TYPE reads_total counter
+reads_total 42
+# HELP latency_seconds Call latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="Get",le="0.1"} 2
+latency_seconds_bucket{method="Get",le="0.5"} 3
+latency_seconds_bucket{method="Get",le="1"} 3
+latency_seconds_bucket{method="Get",le="+Inf"} 4
+latency_seconds_sum{method="Get"} 2.45
+latency_seconds_count{method="Get"} 4
+latency_seconds_bucket{method="Stream",le="0.1"} 0
+latency_seconds_bucket{method="Stream",le="0.5"} 0
+latency_seconds_bucket{method="Stream",le="1"} 1
+latency_seconds_bucket{method="Stream",le="+Inf"} 1
+latency_seconds_sum{method="Stream"} 0.7
+latency_seconds_count{method="Stream"} 1
+# HELP special Special values.
+# TYPE special gauge
+special{kind="inf"} +Inf
+special{kind="nan"} NaN
+`
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Total requests.").Inc()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got content type %q", contentType)
+	}
+
+	if body := w.Body.String(); body != "# HELP requests_total Total requests.\n# TYPE requests_total counter\nrequests_total 1\n" {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	NewRegistry().NewCounter("requests_total", "Total requests.", "method").Inc()
+}
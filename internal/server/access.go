@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"zephyrus/internal/logging"
+	"zephyrus/internal/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requestLatencyBuckets are the upper bounds, in seconds, of RPC latency histogram buckets. Streams
+// may be long-lived, so the largest buckets span hours.
+var requestLatencyBuckets = []float64{0.001, 0.005, 0.025, 0.1, 0.5, 2.5, 10, 60, 600, 3600, 21600}
+
+// callInfoContextKey is the context key under which per-call information is stored.
+type callInfoContextKey struct{}
+
+// callInfo holds information about a call collected by inner interceptors for the access log.
+type callInfo struct {
+	// Name of the authenticated principal, if any.
+	principal string
+}
+
+// accessRecorder logs and records metrics for every gRPC call.
+type accessRecorder struct {
+	logger *logging.Logger
+
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+	messages *metrics.Counter
+}
+
+// newAccessRecorder creates a recorder writing access logs to the logger and, if the registry is
+// non-nil, recording per-method metrics to it.
+func newAccessRecorder(logger *logging.Logger, registry *metrics.Registry) *accessRecorder {
+	r := &accessRecorder{logger: logger}
+
+	if registry != nil {
+		r.requests = registry.NewCounter(
+			"zephyrus_grpc_requests_total",
+			"Total number of completed gRPC calls, by method and status code.",
+			"method",
+			"code",
+		)
+		r.latency = registry.NewHistogram(
+			"zephyrus_grpc_request_duration_seconds",
+			"Duration of completed gRPC calls, by method.",
+			requestLatencyBuckets,
+			"method",
+		)
+		r.messages = registry.NewCounter(
+			"zephyrus_grpc_stream_messages_sent_total",
+			"Total number of messages sent on server streams, by method.",
+			"method",
+		)
+	}
+
+	return r
+}
+
+// unaryInterceptor returns a gRPC interceptor recording unary calls.
+func (r *accessRecorder) unaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		call := &callInfo{}
+
+		resp, err := handler(context.WithValue(ctx, callInfoContextKey{}, call), req)
+		r.record(ctx, info.FullMethod, call, start, err, 0)
+
+		return resp, err
+	}
+}
+
+// streamInterceptor returns a gRPC interceptor recording streaming calls.
+func (r *accessRecorder) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		call := &callInfo{}
+		counted := &countingServerStream{
+			ServerStream: stream,
+			ctx:          context.WithValue(stream.Context(), callInfoContextKey{}, call),
+		}
+
+		err := handler(srv, counted)
+		sent := atomic.LoadInt64(&counted.sent)
+
+		if r.messages != nil {
+			r.messages.Add(float64(sent), info.FullMethod)
+		}
+
+		r.record(stream.Context(), info.FullMethod, call, start, err, sent)
+
+		return err
+	}
+}
+
+// record writes an access log entry and updates metrics for a completed call.
+func (r *accessRecorder) record(ctx context.Context, method string, call *callInfo, start time.Time, err error, sent int64) {
+	duration := time.Since(start)
+	code := status.Code(err)
+
+	if r.requests != nil {
+		r.requests.Inc(method, code.String())
+		r.latency.Observe(duration.Seconds(), method)
+	}
+
+	level := logging.LevelInfo
+	switch {
+	case strings.HasPrefix(method, "/grpc.health.v1.Health/"):
+		// Health checks are frequent and rarely interesting.
+		level = logging.LevelDebug
+	case code == codes.Unknown || code == codes.Internal || code == codes.DataLoss:
+		level = logging.LevelError
+	case code != codes.OK:
+		level = logging.LevelWarn
+	}
+
+	fields := []interface{}{
+		"peer", peerKey(ctx),
+		"method", method,
+		"code", code.String(),
+		"duration", duration,
+		"samples", sent,
+	}
+
+	if call.principal != "" {
+		fields = append(fields, "principal", call.principal)
+	}
+
+	if err != nil {
+		fields = append(fields, "error", status.Convert(err).Message())
+	}
+
+	r.logger.Log(level, "access", fields...)
+}
+
+// countingServerStream wraps a grpc.ServerStream, counting sent messages and overriding its context.
+type countingServerStream struct {
+	// Number of messages successfully sent. This is accessed atomically and must remain first in
+	// the struct for 64-bit alignment on 32-bit ARM.
+	sent int64
+
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the overridden context.
+func (s *countingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg sends a message, counting it on success.
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+	}
+
+	return err
+}
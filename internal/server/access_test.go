@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"zephyrus/internal/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// accessTestDelay is the amount of time each call handled in access log tests takes.
+const accessTestDelay = 20 * time.Millisecond
+
+// testServerStream is a grpc.ServerStream with a fixed context, discarding sent messages.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+// accessEntry is a JSON access log entry.
+type accessEntry struct {
+	Level    string  `json:"level"`
+	Msg      string  `json:"msg"`
+	Peer     string  `json:"peer"`
+	Method   string  `json:"method"`
+	Code     string  `json:"code"`
+	Duration float64 `json:"duration"`
+	Samples  int64   `json:"samples"`
+	Error    string  `json:"error"`
+}
+
+func TestAccessRecorderInterceptors(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 41234},
+	})
+
+	tests := []struct {
+		name string
+		// Function making the call through the recorder's interceptors.
+		call func(r *accessRecorder) error
+		want accessEntry
+	}{
+		{
+			name: "unary",
+			call: func(r *accessRecorder) error {
+				_, err := r.unaryInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: methodGetTemperature}, func(ctx context.Context, req interface{}) (interface{}, error) {
+					time.Sleep(accessTestDelay)
+					return nil, status.Error(codes.Unavailable, "device is not open")
+				})
+
+				return err
+			},
+			want: accessEntry{
+				Level:  "warn",
+				Msg:    "access",
+				Peer:   "192.0.2.1",
+				Method: methodGetTemperature,
+				Code:   "Unavailable",
+				Error:  "device is not open",
+			},
+		},
+		{
+			name: "stream",
+			call: func(r *accessRecorder) error {
+				stream := &testServerStream{ctx: ctx}
+				info := &grpc.StreamServerInfo{FullMethod: methodStreamTemperature, IsServerStream: true}
+
+				return r.streamInterceptor()(nil, stream, info, func(srv interface{}, stream grpc.ServerStream) error {
+					for i := 0; i < 3; i++ {
+						time.Sleep(accessTestDelay / 3)
+
+						if err := stream.SendMsg(nil); err != nil {
+							return err
+						}
+					}
+
+					return nil
+				})
+			},
+			want: accessEntry{
+				Level:   "info",
+				Msg:     "access",
+				Peer:    "192.0.2.1",
+				Method:  methodStreamTemperature,
+				Code:    "OK",
+				Samples: 3,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := newAccessRecorder(logging.NewLogger(&buf, logging.FormatJSON, logging.LevelDebug), nil)
+
+			// The interceptors return the handler's status unchanged.
+			if err := test.call(r); status.Code(err).String() != test.want.Code {
+				t.Errorf("got error %v, want code %s", err, test.want.Code)
+			}
+
+			var entry accessEntry
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("failed to parse access log entry %q: %v", buf.String(), err)
+			}
+
+			if entry.Duration < accessTestDelay.Seconds() || entry.Duration > time.Second.Seconds() {
+				t.Errorf("got duration %fs, want at least %v", entry.Duration, accessTestDelay)
+			}
+
+			entry.Duration = 0
+			if entry != test.want {
+				t.Errorf("got access log entry %+v, want %+v", entry, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// unauthenticatedServices describes services that may be called without a token, so that load
+// balancers and orchestrators can probe health without credentials.
+var unauthenticatedServices = []string{
+	"/grpc.health.v1.Health/",
+}
+
+// principalContextKey is the context key under which the authenticated principal is stored.
+type principalContextKey struct{}
+
+// Principal describes a named identity authenticated by a bearer token.
+type Principal struct {
+	// Name of the principal, used for logging.
+	Name string `json:"name"`
+	// Bearer token presented by the principal.
+	Token string `json:"token"`
+	// Full gRPC method names (e.g. /zephyrus.Weather/GetTemperature) the principal may call. Entries
+	// may contain shell-style wildcards within a path segment (e.g. /zephyrus.Weather/*); a single *
+	// permits all methods.
+	Methods []string `json:"methods"`
+}
+
+// allowed reports whether the principal may call the specified method.
+func (p *Principal) allowed(method string) bool {
+	for _, pattern := range p.Methods {
+		if pattern == "*" {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenFile describes the on-disk format of a token file.
+type tokenFile struct {
+	Principals []*Principal `json:"principals"`
+}
+
+// TokenAuthenticator authenticates and authorizes gRPC calls using static bearer tokens. It is safe
+// for concurrent use.
+type TokenAuthenticator struct {
+	// Path to the token file.
+	path string
+	// Currently loaded principals.
+	principals []*Principal
+	// Mutex used to synchronize access to principals.
+	mutex sync.RWMutex
+}
+
+// NewTokenAuthenticator creates an authenticator with principals loaded from a JSON file on disk.
+func NewTokenAuthenticator(path string) (*TokenAuthenticator, error) {
+	a := &TokenAuthenticator{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Reload rereads principals from the token file. On failure, the previously loaded principals
+// remain in effect.
+func (a *TokenAuthenticator) Reload() error {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+
+	var file tokenFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("auth: %v", err)
+	}
+
+	for _, principal := range file.Principals {
+		if principal.Name == "" || principal.Token == "" {
+			return fmt.Errorf("auth: principals must specify both a name and a token")
+		}
+	}
+
+	a.mutex.Lock()
+	a.principals = file.Principals
+	a.mutex.Unlock()
+
+	log.Printf("auth: loaded %d principals from %s", len(file.Principals), a.path)
+
+	return nil
+}
+
+// UnaryInterceptor returns a gRPC interceptor authorizing unary calls.
+func (a *TokenAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor returns a gRPC interceptor authorizing streaming calls.
+func (a *TokenAuthenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextServerStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authorize authenticates the bearer token in the call metadata and checks that its principal may
+// call the method. On success, the returned context carries the principal.
+func (a *TokenAuthenticator) authorize(ctx context.Context, method string) (context.Context, error) {
+	for _, prefix := range unauthenticatedServices {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	token, ok := bearerToken(ctx)
+	if !ok {
+		log.Printf("auth: rejected call without token: method=%s", method)
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	principal := a.lookup(token)
+	if principal == nil {
+		log.Printf("auth: rejected call with invalid token: method=%s", method)
+		return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+
+	if !principal.allowed(method) {
+		log.Printf("auth: denied call: principal=%s method=%s", principal.Name, method)
+		return nil, status.Errorf(codes.PermissionDenied, "principal %s may not call %s", principal.Name, method)
+	}
+
+	// Attribute the call to the principal in the access log.
+	if call, ok := ctx.Value(callInfoContextKey{}).(*callInfo); ok {
+		call.principal = principal.Name
+	}
+
+	return context.WithValue(ctx, principalContextKey{}, principal), nil
+}
+
+// lookup finds the principal with the specified token, or nil if there is none.
+func (a *TokenAuthenticator) lookup(token string) *Principal {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for _, principal := range a.principals {
+		if subtle.ConstantTimeCompare([]byte(principal.Token), []byte(token)) == 1 {
+			return principal
+		}
+	}
+
+	return nil
+}
+
+// PrincipalFromContext returns the name of the principal authenticated for a call, if any.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	if !ok {
+		return "", false
+	}
+
+	return principal.Name, true
+}
+
+// bearerToken extracts the bearer token from the authorization header in the call metadata.
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, value := range md.Get("authorization") {
+		if strings.HasPrefix(strings.ToLower(value), "bearer ") {
+			return strings.TrimSpace(value[len("bearer "):]), true
+		}
+	}
+
+	return "", false
+}
+
+// contextServerStream wraps a grpc.ServerStream, overriding its context.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the overridden context.
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"zephyrus/schemas"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// dashboardProtocol is the WebSocket subprotocol selected for dashboard clients that offer any.
+	dashboardProtocol = "zephyrus.v1"
+	// bearerProtocolPrefix prefixes the base64url-encoded bearer token offered as a WebSocket
+	// subprotocol, since browsers cannot set the Authorization header on WebSocket requests.
+	bearerProtocolPrefix = "base64url.bearer."
+)
+
+// dashboardReading is a WebSocket message describing a temperature reading and the device status
+// at the time of the reading.
+type dashboardReading struct {
+	temperatureResponse
+	Status string `json:"status"`
+}
+
+// registerDashboard adds the dashboard page and its WebSocket endpoint to the mux.
+func (g *gateway) registerDashboard(mux *http.ServeMux) {
+	mux.HandleFunc("/", g.handleDashboard)
+	mux.Handle("/v1/ws", websocket.Server{
+		Handshake: webSocketHandshake,
+		Handler:   g.handleWebSocket,
+	})
+}
+
+// handleDashboard serves the dashboard page. All assets are inline, so the page has no external
+// dependencies.
+func (g *gateway) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !allowGet(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, dashboardHTML)
+}
+
+// handleWebSocket streams temperature readings of the attached device to a WebSocket client as JSON
+// messages, accepting the same sample_rate and samples query parameters as the Server-Sent Events
+// stream. A stream that ends in failure ends with an error message.
+func (g *gateway) handleWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+
+	r := ws.Request()
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		websocket.JSON.Send(ws, newErrorResponse(err))
+		return
+	}
+
+	identifier, err := g.deviceInfo.sensor.GetIdentifier()
+	if err != nil {
+		websocket.JSON.Send(ws, newErrorResponse(err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(g.callContext(r))
+	defer cancel()
+
+	// Messages from the client are ignored, but reading them detects when the client goes away.
+	go func() {
+		defer cancel()
+
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	stream := &httpServerStream{
+		ctx: ctx,
+		send: func(resp *schemas.GetTemperatureResponse) error {
+			reading := &dashboardReading{
+				temperatureResponse: *newTemperatureResponse(identifier, resp),
+				Status:              g.deviceInfo.sensor.GetStatus().String(),
+			}
+
+			if err := websocket.JSON.Send(ws, reading); err != nil {
+				return status.Error(codes.Canceled, err.Error())
+			}
+
+			return nil
+		},
+	}
+
+	err = g.streamTemperature(stream, req, identifier)
+	if err != nil && ctx.Err() == nil && status.Code(err) != codes.Canceled {
+		websocket.JSON.Send(ws, newErrorResponse(err))
+	}
+}
+
+// webSocketHandshake rejects WebSocket handshakes from browsers on pages of other origins, so that
+// third-party pages cannot read readings using the browser's network access. Clients that send no
+// Origin header, which are not browsers, are permitted. Clients offering subprotocols, such as one
+// carrying a bearer token, must offer dashboardProtocol, which is selected.
+func webSocketHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+
+	if origin != nil && origin.Host != r.Host {
+		return fmt.Errorf("cross-origin WebSocket request from %s", origin)
+	}
+
+	if len(config.Protocol) == 0 {
+		return nil
+	}
+
+	for _, protocol := range config.Protocol {
+		if protocol == dashboardProtocol {
+			config.Protocol = []string{dashboardProtocol}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported WebSocket subprotocols %s", strings.Join(config.Protocol, ", "))
+}
+
+// webSocketToken returns the bearer token offered as a WebSocket subprotocol of the request, if any.
+func webSocketToken(r *http.Request) (string, bool) {
+	for _, value := range r.Header["Sec-Websocket-Protocol"] {
+		for _, protocol := range strings.Split(value, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if !strings.HasPrefix(protocol, bearerProtocolPrefix) {
+				continue
+			}
+
+			token, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(protocol, bearerProtocolPrefix))
+			if err != nil {
+				return "", false
+			}
+
+			return string(token), true
+		}
+	}
+
+	return "", false
+}
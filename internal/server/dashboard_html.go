@@ -0,0 +1,137 @@
+package server
+
+// dashboardHTML is the self-contained dashboard page. It connects to the WebSocket endpoint on the
+// same host, passing through the sample_rate query parameter of the page URL and offering the
+// access_token parameter of the URL fragment as a WebSocket subprotocol, and keeps a sparkline of
+// readings received since the page was loaded.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Zephyrus</title>
+<style>
+  body {
+    margin: 0;
+    padding: 2rem;
+    background: #111;
+    color: #eee;
+    font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+  }
+  main { max-width: 40rem; margin: 0 auto; }
+  header { display: flex; justify-content: space-between; align-items: baseline; color: #999; }
+  #temperature { font-size: 6rem; font-weight: 300; margin: 1rem 0 0; }
+  #fahrenheit { font-size: 1.5rem; color: #999; }
+  #sparkline { width: 100%; height: 6rem; margin: 2rem 0 0.5rem; }
+  #sparkline polyline { fill: none; stroke: #4aa3df; stroke-width: 2; vector-effect: non-scaling-stroke; }
+  #range { display: flex; justify-content: space-between; font-size: 0.875rem; color: #999; }
+  .status { padding: 0.125rem 0.5rem; border-radius: 0.25rem; background: #333; }
+  .status.ok { background: #1e5631; }
+  .status.error { background: #7a1f1f; }
+  #error { margin-top: 1rem; color: #e66; min-height: 1.5rem; }
+</style>
+</head>
+<body>
+<main>
+  <header>
+    <span id="device">Connecting&hellip;</span>
+    <span id="status" class="status">UNKNOWN</span>
+  </header>
+  <div id="temperature">&ndash;</div>
+  <div id="fahrenheit">&nbsp;</div>
+  <svg id="sparkline" viewBox="0 0 100 100" preserveAspectRatio="none">
+    <polyline id="history" points=""></polyline>
+  </svg>
+  <div id="range"><span id="minimum"></span><span id="updated"></span><span id="maximum"></span></div>
+  <div id="error"></div>
+</main>
+<script>
+(function () {
+  var maxReadings = 300;
+  var reconnectDelay = 5000;
+  var readings = [];
+
+  var params = new URLSearchParams(window.location.search);
+  var query = new URLSearchParams();
+  query.set("sample_rate", params.get("sample_rate") || "1");
+
+  // The token is taken from the URL fragment, which is never sent to the server, and removed from
+  // the address bar once read.
+  var protocols = ["zephyrus.v1"];
+  var token = new URLSearchParams(window.location.hash.slice(1)).get("access_token");
+  if (token) {
+    var encoded = btoa(unescape(encodeURIComponent(token)));
+    protocols.push("base64url.bearer." + encoded.replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, ""));
+    history.replaceState(null, "", window.location.pathname + window.location.search);
+  }
+
+  var scheme = window.location.protocol === "https:" ? "wss://" : "ws://";
+  var url = scheme + window.location.host + "/v1/ws?" + query.toString();
+
+  function element(id) {
+    return document.getElementById(id);
+  }
+
+  function setStatus(text, healthy) {
+    var status = element("status");
+    status.textContent = text;
+    status.className = "status " + (healthy ? "ok" : "error");
+  }
+
+  function renderSparkline() {
+    var values = readings.map(function (reading) { return reading.temperature; });
+    var minimum = Math.min.apply(null, values);
+    var maximum = Math.max.apply(null, values);
+    var span = maximum - minimum || 1;
+
+    var points = values.map(function (value, i) {
+      var x = values.length > 1 ? (i / (values.length - 1)) * 100 : 100;
+      var y = 95 - ((value - minimum) / span) * 90;
+      return x.toFixed(2) + "," + y.toFixed(2);
+    });
+
+    element("history").setAttribute("points", points.join(" "));
+    element("minimum").textContent = "min " + minimum.toFixed(1) + " °C";
+    element("maximum").textContent = "max " + maximum.toFixed(1) + " °C";
+  }
+
+  function render(reading) {
+    readings.push(reading);
+    if (readings.length > maxReadings) {
+      readings.shift();
+    }
+
+    element("device").textContent = reading.device;
+    element("temperature").textContent = reading.temperature.toFixed(1) + " °C";
+    element("fahrenheit").textContent = (reading.temperature * 9 / 5 + 32).toFixed(1) + " °F";
+    element("updated").textContent = new Date(reading.timestamp).toLocaleTimeString();
+    element("error").textContent = "";
+    setStatus(reading.status, reading.status === "OPENED");
+    renderSparkline();
+  }
+
+  function connect() {
+    var ws = new WebSocket(url, protocols);
+
+    ws.onmessage = function (event) {
+      var message = JSON.parse(event.data);
+      if (message.error) {
+        element("error").textContent = message.code + ": " + message.error;
+        return;
+      }
+
+      render(message);
+    };
+
+    ws.onclose = function () {
+      setStatus("DISCONNECTED", false);
+      setTimeout(connect, reconnectDelay);
+    };
+  }
+
+  connect();
+})();
+</script>
+</body>
+</html>
+`
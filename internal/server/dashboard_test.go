@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestDashboardWebSocketTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	tokens := filepath.Join(pki.dir, "tokens.json")
+	if err := ioutil.WriteFile(tokens, []byte(`{"principals": [{"name": "dashboard", "token": "secret", "methods": ["*"]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewTokenAuthenticator(tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewZephyrusServer(
+		&testSensor{},
+		WithTLS(pki.serverTLS(t)),
+		WithTokenAuthenticator(auth),
+		WithGateway(),
+		WithDashboard(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, shutdown := serveHTTP(t, s)
+	defer shutdown()
+
+	tests := []struct {
+		name   string
+		origin string
+		token  string
+		client *tls.Config
+		// Whether the handshake fails, and otherwise, the error code of the first message, if any.
+		wantHandshakeErr bool
+		wantCode         string
+	}{
+		{name: "valid token", origin: "https://" + addr, token: "secret", client: pki.clientTLS(true)},
+		{name: "no token", origin: "https://" + addr, client: pki.clientTLS(true), wantCode: "Unauthenticated"},
+		{name: "invalid token", origin: "https://" + addr, token: "guess", client: pki.clientTLS(true), wantCode: "Unauthenticated"},
+		{name: "cross-origin", origin: "https://example.com", token: "secret", client: pki.clientTLS(true), wantHandshakeErr: true},
+		{name: "no client certificate", origin: "https://" + addr, token: "secret", client: pki.clientTLS(false), wantHandshakeErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := websocket.NewConfig("wss://"+addr+"/v1/ws?samples=1", test.origin)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg.TlsConfig = test.client
+			cfg.Protocol = []string{dashboardProtocol}
+			if test.token != "" {
+				cfg.Protocol = append(cfg.Protocol, bearerProtocolPrefix+base64.RawURLEncoding.EncodeToString([]byte(test.token)))
+			}
+
+			ws, err := websocket.DialConfig(cfg)
+			if (err != nil) != test.wantHandshakeErr {
+				t.Fatalf("got handshake error %v, want error=%v", err, test.wantHandshakeErr)
+			}
+
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+
+			var msg json.RawMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			var reading dashboardReading
+			var errResp errorResponse
+			if err := json.Unmarshal(msg, &reading); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(msg, &errResp); err != nil {
+				t.Fatal(err)
+			}
+
+			if errResp.Code != test.wantCode {
+				t.Errorf("got message %s, want error code %q", msg, test.wantCode)
+			}
+
+			if test.wantCode == "" && reading.Temperature != 21.5 {
+				t.Errorf("got message %s, want a reading of 21.5", msg)
+			}
+		})
+	}
+}
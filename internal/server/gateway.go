@@ -0,0 +1,470 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"zephyrus/schemas"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Full gRPC method names of calls made on behalf of HTTP requests. These are subject to the same
+// authorization, limits, and access logging as the equivalent gRPC calls.
+const (
+	methodGetIdentifier     = "/zephyrus.DeviceInfo/GetIdentifier"
+	methodGetStatus         = "/zephyrus.DeviceInfo/GetStatus"
+	methodGetTemperature    = "/zephyrus.Weather/GetTemperature"
+	methodStreamTemperature = "/zephyrus.Weather/StreamTemperature"
+)
+
+// temperatureUnit is the unit of all temperatures served over HTTP.
+const temperatureUnit = "celsius"
+
+// httpStatusCodes maps gRPC status codes to the HTTP status codes of equivalent HTTP responses.
+// Codes not listed map to 500 Internal Server Error.
+var httpStatusCodes = map[codes.Code]int{
+	codes.OK:                http.StatusOK,
+	codes.InvalidArgument:   http.StatusBadRequest,
+	codes.NotFound:          http.StatusNotFound,
+	codes.PermissionDenied:  http.StatusForbidden,
+	codes.Unauthenticated:   http.StatusUnauthorized,
+	codes.ResourceExhausted: http.StatusTooManyRequests,
+	codes.Unimplemented:     http.StatusNotImplemented,
+	codes.Unavailable:       http.StatusServiceUnavailable,
+	codes.DeadlineExceeded:  http.StatusGatewayTimeout,
+}
+
+// deviceResponse describes a device in HTTP responses.
+type deviceResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// devicesResponse is the HTTP response listing all devices.
+type devicesResponse struct {
+	Devices   []*deviceResponse `json:"devices"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// temperatureResponse is the HTTP response, or stream event, describing a temperature reading.
+type temperatureResponse struct {
+	Device      string    `json:"device"`
+	Temperature float64   `json:"temperature"`
+	Unit        string    `json:"unit"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// statusResponse is the HTTP response describing the status of a device.
+type statusResponse struct {
+	Device    string    `json:"device"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// errorResponse is the HTTP response, or stream event, describing a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// gateway serves the device information and weather services as JSON over HTTP. Requests are
+// translated to calls on the gRPC service implementations, passing through the same interceptors.
+type gateway struct {
+	deviceInfo *DeviceInfoService
+	weather    *WeatherService
+	// Interceptors applied to unary and streaming calls.
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+	// Whether bearer tokens are accepted in the access_token query parameter.
+	queryToken bool
+}
+
+// registerAPI adds the gateway's JSON API handlers to the mux.
+func (g *gateway) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/devices", g.handleDevices)
+	mux.HandleFunc("/v1/devices/", g.handleDevice)
+}
+
+// handleDevices lists all devices attached to the server, at GET /v1/devices.
+func (g *gateway) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	ctx := g.callContext(r)
+
+	identifier, err := g.unary(ctx, &schemas.GetIdentifierRequest{}, &grpc.UnaryServerInfo{
+		Server:     g.deviceInfo,
+		FullMethod: methodGetIdentifier,
+	}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.deviceInfo.GetIdentifier(ctx, req.(*schemas.GetIdentifierRequest))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	deviceStatus, err := g.unary(ctx, &schemas.GetStatusRequest{}, &grpc.UnaryServerInfo{
+		Server:     g.deviceInfo,
+		FullMethod: methodGetStatus,
+	}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return g.deviceInfo.GetStatus(ctx, req.(*schemas.GetStatusRequest))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &devicesResponse{
+		Devices: []*deviceResponse{{
+			ID:     identifier.(*schemas.GetIdentifierResponse).Identifier,
+			Status: deviceStatus.(*schemas.GetStatusResponse).Status.String(),
+		}},
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// handleDevice routes requests for a single device, at GET /v1/devices/{id}/temperature,
+// /v1/devices/{id}/temperature/stream?sample_rate={rate}&samples={count}, and
+// /v1/devices/{id}/status.
+func (g *gateway) handleDevice(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/devices/"), "/"), "/")
+	id := segments[0]
+
+	var handler func(http.ResponseWriter, *http.Request, string)
+	switch strings.Join(segments[1:], "/") {
+	case "temperature":
+		handler = g.handleTemperature
+	case "temperature/stream":
+		handler = g.handleTemperatureStream
+	case "status":
+		handler = g.handleStatus
+	default:
+		writeError(w, status.Errorf(codes.NotFound, "no such endpoint: %s", r.URL.Path))
+		return
+	}
+
+	if !allowGet(w, r) {
+		return
+	}
+
+	handler(w, r, id)
+}
+
+// handleTemperature reads the current temperature of a device.
+func (g *gateway) handleTemperature(w http.ResponseWriter, r *http.Request, id string) {
+	resp, err := g.unary(g.callContext(r), &schemas.GetTemperatureRequest{}, &grpc.UnaryServerInfo{
+		Server:     g.weather,
+		FullMethod: methodGetTemperature,
+	}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if err := g.checkDevice(id); err != nil {
+			return nil, err
+		}
+
+		return g.weather.GetTemperature(ctx, req.(*schemas.GetTemperatureRequest))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTemperatureResponse(id, resp.(*schemas.GetTemperatureResponse)))
+}
+
+// handleStatus reads the current status of a device.
+func (g *gateway) handleStatus(w http.ResponseWriter, r *http.Request, id string) {
+	resp, err := g.unary(g.callContext(r), &schemas.GetStatusRequest{}, &grpc.UnaryServerInfo{
+		Server:     g.deviceInfo,
+		FullMethod: methodGetStatus,
+	}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if err := g.checkDevice(id); err != nil {
+			return nil, err
+		}
+
+		return g.deviceInfo.GetStatus(ctx, req.(*schemas.GetStatusRequest))
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &statusResponse{
+		Device:    id,
+		Status:    resp.(*schemas.GetStatusResponse).Status.String(),
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// handleTemperatureStream streams temperature readings of a device as Server-Sent Events. Each
+// reading is sent as a "temperature" event; a stream that ends in failure after it has started
+// ends with an "error" event.
+func (g *gateway) handleTemperatureStream(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Error(codes.Unimplemented, "streaming is not supported by this connection"))
+		return
+	}
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	started := false
+	stream := &httpServerStream{
+		ctx: g.callContext(r),
+		send: func(resp *schemas.GetTemperatureResponse) error {
+			if !started {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.WriteHeader(http.StatusOK)
+				started = true
+			}
+
+			if err := writeEvent(w, "temperature", newTemperatureResponse(id, resp)); err != nil {
+				return status.Error(codes.Canceled, err.Error())
+			}
+
+			flusher.Flush()
+
+			return nil
+		},
+	}
+
+	err = g.streamTemperature(stream, req, id)
+	if err == nil {
+		return
+	}
+
+	if !started {
+		writeError(w, err)
+		return
+	}
+
+	// Nothing can be written to a client that has gone away.
+	if r.Context().Err() == nil && status.Code(err) != codes.Canceled {
+		writeEvent(w, "error", newErrorResponse(err))
+		flusher.Flush()
+	}
+}
+
+// streamTemperature streams temperature readings of a device to an HTTP stream via the gRPC
+// service implementation.
+func (g *gateway) streamTemperature(stream grpc.ServerStream, req *schemas.GetTemperatureStreamRequest, id string) error {
+	return g.stream(g.weather, stream, &grpc.StreamServerInfo{
+		FullMethod:     methodStreamTemperature,
+		IsServerStream: true,
+	}, func(srv interface{}, stream grpc.ServerStream) error {
+		if err := g.checkDevice(id); err != nil {
+			return err
+		}
+
+		return g.weather.StreamTemperature(req, &temperatureServerStream{stream})
+	})
+}
+
+// checkDevice returns a NotFound error unless the identifier is that of the attached device.
+func (g *gateway) checkDevice(id string) error {
+	identifier, err := g.deviceInfo.sensor.GetIdentifier()
+	if err != nil {
+		return err
+	}
+
+	if id != identifier {
+		return status.Errorf(codes.NotFound, "no such device: %s", id)
+	}
+
+	return nil
+}
+
+// parseStreamRequest parses the sample_rate and samples query parameters of a stream request.
+func parseStreamRequest(r *http.Request) (*schemas.GetTemperatureStreamRequest, error) {
+	req := &schemas.GetTemperatureStreamRequest{}
+	query := r.URL.Query()
+
+	if value := query.Get("sample_rate"); value != "" {
+		sampleRate, err := strconv.ParseFloat(value, 64)
+		if err != nil || sampleRate < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid sample rate: %s", value)
+		}
+
+		req.SampleRate = sampleRate
+	}
+
+	if value := query.Get("samples"); value != "" {
+		samples, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid number of samples: %s", value)
+		}
+
+		req.Samples = int32(samples)
+	}
+
+	return req, nil
+}
+
+// newTemperatureResponse creates an HTTP response for a temperature reading taken now.
+func newTemperatureResponse(id string, resp *schemas.GetTemperatureResponse) *temperatureResponse {
+	return &temperatureResponse{
+		Device:      id,
+		Temperature: resp.Temperature,
+		Unit:        temperatureUnit,
+		Timestamp:   time.Now().UTC(),
+	}
+}
+
+// newErrorResponse creates an HTTP response describing an error.
+func newErrorResponse(err error) *errorResponse {
+	s := status.Convert(err)
+
+	return &errorResponse{Error: s.Message(), Code: s.Code().String()}
+}
+
+// callContext creates the context of a call made on behalf of an HTTP request, carrying the
+// client's address, TLS connection state, and bearer token in the form expected by the
+// interceptors. Since browsers cannot set headers on WebSocket requests, the token may instead be
+// passed as a WebSocket subprotocol, and if enabled, in the access_token query parameter.
+func (g *gateway) callContext(r *http.Request) context.Context {
+	p := &peer.Peer{Addr: httpPeerAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+
+	ctx := peer.NewContext(r.Context(), p)
+
+	md := metadata.MD{}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		md.Set("authorization", authorization)
+	} else if token, ok := webSocketToken(r); ok {
+		md.Set("authorization", "Bearer "+token)
+	} else if token := r.URL.Query().Get("access_token"); token != "" && g.queryToken {
+		md.Set("authorization", "Bearer "+token)
+	}
+
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// allowGet responds with 405 Method Not Allowed and returns false unless the request is a GET.
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	w.Header().Set("Allow", http.MethodGet)
+	writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{
+		Error: fmt.Sprintf("method %s is not allowed", r.Method),
+		Code:  codes.Unimplemented.String(),
+	})
+
+	return false
+}
+
+// writeJSON writes a JSON response with the specified status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response with the HTTP equivalent of the error's gRPC status code.
+func writeError(w http.ResponseWriter, err error) {
+	code, ok := httpStatusCodes[status.Code(err)]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+
+	writeJSON(w, code, newErrorResponse(err))
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+
+	return err
+}
+
+// httpPeerAddr is the net.Addr of an HTTP client, as reported by http.Request.RemoteAddr.
+type httpPeerAddr string
+
+// Network returns the name of the network.
+func (a httpPeerAddr) Network() string {
+	return "tcp"
+}
+
+// String returns the address.
+func (a httpPeerAddr) String() string {
+	return string(a)
+}
+
+// httpServerStream is a grpc.ServerStream sending temperature responses to an HTTP client. It
+// supports only sending messages; metadata is discarded.
+type httpServerStream struct {
+	ctx context.Context
+	// Function writing a single response to the client.
+	send func(*schemas.GetTemperatureResponse) error
+}
+
+// SetHeader discards header metadata.
+func (s *httpServerStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+// SendHeader discards header metadata.
+func (s *httpServerStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+// SetTrailer discards trailer metadata.
+func (s *httpServerStream) SetTrailer(metadata.MD) {}
+
+// Context returns the context of the HTTP request.
+func (s *httpServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg sends a temperature response to the client.
+func (s *httpServerStream) SendMsg(m interface{}) error {
+	resp, ok := m.(*schemas.GetTemperatureResponse)
+	if !ok {
+		return status.Errorf(codes.Internal, "unexpected message type %T", m)
+	}
+
+	return s.send(resp)
+}
+
+// RecvMsg is not supported, since temperature streams receive no messages after the request.
+func (s *httpServerStream) RecvMsg(m interface{}) error {
+	return status.Error(codes.Unimplemented, "receiving messages is not supported")
+}
+
+// temperatureServerStream adapts a grpc.ServerStream to the temperature streaming server interface,
+// as generated gRPC code does for streams passed through interceptors.
+type temperatureServerStream struct {
+	grpc.ServerStream
+}
+
+// Send sends a temperature response.
+func (s *temperatureServerStream) Send(resp *schemas.GetTemperatureResponse) error {
+	return s.ServerStream.SendMsg(resp)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"zephyrus/internal/device"
+	"zephyrus/schemas"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthCheckInterval describes the interval at which device health is reevaluated.
+const healthCheckInterval = 5 * time.Second
+
+// defaultHealthWindow describes the default maximum age of the most recent successful device read
+// for the device to be considered healthy.
+const defaultHealthWindow = 30 * time.Second
+
+// trackedSensor wraps a Sensor and records the time of the most recent successful temperature read,
+// so that device health reflects reads made by any service.
+type trackedSensor struct {
+	device.Sensor
+
+	// Time of the most recent successful temperature read.
+	lastGood time.Time
+	// Mutex used to synchronize access to lastGood.
+	mutex sync.Mutex
+}
+
+// GetTemperature reads from the wrapped sensor, recording the time of successful reads.
+func (s *trackedSensor) GetTemperature() (float64, error) {
+	temperature, err := s.Sensor.GetTemperature()
+	if err == nil {
+		s.mutex.Lock()
+		s.lastGood = time.Now()
+		s.mutex.Unlock()
+	}
+
+	return temperature, err
+}
+
+// lastSuccessfulRead returns the time of the most recent successful temperature read.
+func (s *trackedSensor) lastSuccessfulRead() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastGood
+}
+
+// healthy reports whether the device is open and has produced a good reading within the window.
+func (s *trackedSensor) healthy(window time.Duration) bool {
+	if s.GetStatus() != schemas.Status_OPENED {
+		return false
+	}
+
+	return time.Since(s.lastSuccessfulRead()) <= window
+}
+
+// healthMonitor periodically evaluates device health and publishes it via the standard gRPC health
+// checking protocol.
+type healthMonitor struct {
+	sensor *trackedSensor
+	health *health.Server
+	// Maximum age of the most recent successful read for the device to be considered healthy.
+	window time.Duration
+	// Names of services whose serving status depends on device health. All other registered
+	// services are always reported as serving.
+	dependent []string
+	// Channel closed to stop the monitor.
+	done chan bool
+	// Guards against starting more than once.
+	startOnce sync.Once
+	// Guards against stopping more than once.
+	stopOnce sync.Once
+}
+
+// newHealthMonitor creates a monitor, registering the gRPC health service on the server. All
+// services already registered on the server are initially reported as serving.
+func newHealthMonitor(grpcServer *grpc.Server, sensor *trackedSensor, window time.Duration, dependent []string) *healthMonitor {
+	h := health.NewServer()
+
+	for service := range grpcServer.GetServiceInfo() {
+		h.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	healthpb.RegisterHealthServer(grpcServer, h)
+
+	return &healthMonitor{
+		sensor:    sensor,
+		health:    h,
+		window:    window,
+		dependent: dependent,
+		done:      make(chan bool),
+	}
+}
+
+// start begins evaluating device health in the background. Subsequent calls have no effect.
+func (m *healthMonitor) start() {
+	m.startOnce.Do(func() {
+		go m.run()
+	})
+}
+
+// run evaluates device health until the monitor is stopped.
+func (m *healthMonitor) run() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	serving := true
+
+	for {
+		healthy := m.check()
+		if healthy != serving {
+			log.Printf("health: device health changed: healthy=%t", healthy)
+			serving = healthy
+		}
+
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if healthy {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+
+		// The empty service name describes the health of the server as a whole.
+		m.health.SetServingStatus("", status)
+		for _, service := range m.dependent {
+			m.health.SetServingStatus(service, status)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// stop stops evaluating device health and reports all services as not serving.
+func (m *healthMonitor) stop() {
+	m.stopOnce.Do(func() {
+		close(m.done)
+		m.health.Shutdown()
+	})
+}
+
+// check evaluates device health. If no service has read from the device recently, the device is
+// probed through the same sensor stack so that idle servers still report accurate health.
+func (m *healthMonitor) check() bool {
+	if time.Since(m.sensor.lastSuccessfulRead()) > healthCheckInterval {
+		m.sensor.GetTemperature()
+	}
+
+	return m.sensor.healthy(m.window)
+}
+
+// registeredServices returns the names of the services registered on the server by a function.
+func registeredServices(grpcServer *grpc.Server, register func()) []string {
+	before := grpcServer.GetServiceInfo()
+	register()
+
+	var services []string
+	for service := range grpcServer.GetServiceInfo() {
+		if _, ok := before[service]; !ok {
+			services = append(services, service)
+		}
+	}
+
+	return services
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Number of tracked peers above which idle rate limiter state is evicted.
+	limiterEvictionThreshold = 1024
+	// Amount of time after which a peer's rate limiter state is considered idle.
+	limiterIdleTimeout = 10 * time.Minute
+)
+
+// Limits describes server-side limits on client usage. Zero values disable the respective limit.
+type Limits struct {
+	// Maximum number of concurrent streams per peer.
+	MaxStreamsPerPeer int
+	// Maximum sample rate, in samples per second, that a client may request for a temperature
+	// stream. A requested sample rate of zero (unthrottled) is considered to exceed any maximum.
+	MaxSampleRate float64
+	// Whether streams requesting a sample rate above the maximum are rejected rather than clamped.
+	RejectExcessSampleRate bool
+	// Sustained rate, in calls per second per peer, of permitted unary temperature reads.
+	UnaryRate float64
+	// Number of unary temperature reads a peer may make in a burst above the sustained rate.
+	UnaryBurst int
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter enforces Limits per peer. It is safe for concurrent use.
+type limiter struct {
+	limits Limits
+	// Number of active streams, per peer.
+	streams map[string]int
+	// Unary call token buckets, per peer.
+	buckets map[string]*tokenBucket
+	// Mutex used to synchronize access to per-peer state.
+	mutex sync.Mutex
+}
+
+// newLimiter creates a limiter enforcing the specified limits.
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		streams: make(map[string]int),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// streamInterceptor returns a gRPC interceptor enforcing the per-peer concurrent stream limit.
+// Health check streams are exempt.
+func (l *limiter) streamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if l.limits.MaxStreamsPerPeer <= 0 {
+			return handler(srv, stream)
+		}
+
+		for _, prefix := range unauthenticatedServices {
+			if strings.HasPrefix(info.FullMethod, prefix) {
+				return handler(srv, stream)
+			}
+		}
+
+		key := peerKey(stream.Context())
+
+		l.mutex.Lock()
+		if l.streams[key] >= l.limits.MaxStreamsPerPeer {
+			l.mutex.Unlock()
+			return status.Errorf(
+				codes.ResourceExhausted,
+				"peer %s exceeds the limit of %d concurrent streams",
+				key,
+				l.limits.MaxStreamsPerPeer,
+			)
+		}
+		l.streams[key]++
+		l.mutex.Unlock()
+
+		defer func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+
+			if l.streams[key]--; l.streams[key] <= 0 {
+				delete(l.streams, key)
+			}
+		}()
+
+		return handler(srv, stream)
+	}
+}
+
+// allowUnary consumes a token from the calling peer's bucket, returning a ResourceExhausted error if
+// none is available.
+func (l *limiter) allowUnary(ctx context.Context) error {
+	if l.limits.UnaryRate <= 0 {
+		return nil
+	}
+
+	key := peerKey(ctx)
+	now := time.Now()
+	burst := math.Max(float64(l.limits.UnaryBurst), 1)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.buckets) > limiterEvictionThreshold {
+		for k, bucket := range l.buckets {
+			if now.Sub(bucket.last) > limiterIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.limits.UnaryRate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return status.Errorf(codes.ResourceExhausted, "peer %s exceeds the rate limit of %g calls per second", key, l.limits.UnaryRate)
+	}
+
+	bucket.tokens--
+
+	return nil
+}
+
+// sampleRate applies the maximum sample rate to a requested sample rate, returning either the
+// (possibly clamped) permitted rate or an InvalidArgument error.
+func (l *limiter) sampleRate(requested float64) (float64, error) {
+	maximum := l.limits.MaxSampleRate
+	if maximum <= 0 || (requested > 0 && requested <= maximum) {
+		return requested, nil
+	}
+
+	if l.limits.RejectExcessSampleRate {
+		return 0, status.Errorf(codes.InvalidArgument, "sample rate must be between 0 (exclusive) and %g", maximum)
+	}
+
+	return maximum, nil
+}
+
+// peerKey identifies the calling peer by host, so that all connections from one client share limits.
+func peerKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
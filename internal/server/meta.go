@@ -2,16 +2,29 @@ package server
 
 import (
 	"context"
+	"time"
 
 	"zephyrus/schemas"
 )
 
 // MetaService is a server-side implementation of meta RPC methods.
-type MetaService struct{}
+type MetaService struct {
+	// Sensor whose recent reads determine readiness.
+	sensor *trackedSensor
+	// Maximum age of the most recent successful read for the server to be considered ready. If
+	// zero, health checks report liveness only.
+	readinessWindow time.Duration
+}
 
-// HealthCheck always returns a successful health check response.
-// Note that this method only reports liveness of the server, without regard to any other components
-// of the system (including functionality of attached weather devices).
+// HealthCheck reports the health of the server.
+// By default, this method only reports liveness of the server, without regard to any other
+// components of the system (including functionality of attached weather devices). In readiness
+// mode, the check fails unless the device is open and has produced a good reading within the
+// readiness window.
 func (s *MetaService) HealthCheck(ctx context.Context, request *schemas.HealthCheckRequest) (*schemas.HealthCheckResponse, error) {
+	if s.readinessWindow > 0 && !s.sensor.healthy(s.readinessWindow) {
+		return &schemas.HealthCheckResponse{Ok: false}, nil
+	}
+
 	return &schemas.HealthCheckResponse{Ok: true}, nil
 }
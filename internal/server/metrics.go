@@ -0,0 +1,34 @@
+package server
+
+import (
+	"zephyrus/internal/device"
+	"zephyrus/internal/metrics"
+)
+
+// registerMetrics registers server-level metrics with the registry. The temperature gauge is
+// refreshed on each collection by reading through the same sensor that backs the gRPC services.
+func registerMetrics(registry *metrics.Registry, sensor device.Sensor, weather *WeatherService) {
+	temperature := registry.NewGauge(
+		"zephyrus_device_temperature_celsius",
+		"Most recent temperature reading from the device.",
+		"device",
+	)
+	registry.OnCollect(func() {
+		identifier, err := sensor.GetIdentifier()
+		if err != nil {
+			return
+		}
+
+		if value, err := sensor.GetTemperature(); err == nil {
+			temperature.Set(value, identifier)
+		}
+	})
+
+	registry.NewGaugeFunc(
+		"zephyrus_active_streams",
+		"Number of temperature streams currently being served.",
+		func() float64 {
+			return float64(weather.ActiveStreams())
+		},
+	)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/tls"
+	"time"
+
+	"zephyrus/internal/logging"
+	"zephyrus/internal/metrics"
+)
+
+// Option configures optional behavior of a ZephyrusServer.
+type Option func(*options)
+
+// options describes all optional server configuration.
+type options struct {
+	// Registry to which server metrics are registered and which is served over HTTP, if enabled.
+	metrics *metrics.Registry
+	// Maximum age of the most recent successful device read for the device to be reported healthy.
+	healthWindow time.Duration
+	// Maximum age of the most recent successful device read for Meta health checks to pass, if
+	// readiness mode is enabled.
+	readinessWindow time.Duration
+	// TLS configuration for the gRPC and HTTP listeners; connections are insecure if nil.
+	tls *tls.Config
+	// Authenticator authorizing all gRPC calls; calls are unauthenticated if nil.
+	auth *TokenAuthenticator
+	// Limits on client usage.
+	limits Limits
+	// Logger to which access logs are written.
+	accessLogger *logging.Logger
+	// Whether the HTTP/JSON gateway is served on the HTTP listener.
+	gateway bool
+	// Whether the web dashboard is served on the HTTP listener.
+	dashboard bool
+	// Whether HTTP requests may pass bearer tokens in the access_token query parameter.
+	queryToken bool
+}
+
+// WithMetrics registers server metrics to the specified registry and serves it at /metrics on the
+// HTTP listener.
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(o *options) {
+		o.metrics = registry
+	}
+}
+
+// WithHealthWindow sets the maximum age of the most recent successful device read for the device to
+// be reported as serving via the gRPC health checking protocol.
+func WithHealthWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.healthWindow = window
+	}
+}
+
+// WithReadiness enables readiness mode for Meta health checks, which then fail unless the device
+// has produced a good reading within the specified window.
+func WithReadiness(window time.Duration) Option {
+	return func(o *options) {
+		o.readinessWindow = window
+	}
+}
+
+// WithTLS secures all gRPC and HTTP connections with the specified TLS configuration.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+// WithTokenAuthenticator requires all gRPC calls to present a bearer token authorized by the
+// specified authenticator.
+func WithTokenAuthenticator(auth *TokenAuthenticator) Option {
+	return func(o *options) {
+		o.auth = auth
+	}
+}
+
+// WithLimits enforces limits on client usage.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithAccessLogger writes access logs for all gRPC calls to the specified logger.
+func WithAccessLogger(logger *logging.Logger) Option {
+	return func(o *options) {
+		o.accessLogger = logger
+	}
+}
+
+// WithGateway serves the device information and weather services as JSON, including a Server-Sent
+// Events temperature stream, under /v1/ on the HTTP listener. Requests are subject to the same
+// authentication, limits, and access logging as gRPC calls.
+func WithGateway() Option {
+	return func(o *options) {
+		o.gateway = true
+	}
+}
+
+// WithDashboard serves a self-contained web dashboard of the current temperature, recent history,
+// and device status at / on the HTTP listener, with readings pushed over a WebSocket at /v1/ws.
+func WithDashboard() Option {
+	return func(o *options) {
+		o.dashboard = true
+	}
+}
+
+// WithQueryToken accepts bearer tokens in the access_token query parameter of gateway and dashboard
+// requests, for clients such as browser EventSources that cannot set the Authorization header.
+// Tokens in URLs may be recorded by proxies and browser history.
+func WithQueryToken() Option {
+	return func(o *options) {
+		o.queryToken = true
+	}
+}
@@ -1,13 +1,20 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"sync"
 
 	"zephyrus/internal/device"
+	"zephyrus/internal/logging"
 	"zephyrus/schemas"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -15,37 +22,203 @@ import (
 type ZephyrusServer struct {
 	// Wrapped gRPC server instance.
 	server *grpc.Server
+	// Handlers for HTTP endpoints served alongside the gRPC server.
+	mux *http.ServeMux
+	// HTTP server for auxiliary endpoints.
+	http *http.Server
+	// TLS configuration of HTTP listeners; HTTP is served in plaintext if nil.
+	httpTLS *tls.Config
+	// Monitor publishing device health via the gRPC health checking protocol.
+	health *healthMonitor
+	// Channel closed when the server begins shutting down, ending all active streams.
+	shutdown chan bool
+	// Guards against closing the shutdown channel more than once.
+	shutdownOnce sync.Once
 }
 
 // NewZephyrusServer creates a new server with the specified device sensor backend.
 // Note that the server is, in itself, agnostic to the actual hardware device; it merely provides
 // abstractions on top of a client library that implements the device.Sensor interface.
-func NewZephyrusServer(sensor device.Sensor) (*ZephyrusServer, error) {
-	grpcServer := grpc.NewServer()
-	deviceInfoService := &DeviceInfoService{sensor}
-	weatherService := &WeatherService{sensor}
-	metaService := &MetaService{}
+func NewZephyrusServer(sensor device.Sensor, opts ...Option) (*ZephyrusServer, error) {
+	o := &options{
+		healthWindow: defaultHealthWindow,
+		accessLogger: logging.NewLogger(os.Stderr, logging.FormatText, logging.LevelInfo),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	tracked := &trackedSensor{Sensor: sensor}
+
+	var serverOpts []grpc.ServerOption
+	if o.tls != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(o.tls)))
+	}
+
+	limiter := newLimiter(o.limits)
+	access := newAccessRecorder(o.accessLogger, o.metrics)
+
+	// Access recording is outermost so that calls rejected by other interceptors are also recorded.
+	unaryInterceptors := []grpc.UnaryServerInterceptor{access.unaryInterceptor()}
+	streamInterceptors := []grpc.StreamServerInterceptor{access.streamInterceptor()}
+
+	if o.auth != nil {
+		unaryInterceptors = append(unaryInterceptors, o.auth.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, o.auth.StreamInterceptor())
+	}
+
+	streamInterceptors = append(streamInterceptors, limiter.streamInterceptor())
+
+	unaryInterceptor := chainUnaryInterceptors(unaryInterceptors)
+	streamInterceptor := chainStreamInterceptors(streamInterceptors)
+
+	serverOpts = append(
+		serverOpts,
+		grpc.UnaryInterceptor(unaryInterceptor),
+		grpc.StreamInterceptor(streamInterceptor),
+	)
+
+	shutdown := make(chan bool)
+
+	grpcServer := grpc.NewServer(serverOpts...)
+	deviceInfoService := &DeviceInfoService{tracked}
+	weatherService := &WeatherService{sensor: tracked, limiter: limiter, shutdown: shutdown}
+	metaService := &MetaService{sensor: tracked, readinessWindow: o.readinessWindow}
+
+	// Services that read from the device are reported as not serving when the device is unhealthy.
+	var dependent []string
 
 	schemas.RegisterDeviceInfoServer(grpcServer, deviceInfoService)
-	schemas.RegisterWeatherServer(grpcServer, weatherService)
+	dependent = append(dependent, registeredServices(grpcServer, func() {
+		schemas.RegisterWeatherServer(grpcServer, weatherService)
+	})...)
 	schemas.RegisterMetaServer(grpcServer, metaService)
+
 	reflection.Register(grpcServer)
+	healthMonitor := newHealthMonitor(grpcServer, tracked, o.healthWindow, dependent)
 
-	return &ZephyrusServer{server: grpcServer}, nil
-}
+	mux := http.NewServeMux()
 
-// Serve starts the gRPC server on the specified port and serves indefinitely.
-func (s *ZephyrusServer) Serve(port int) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return fmt.Errorf("server: %v", err)
+	if o.metrics != nil {
+		registerMetrics(o.metrics, tracked, weatherService)
+		mux.Handle("/metrics", o.metrics)
+	}
+
+	gw := &gateway{
+		deviceInfo: deviceInfoService,
+		weather:    weatherService,
+		unary:      unaryInterceptor,
+		stream:     streamInterceptor,
+		queryToken: o.queryToken,
+	}
+
+	if o.gateway {
+		gw.registerAPI(mux)
 	}
 
+	if o.dashboard {
+		gw.registerDashboard(mux)
+	}
+
+	s := &ZephyrusServer{
+		server:   grpcServer,
+		mux:      mux,
+		http:     &http.Server{Handler: mux},
+		health:   healthMonitor,
+		shutdown: shutdown,
+	}
+
+	if o.tls != nil {
+		s.httpTLS = httpTLSConfig(o.tls)
+	}
+
+	return s, nil
+}
+
+// Serve serves gRPC calls on the specified listener until the server is shut down. Serve may be
+// called concurrently with multiple listeners, e.g. to serve both TCP and Unix domain sockets.
+func (s *ZephyrusServer) Serve(listener net.Listener) error {
 	defer listener.Close()
 
+	s.health.start()
+
 	if err := s.server.Serve(listener); err != nil {
 		return fmt.Errorf("server: %v", err)
 	}
 
 	return nil
 }
+
+// ServeHTTPListener serves auxiliary HTTP endpoints (such as metrics) on the specified listener
+// until the server is shut down. It may be called concurrently with multiple listeners. If TLS is
+// enabled, HTTP is served over TLS with the same configuration as gRPC, including verification of
+// client certificates.
+func (s *ZephyrusServer) ServeHTTPListener(listener net.Listener) error {
+	if s.httpTLS != nil {
+		listener = tls.NewListener(listener, s.httpTLS)
+	}
+
+	if err := s.http.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server: %v", err)
+	}
+
+	return nil
+}
+
+// httpTLSConfig returns a configuration for serving HTTP with the specified gRPC configuration,
+// offering HTTP/1.1 alongside HTTP/2 for clients such as WebSockets that require it. Configurations
+// resolved per connection are adapted in the same way.
+func httpTLSConfig(cfg *tls.Config) *tls.Config {
+	protocols := []string{"h2", "http/1.1"}
+
+	httpCfg := cfg.Clone()
+	httpCfg.NextProtos = protocols
+
+	if getConfig := cfg.GetConfigForClient; getConfig != nil {
+		httpCfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			connCfg, err := getConfig(hello)
+			if err != nil || connCfg == nil {
+				return connCfg, err
+			}
+
+			connCfg = connCfg.Clone()
+			connCfg.NextProtos = protocols
+
+			return connCfg, nil
+		}
+	}
+
+	return httpCfg
+}
+
+// Shutdown gracefully stops the server. Health checks immediately report not serving, active
+// streams are ended with an Unavailable status so that clients reconnect elsewhere or later, and
+// in-flight calls are drained. If the context expires before draining completes, all remaining
+// connections are closed forcibly and an error is returned.
+func (s *ZephyrusServer) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		close(s.shutdown)
+	})
+	s.health.stop()
+
+	httpErr := s.http.Shutdown(ctx)
+
+	stopped := make(chan bool)
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+		return fmt.Errorf("server: forced shutdown: %v", ctx.Err())
+	}
+
+	if httpErr != nil {
+		return fmt.Errorf("server: %v", httpErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zephyrus/internal/tlsconfig"
+	"zephyrus/schemas"
+)
+
+// testSensor is a device.Sensor reporting a constant temperature.
+type testSensor struct{}
+
+func (s *testSensor) Open() error                      { return nil }
+func (s *testSensor) Close() error                     { return nil }
+func (s *testSensor) GetIdentifier() (string, error)   { return "temper", nil }
+func (s *testSensor) GetStatus() schemas.Status        { return schemas.Status_OPENED }
+func (s *testSensor) GetTemperature() (float64, error) { return 21.5, nil }
+
+// testPKI is a throwaway certificate authority with a server and client certificate it issued,
+// written to a temporary directory.
+type testPKI struct {
+	dir string
+	// Paths of the CA certificate, and of the server certificate and key.
+	caFile   string
+	certFile string
+	keyFile  string
+	// Pool containing the CA certificate, and the client certificate.
+	pool   *x509.CertPool
+	client tls.Certificate
+}
+
+// newTestPKI generates a certificate authority, a server certificate for 127.0.0.1, and a client
+// certificate. The directory is removed by close.
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	p := &testPKI{
+		dir:      dir,
+		caFile:   filepath.Join(dir, "ca.pem"),
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+		pool:     x509.NewCertPool(),
+	}
+	p.pool.AddCert(ca)
+
+	serverCert, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	files := map[string][]byte{
+		p.caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		p.certFile: serverCert,
+		p.keyFile:  serverKey,
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if p.client, err = tls.X509KeyPair(issue(3, x509.ExtKeyUsageClientAuth)); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func (p *testPKI) close() {
+	os.RemoveAll(p.dir)
+}
+
+// serverTLS returns the server TLS configuration, requiring client certificates.
+func (p *testPKI) serverTLS(t *testing.T) *tls.Config {
+	cfg, err := tlsconfig.NewServerConfig(&tlsconfig.ServerOptions{
+		CertFile:     p.certFile,
+		KeyFile:      p.keyFile,
+		ClientCAFile: p.caFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+// clientTLS returns a client TLS configuration trusting the CA, presenting the client certificate
+// if requested.
+func (p *testPKI) clientTLS(withCert bool) *tls.Config {
+	cfg := &tls.Config{RootCAs: p.pool}
+	if withCert {
+		cfg.Certificates = []tls.Certificate{p.client}
+	}
+
+	return cfg
+}
+
+// serveHTTP serves the HTTP endpoints of the server on a local listener, returning its address
+// and a function shutting the server down.
+func serveHTTP(t *testing.T, s *ZephyrusServer) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.ServeHTTPListener(listener)
+
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		s.Shutdown(ctx)
+	}
+
+	return listener.Addr().String(), shutdown
+}
+
+func TestServeHTTPListenerTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	s, err := NewZephyrusServer(&testSensor{}, WithTLS(pki.serverTLS(t)), WithGateway())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, shutdown := serveHTTP(t, s)
+	defer shutdown()
+
+	tests := []struct {
+		name   string
+		url    string
+		client *tls.Config
+		ok     bool
+	}{
+		{name: "plaintext", url: "http://" + addr + "/v1/devices"},
+		{name: "TLS without a client certificate", url: "https://" + addr + "/v1/devices", client: pki.clientTLS(false)},
+		{name: "mutual TLS", url: "https://" + addr + "/v1/devices", client: pki.clientTLS(true), ok: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: test.client}}
+
+			resp, err := client.Get(test.url)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if ok := err == nil && resp.StatusCode == http.StatusOK; ok != test.ok {
+				t.Errorf("got response %v, error %v; want ok=%v", resp, err, test.ok)
+			}
+		})
+	}
+}
+
+func TestHTTPTLSConfigProtocols(t *testing.T) {
+	pki := newTestPKI(t)
+	defer pki.close()
+
+	cfg := httpTLSConfig(pki.serverTLS(t))
+
+	connCfg, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WebSocket clients require HTTP/1.1.
+	if len(connCfg.NextProtos) != 2 || connCfg.NextProtos[0] != "h2" || connCfg.NextProtos[1] != "http/1.1" {
+		t.Errorf("got protocols %v, want [h2 http/1.1]", connCfg.NextProtos)
+	}
+
+	if connCfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("got client authentication %v, want client certificates to be required", connCfg.ClientAuth)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"zephyrus/internal/device"
@@ -25,11 +26,23 @@ var transientClientErrors = []codes.Code{
 
 // WeatherService is a server-side implementation of weather RPC calls.
 type WeatherService struct {
+	// Number of active temperature streams. This is accessed atomically and must remain first in
+	// the struct for 64-bit alignment on 32-bit ARM.
+	streams int64
+
 	sensor device.Sensor
+	// Limiter enforcing client usage limits.
+	limiter *limiter
+	// Channel closed when the server begins shutting down.
+	shutdown <-chan bool
 }
 
 // GetTemperature reads the current temperature.
 func (s *WeatherService) GetTemperature(ctx context.Context, request *schemas.GetTemperatureRequest) (*schemas.GetTemperatureResponse, error) {
+	if err := s.limiter.allowUnary(ctx); err != nil {
+		return nil, err
+	}
+
 	temperature, err := s.sensor.GetTemperature()
 	if err != nil {
 		return nil, err
@@ -44,6 +57,14 @@ func (s *WeatherService) GetTemperature(ctx context.Context, request *schemas.Ge
 func (s *WeatherService) StreamTemperature(request *schemas.GetTemperatureStreamRequest, stream schemas.Weather_StreamTemperatureServer) error {
 	var sample int32
 
+	sampleRate, err := s.limiter.sampleRate(request.SampleRate)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&s.streams, 1)
+	defer atomic.AddInt64(&s.streams, -1)
+
 	// Abstraction to gracefully retry a client stream transmission, up to the maximum number of
 	// allowable consecutive failures.
 	send := func(response *schemas.GetTemperatureResponse) error {
@@ -99,10 +120,24 @@ func (s *WeatherService) StreamTemperature(request *schemas.GetTemperatureStream
 
 		// Throttle device reads when a sample rate is provided; otherwise, stream readings
 		// to the client as fast as it can receive them.
-		if request.SampleRate > 0 {
-			time.Sleep(time.Duration(1.0e9 / request.SampleRate))
+		var delay time.Duration
+		if sampleRate > 0 {
+			delay = time.Duration(1.0e9 / sampleRate)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.shutdown:
+			return status.Error(codes.Unavailable, "server is shutting down")
 		}
 	}
 
 	return nil
 }
+
+// ActiveStreams returns the number of temperature streams currently being served.
+func (s *WeatherService) ActiveStreams() int64 {
+	return atomic.LoadInt64(&s.streams)
+}
@@ -0,0 +1,634 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"zephyrus/internal/client"
+	"zephyrus/internal/collector"
+	"zephyrus/internal/tlsconfig"
+)
+
+// Config describes the servers monitored by a supervisor and how their readings are collected.
+type Config struct {
+	Servers         []string
+	StatsdAddr      string
+	StatsdFormat    collector.TagFormat
+	SampleRate      float64
+	Alerts          string
+	TLS             bool
+	TLSCA           string
+	TLSCert         string
+	TLSKey          string
+	TLSServer       string
+	TokenFile       string
+	ShutdownTimeout time.Duration
+	Retry           collector.RetryPolicy
+	InfluxDB        collector.InfluxDBConfig
+	Graphite        collector.GraphiteConfig
+	MQTT            collector.MQTTConfig
+	File            collector.FileConfig
+	SinkQueueSize   int
+	SinkErrorPolicy collector.SinkErrorPolicy
+	QueueDir        string
+	QueueMaxSize    int64
+}
+
+// sameConnection returns whether two configurations connect to servers in the same way.
+func (c *Config) sameConnection(other *Config) bool {
+	return c.TLS == other.TLS &&
+		c.TLSCA == other.TLSCA &&
+		c.TLSCert == other.TLSCert &&
+		c.TLSKey == other.TLSKey &&
+		c.TLSServer == other.TLSServer &&
+		c.TokenFile == other.TokenFile
+}
+
+// stream is a supervised stream of temperatures from a server, implemented by collector.Stream.
+type stream interface {
+	Run(ctx context.Context)
+	SetSampleRate(sampleRate float64)
+	SetRetryPolicy(policy collector.RetryPolicy) error
+}
+
+// streamConstructor creates the stream from the server at the specified address.
+type streamConstructor func(addr string, cfg *collector.StreamConfig, opts ...client.Option) (stream, error)
+
+// newCollectorStream creates a collector.Stream from the server at the specified address.
+func newCollectorStream(addr string, cfg *collector.StreamConfig, opts ...client.Option) (stream, error) {
+	return collector.NewStream(addr, cfg, opts...)
+}
+
+// batchSink is a sink that can be fed from a disk queue.
+type batchSink interface {
+	client.TemperatureConsumer
+	collector.BatchConsumer
+}
+
+// queued returns a sink delivering readings to the named consumer through a disk queue, if the
+// queue is enabled, or directly otherwise.
+func queued(identifier string, name string, cfg *collector.DiskQueueConfig, consumer batchSink) (collector.Sink, error) {
+	if cfg.Dir == "" {
+		return collector.Sink{Name: name, Consumer: consumer}, nil
+	}
+
+	queue, err := collector.NewDiskQueue(identifier, name, cfg, consumer)
+	if err != nil {
+		return collector.Sink{}, err
+	}
+
+	return collector.Sink{Name: name, Consumer: queue}, nil
+}
+
+// closeSinks closes every sink that supports it, e.g. after failing to create the remaining sinks.
+func closeSinks(sinks []collector.Sink) {
+	for _, sink := range sinks {
+		if closer, ok := sink.Consumer.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("supervisor: failed to close sink: sink=%s error=%v", sink.Name, err)
+			}
+		}
+	}
+}
+
+// collection is a running stream from a single server.
+type collection struct {
+	stream stream
+	cancel context.CancelFunc
+	// Channel closed when the stream has stopped and its consumers are closed.
+	done chan bool
+	// Alert consumer of the stream, once created, if alerts are enabled.
+	alerts *collector.AlertWebhookConsumer
+}
+
+// Supervisor runs a collection per monitored server and applies configuration changes to them
+// without restarting what did not change. Apart from the consumers it creates, it is not safe for
+// concurrent use.
+type Supervisor struct {
+	// Configuration currently in effect.
+	cfg *Config
+	// Statsd client shared by the consumers of every collection, if statsd is enabled.
+	statsd *collector.StatsdClient
+	// Running collections, keyed by server address.
+	collections map[string]*collection
+	// Alert configuration, if alerts are enabled.
+	alertCfg *collector.AlertConfig
+	// Mutex used to synchronize access to the alert configuration and alert consumers, which are
+	// created by stream goroutines.
+	mutex sync.Mutex
+	// Function creating the stream of each collection.
+	newStream streamConstructor
+}
+
+// NewSupervisor creates a supervisor for the configuration. No collections are started.
+func NewSupervisor(cfg *Config) (*Supervisor, error) {
+	s := &Supervisor{
+		cfg:         cfg,
+		collections: make(map[string]*collection),
+		newStream:   newCollectorStream,
+	}
+
+	var err error
+
+	if cfg.StatsdAddr != "" {
+		log.Printf("supervisor: connecting to statsd server")
+		if s.statsd, err = collector.NewStatsdClient(cfg.StatsdAddr, cfg.StatsdFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Alerts != "" {
+		log.Printf("supervisor: loading alert configuration")
+		if s.alertCfg, err = collector.LoadAlertConfig(cfg.Alerts); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Config returns the configuration currently in effect.
+func (s *Supervisor) Config() *Config {
+	return s.cfg
+}
+
+// Start starts collecting from the server at the specified address.
+func (s *Supervisor) Start(addr string) error {
+	opts, err := clientOptions(s.cfg)
+	if err != nil {
+		return err
+	}
+
+	c := &collection{done: make(chan bool)}
+
+	streamCfg := &collector.StreamConfig{
+		SampleRate: s.cfg.SampleRate,
+		Retry:      s.cfg.Retry,
+		Factory:    s.factory(addr, c),
+	}
+	if s.statsd != nil {
+		streamCfg.Statsd = s.statsd
+	}
+
+	log.Printf("supervisor: connecting to Zephyrus gRPC server: server=%s", addr)
+	if c.stream, err = s.newStream(addr, streamCfg, opts...); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	s.collections[addr] = c
+
+	go func() {
+		defer close(c.done)
+		c.stream.Run(ctx)
+	}()
+
+	return nil
+}
+
+// factory returns a factory creating the consumer of the collection from the server at the
+// specified address, which dispatches readings to every enabled sink. Changes to the sink
+// configuration restart collections, so their current configuration is captured here.
+func (s *Supervisor) factory(addr string, c *collection) collector.ConsumerFactory {
+	statsd := s.statsd
+	fanOut := collector.FanOutConfig{
+		QueueSize:    s.cfg.SinkQueueSize,
+		ErrorPolicy:  s.cfg.SinkErrorPolicy,
+		DrainTimeout: s.cfg.ShutdownTimeout,
+		Server:       addr,
+	}
+	queue := collector.DiskQueueConfig{
+		Dir:     s.cfg.QueueDir,
+		MaxSize: s.cfg.QueueMaxSize,
+		Retry:   s.cfg.Retry,
+		Server:  addr,
+	}
+	if statsd != nil {
+		fanOut.Statsd = statsd
+		queue.Statsd = statsd
+	}
+	influxDB := s.cfg.InfluxDB
+	graphite := s.cfg.Graphite
+	broker := s.cfg.MQTT
+	broker.Server = addr
+	archive := s.cfg.File
+	archive.Server = addr
+
+	return func(identifier string) (client.TemperatureConsumer, error) {
+		var sinks []collector.Sink
+
+		if statsd != nil {
+			sinks = append(sinks, collector.Sink{
+				Name:     "statsd",
+				Consumer: collector.NewTemperatureStatsdConsumerWithClient(identifier, statsd),
+			})
+		}
+
+		if influxDB.URL != "" {
+			influx, err := collector.NewInfluxDBConsumer(identifier, &influxDB)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sink, err := queued(identifier, "influxdb", &queue, influx)
+			if err != nil {
+				influx.Close()
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sinks = append(sinks, sink)
+		}
+
+		if graphite.Addr != "" {
+			carbon, err := collector.NewGraphiteConsumer(identifier, &graphite)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sink, err := queued(identifier, "graphite", &queue, carbon)
+			if err != nil {
+				carbon.Close()
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sinks = append(sinks, sink)
+		}
+
+		if broker.Broker != "" {
+			publisher, err := collector.NewMQTTConsumer(identifier, &broker)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sinks = append(sinks, collector.Sink{Name: "mqtt", Consumer: publisher})
+		}
+
+		if archive.Path != "" {
+			file, err := collector.NewFileConsumer(identifier, &archive)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, err
+			}
+
+			sinks = append(sinks, collector.Sink{Name: "file", Consumer: file})
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.alertCfg != nil {
+			c.alerts = collector.NewAlertWebhookConsumer(identifier, s.alertCfg)
+			sinks = append(sinks, collector.Sink{Name: "alerts", Consumer: c.alerts})
+		}
+
+		consumer, err := collector.NewFanOutConsumer(identifier, &fanOut, sinks...)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+
+		return consumer, nil
+	}
+}
+
+// stop stops collecting from the servers at the specified addresses, returning whether every
+// collection stopped within the shutdown timeout. Collections are stopped in parallel, so the wait
+// for their consumers to flush is bounded by a single shutdown timeout.
+func (s *Supervisor) stop(addrs []string) bool {
+	stopping := make(map[string]*collection, len(addrs))
+	for _, addr := range addrs {
+		c, ok := s.collections[addr]
+		if !ok {
+			continue
+		}
+
+		delete(s.collections, addr)
+		c.cancel()
+		stopping[addr] = c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	stopped := true
+
+	for addr, c := range stopping {
+		select {
+		case <-c.done:
+			log.Printf("supervisor: stopped collection: server=%s", addr)
+		case <-ctx.Done():
+			log.Printf("supervisor: collection did not stop within %v: server=%s", s.cfg.ShutdownTimeout, addr)
+			stopped = false
+		}
+	}
+
+	return stopped
+}
+
+// addrs returns the addresses of the servers with running collections.
+func (s *Supervisor) addrs() []string {
+	addrs := make([]string, 0, len(s.collections))
+	for addr := range s.collections {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// restart restarts every collection, e.g. to apply new connection options.
+func (s *Supervisor) restart() {
+	addrs := s.addrs()
+
+	s.stop(addrs)
+
+	for _, addr := range addrs {
+		if err := s.Start(addr); err != nil {
+			log.Printf("supervisor: failed to restart collection: server=%s error=%v", addr, err)
+		}
+	}
+}
+
+// Apply applies a new configuration, logging each change. Changes that cannot be applied are logged
+// and leave the corresponding previous configuration in effect.
+func (s *Supervisor) Apply(cfg *Config) {
+	prev := s.cfg
+	changed := false
+
+	restart := false
+
+	// Statsd client that is no longer used once collections are restarted, if statsd is disabled.
+	var unusedStatsd *collector.StatsdClient
+
+	if cfg.StatsdAddr != prev.StatsdAddr || cfg.StatsdFormat != prev.StatsdFormat {
+		changed = true
+		log.Printf(
+			"supervisor: statsd target changed: from=%s (%s) to=%s (%s)",
+			prev.StatsdAddr,
+			prev.StatsdFormat,
+			cfg.StatsdAddr,
+			cfg.StatsdFormat,
+		)
+
+		switch {
+		case s.statsd != nil && cfg.StatsdAddr != "":
+			if err := s.statsd.SetTarget(cfg.StatsdAddr, cfg.StatsdFormat); err != nil {
+				log.Printf("supervisor: failed to change statsd target: error=%v", err)
+				cfg.StatsdAddr, cfg.StatsdFormat = prev.StatsdAddr, prev.StatsdFormat
+			}
+		case s.statsd != nil:
+			restart = true
+			unusedStatsd, s.statsd = s.statsd, nil
+		default:
+			statsd, err := collector.NewStatsdClient(cfg.StatsdAddr, cfg.StatsdFormat)
+			if err != nil {
+				log.Printf("supervisor: failed to connect to statsd server: error=%v", err)
+				cfg.StatsdAddr, cfg.StatsdFormat = prev.StatsdAddr, prev.StatsdFormat
+				break
+			}
+
+			restart = true
+			s.statsd = statsd
+		}
+	}
+
+	if cfg.InfluxDB != prev.InfluxDB {
+		changed = true
+		restart = true
+		log.Printf("supervisor: InfluxDB configuration changed: url=%s", cfg.InfluxDB.URL)
+	}
+
+	if cfg.Graphite != prev.Graphite {
+		changed = true
+		restart = true
+		log.Printf("supervisor: Graphite configuration changed: addr=%s path=%s", cfg.Graphite.Addr, cfg.Graphite.PathTemplate)
+	}
+
+	if cfg.MQTT != prev.MQTT {
+		changed = true
+		restart = true
+		log.Printf("supervisor: MQTT configuration changed: broker=%s topic=%s", cfg.MQTT.Broker, cfg.MQTT.Topic)
+	}
+
+	if cfg.File != prev.File {
+		changed = true
+		restart = true
+		log.Printf("supervisor: file configuration changed: path=%s format=%s", cfg.File.Path, cfg.File.Format)
+	}
+
+	if cfg.SinkQueueSize != prev.SinkQueueSize || cfg.SinkErrorPolicy != prev.SinkErrorPolicy {
+		changed = true
+		restart = true
+		log.Printf("supervisor: sink configuration changed: queue size=%d error policy=%s", cfg.SinkQueueSize, cfg.SinkErrorPolicy)
+	}
+
+	if cfg.QueueDir != prev.QueueDir || cfg.QueueMaxSize != prev.QueueMaxSize {
+		changed = true
+		restart = true
+		log.Printf("supervisor: disk queue configuration changed: dir=%s max size=%d", cfg.QueueDir, cfg.QueueMaxSize)
+	}
+
+	if cfg.ShutdownTimeout != prev.ShutdownTimeout {
+		changed = true
+		log.Printf("supervisor: shutdown timeout changed: from=%v to=%v", prev.ShutdownTimeout, cfg.ShutdownTimeout)
+	}
+
+	if cfg.SampleRate != prev.SampleRate {
+		changed = true
+		log.Printf("supervisor: sample rate changed: from=%f to=%f", prev.SampleRate, cfg.SampleRate)
+
+		for _, c := range s.collections {
+			c.stream.SetSampleRate(cfg.SampleRate)
+		}
+	}
+
+	if cfg.Retry != prev.Retry {
+		changed = true
+		log.Printf(
+			"supervisor: retry policy changed: initial interval=%v max interval=%v multiplier=%f jitter=%f failure threshold=%d",
+			cfg.Retry.InitialInterval,
+			cfg.Retry.MaxInterval,
+			cfg.Retry.Multiplier,
+			cfg.Retry.Jitter,
+			cfg.Retry.FailureThreshold,
+		)
+
+		for addr, c := range s.collections {
+			if err := c.stream.SetRetryPolicy(cfg.Retry); err != nil {
+				log.Printf("supervisor: failed to change retry policy: server=%s error=%v", addr, err)
+			}
+		}
+	}
+
+	if !cfg.sameConnection(prev) {
+		changed = true
+		restart = true
+		log.Printf("supervisor: connection options changed: tls=%v ca=%s cert=%s token file=%s", cfg.TLS, cfg.TLSCA, cfg.TLSCert, cfg.TokenFile)
+	}
+
+	if cfg.Alerts != prev.Alerts {
+		changed = true
+		log.Printf("supervisor: alert configuration path changed: from=%s to=%s", prev.Alerts, cfg.Alerts)
+	}
+
+	// Alert rules are reread on every reload, since the file may have changed even if its path
+	// has not.
+	if s.reloadAlerts(cfg) {
+		restart = true
+	}
+
+	s.cfg = cfg
+
+	if restart {
+		s.restart()
+	}
+
+	if unusedStatsd != nil {
+		if err := unusedStatsd.Close(); err != nil {
+			log.Printf("supervisor: failed to close statsd client: error=%v", err)
+		}
+	}
+
+	if s.applyServers(cfg.Servers) {
+		changed = true
+	}
+
+	if !changed {
+		log.Printf("supervisor: no options changed")
+	}
+}
+
+// applyServers starts collecting from servers that are not yet monitored and stops collecting from
+// servers that are no longer listed, returning whether any were started or stopped. Servers that
+// fail to start are retried on the next reload.
+func (s *Supervisor) applyServers(servers []string) bool {
+	listed := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		listed[addr] = true
+	}
+
+	var removed []string
+	for addr := range s.collections {
+		if !listed[addr] {
+			log.Printf("supervisor: server removed: server=%s", addr)
+			removed = append(removed, addr)
+		}
+	}
+
+	s.stop(removed)
+
+	changed := len(removed) > 0
+
+	for _, addr := range servers {
+		if _, ok := s.collections[addr]; ok {
+			continue
+		}
+
+		changed = true
+		log.Printf("supervisor: server added: server=%s", addr)
+
+		if err := s.Start(addr); err != nil {
+			log.Printf("supervisor: failed to start collection: server=%s error=%v", addr, err)
+		}
+	}
+
+	return changed
+}
+
+// reloadAlerts rereads the alert configuration and applies it to every alert consumer, returning
+// whether alerts were enabled or disabled, which requires collections to be restarted. If the
+// alert configuration cannot be read, the previous alert configuration remains in effect.
+func (s *Supervisor) reloadAlerts(cfg *Config) bool {
+	var alertCfg *collector.AlertConfig
+
+	if cfg.Alerts != "" {
+		log.Printf("supervisor: reloading alert configuration")
+
+		var err error
+		if alertCfg, err = collector.LoadAlertConfig(cfg.Alerts); err != nil {
+			log.Printf("supervisor: failed to reload alert configuration: error=%v", err)
+			cfg.Alerts = s.cfg.Alerts
+			return false
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	toggled := (alertCfg == nil) != (s.alertCfg == nil)
+	s.alertCfg = alertCfg
+
+	if toggled {
+		log.Printf("supervisor: alerts toggled; restarting collections: enabled=%v", alertCfg != nil)
+		return true
+	}
+
+	if alertCfg != nil {
+		for _, c := range s.collections {
+			if c.alerts != nil {
+				c.alerts.Reload(alertCfg)
+			}
+		}
+	}
+
+	return false
+}
+
+// Shutdown stops every collection, flushing pending readings and notifications. It returns an
+// error if any collection did not stop within the shutdown timeout.
+func (s *Supervisor) Shutdown() error {
+	if !s.stop(s.addrs()) {
+		return fmt.Errorf("supervisor: consumers did not flush within %v", s.cfg.ShutdownTimeout)
+	}
+
+	if s.statsd != nil {
+		if err := s.statsd.Close(); err != nil {
+			log.Printf("supervisor: failed to close statsd client: error=%v", err)
+		}
+	}
+
+	log.Printf("supervisor: shut down cleanly")
+
+	return nil
+}
+
+// clientOptions returns the options with which clients connect to servers.
+func clientOptions(cfg *Config) ([]client.Option, error) {
+	var opts []client.Option
+	if cfg.TLS {
+		log.Printf("supervisor: enabling TLS: ca=%s cert=%s", cfg.TLSCA, cfg.TLSCert)
+		tlsConfig, err := tlsconfig.NewClientConfig(&tlsconfig.ClientOptions{
+			CAFile:     cfg.TLSCA,
+			CertFile:   cfg.TLSCert,
+			KeyFile:    cfg.TLSKey,
+			ServerName: cfg.TLSServer,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, client.WithTLS(tlsConfig))
+	}
+
+	if cfg.TokenFile != "" {
+		token, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, client.WithToken(strings.TrimSpace(string(token))))
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,233 @@
+package supervisor
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zephyrus/internal/client"
+	"zephyrus/internal/collector"
+)
+
+// fakeStream is a stream that runs until its context is done, then takes stopDelay to stop.
+type fakeStream struct {
+	stopDelay time.Duration
+	// Settings applied to the stream, and whether it has stopped.
+	sampleRate float64
+	retry      collector.RetryPolicy
+	stopped    bool
+	// Mutex used to synchronize access to the settings and stopped state.
+	mutex sync.Mutex
+}
+
+func (s *fakeStream) Run(ctx context.Context) {
+	<-ctx.Done()
+	time.Sleep(s.stopDelay)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stopped = true
+}
+
+func (s *fakeStream) SetSampleRate(sampleRate float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sampleRate = sampleRate
+}
+
+func (s *fakeStream) SetRetryPolicy(policy collector.RetryPolicy) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.retry = policy
+
+	return nil
+}
+
+func (s *fakeStream) isStopped() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stopped
+}
+
+// streamTracker records the fake streams created for each server, in order.
+type streamTracker struct {
+	stopDelay time.Duration
+	streams   map[string][]*fakeStream
+}
+
+func (t *streamTracker) newStream(addr string, cfg *collector.StreamConfig, opts ...client.Option) (stream, error) {
+	s := &fakeStream{stopDelay: t.stopDelay, sampleRate: cfg.SampleRate, retry: cfg.Retry}
+	t.streams[addr] = append(t.streams[addr], s)
+
+	return s, nil
+}
+
+func newTestConfig(servers ...string) *Config {
+	return &Config{
+		Servers:         servers,
+		SampleRate:      1,
+		ShutdownTimeout: time.Second,
+		Retry:           collector.DefaultRetryPolicy,
+		SinkQueueSize:   10,
+		SinkErrorPolicy: collector.SinkErrorIgnore,
+	}
+}
+
+// newTestSupervisor creates a supervisor of fake streams taking stopDelay to stop, and starts
+// collecting from the configured servers.
+func newTestSupervisor(t *testing.T, cfg *Config, stopDelay time.Duration) (*Supervisor, *streamTracker) {
+	s, err := NewSupervisor(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := &streamTracker{stopDelay: stopDelay, streams: make(map[string][]*fakeStream)}
+	s.newStream = tracker.newStream
+
+	for _, addr := range cfg.Servers {
+		if err := s.Start(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return s, tracker
+}
+
+// running returns the sorted addresses of the servers with running collections.
+func running(s *Supervisor) string {
+	addrs := s.addrs()
+	sort.Strings(addrs)
+
+	return strings.Join(addrs, ",")
+}
+
+func TestSupervisorApplyServers(t *testing.T) {
+	tests := []struct {
+		name string
+		from []string
+		to   []string
+		// Servers whose collections are expected to be stopped, and started by the change.
+		stopped []string
+		started []string
+	}{
+		{name: "added", from: []string{"a"}, to: []string{"a", "b"}, started: []string{"b"}},
+		{name: "removed", from: []string{"a", "b"}, to: []string{"b"}, stopped: []string{"a"}},
+		{name: "replaced", from: []string{"a", "b"}, to: []string{"b", "c"}, stopped: []string{"a"}, started: []string{"c"}},
+		{name: "unchanged", from: []string{"a", "b"}, to: []string{"b", "a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, tracker := newTestSupervisor(t, newTestConfig(test.from...), 0)
+
+			s.Apply(newTestConfig(test.to...))
+
+			want := append([]string(nil), test.to...)
+			sort.Strings(want)
+
+			if got := running(s); got != strings.Join(want, ",") {
+				t.Errorf("got collections for %s, want %s", got, strings.Join(want, ","))
+			}
+
+			for _, addr := range test.stopped {
+				if !tracker.streams[addr][0].isStopped() {
+					t.Errorf("collection from %s was not stopped", addr)
+				}
+			}
+
+			for _, addr := range test.started {
+				if n := len(tracker.streams[addr]); n != 1 {
+					t.Errorf("got %d streams from %s, want 1", n, addr)
+				}
+			}
+
+			// Servers that remain listed are not restarted.
+			for _, addr := range test.to {
+				streams := tracker.streams[addr]
+				if len(streams) != 1 || streams[0].isStopped() {
+					t.Errorf("collection from %s was restarted or stopped", addr)
+				}
+			}
+
+			if err := s.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSupervisorApplyStreamSettings(t *testing.T) {
+	s, tracker := newTestSupervisor(t, newTestConfig("a", "b"), 0)
+	defer s.Shutdown()
+
+	cfg := newTestConfig("a", "b")
+	cfg.SampleRate = 0.5
+	cfg.Retry.MaxInterval = time.Minute
+
+	s.Apply(cfg)
+
+	// The sample rate and retry policy are applied to the running streams without restarting them.
+	for _, addr := range []string{"a", "b"} {
+		streams := tracker.streams[addr]
+		if len(streams) != 1 {
+			t.Fatalf("got %d streams from %s, want 1", len(streams), addr)
+		}
+
+		streams[0].mutex.Lock()
+		sampleRate, retry := streams[0].sampleRate, streams[0].retry
+		streams[0].mutex.Unlock()
+
+		if sampleRate != 0.5 || retry != cfg.Retry {
+			t.Errorf("got sample rate %f and retry policy %+v from %s, want 0.5 and %+v", sampleRate, retry, addr, cfg.Retry)
+		}
+	}
+}
+
+func TestSupervisorRestartStopsCollectionsInParallel(t *testing.T) {
+	servers := []string{"a", "b", "c", "d", "e"}
+	stopDelay := 200 * time.Millisecond
+
+	s, tracker := newTestSupervisor(t, newTestConfig(servers...), stopDelay)
+	defer s.Shutdown()
+
+	// Sink changes restart every collection.
+	cfg := newTestConfig(servers...)
+	cfg.SinkQueueSize = 20
+
+	start := time.Now()
+	s.Apply(cfg)
+
+	if elapsed := time.Since(start); elapsed > 2*stopDelay {
+		t.Errorf("restart took %v, want about %v", elapsed, stopDelay)
+	}
+
+	for _, addr := range servers {
+		streams := tracker.streams[addr]
+		if len(streams) != 2 || !streams[0].isStopped() || streams[1].isStopped() {
+			t.Errorf("collection from %s was not restarted", addr)
+		}
+	}
+}
+
+func TestSupervisorShutdownTimeout(t *testing.T) {
+	cfg := newTestConfig("a", "b")
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+
+	s, _ := newTestSupervisor(t, cfg, time.Second)
+
+	start := time.Now()
+	if err := s.Shutdown(); err == nil {
+		t.Error("shut down cleanly, want an error")
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("shutdown took %v, want about the shutdown timeout", elapsed)
+	}
+}
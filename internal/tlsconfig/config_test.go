@@ -0,0 +1,333 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA is a throwaway certificate authority.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// PEM encoding of the certificate.
+	pem []byte
+}
+
+// newTestCA generates a self-signed certificate authority.
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue generates a certificate and key signed by the CA, returning them PEM-encoded.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes a file in the directory, returning its path.
+func writeFile(t *testing.T, dir string, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// handshake performs a TLS handshake between a server using the configuration as wrapped by gRPC
+// and a client using the specified configuration, returning the client's connection state.
+func handshake(t *testing.T, server *tls.Config, client *tls.Config) (tls.ConnectionState, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	creds := credentials.NewTLS(server)
+	serverErrors := make(chan error, 1)
+
+	go func() {
+		rawConn, err := listener.Accept()
+		if err != nil {
+			serverErrors <- err
+			return
+		}
+
+		conn, _, err := creds.ServerHandshake(rawConn)
+		if err == nil {
+			// Wait for the client, whose handshake may complete before the server verifies its
+			// certificate.
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		} else {
+			rawConn.Close()
+		}
+
+		serverErrors <- err
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), client)
+	if err != nil {
+		<-serverErrors
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	conn.Write([]byte{0})
+
+	// The server's rejection of a client certificate is only observed when reading.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, readErr := conn.Read(make([]byte, 1))
+
+	if err := <-serverErrors; err != nil {
+		return conn.ConnectionState(), err
+	}
+
+	if ne, ok := readErr.(net.Error); ok && ne.Timeout() {
+		return conn.ConnectionState(), readErr
+	}
+
+	return conn.ConnectionState(), nil
+}
+
+// fixture is a set of certificate files for a server and its clients.
+type fixture struct {
+	dir string
+	ca  *testCA
+	// Paths of the CA certificate and the server certificate and key.
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestCA(t, "ca")
+	cert, key := ca.issue(t, "zephyrus", 2, x509.ExtKeyUsageServerAuth)
+
+	return &fixture{
+		dir:      dir,
+		ca:       ca,
+		caFile:   writeFile(t, dir, "ca.crt", ca.pem),
+		certFile: writeFile(t, dir, "server.crt", cert),
+		keyFile:  writeFile(t, dir, "server.key", key),
+	}
+}
+
+func (f *fixture) clientConfig(t *testing.T, opts ClientOptions) *tls.Config {
+	opts.CAFile = f.caFile
+	opts.ServerName = "zephyrus"
+
+	cfg, err := NewClientConfig(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.NextProtos = []string{"h2"}
+
+	return cfg
+}
+
+func TestServerConfigNegotiatesHTTP2(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+
+	server, err := NewServerConfig(&ServerOptions{CertFile: f.certFile, KeyFile: f.keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := handshake(t, server, f.clientConfig(t, ClientOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if state.NegotiatedProtocol != "h2" {
+		t.Errorf("got negotiated protocol %q, want h2", state.NegotiatedProtocol)
+	}
+}
+
+func TestServerConfigMutualTLS(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+
+	server, err := NewServerConfig(&ServerOptions{CertFile: f.certFile, KeyFile: f.keyFile, ClientCAFile: f.caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key := f.ca.issue(t, "collector", 3, x509.ExtKeyUsageClientAuth)
+	trusted := ClientOptions{
+		CertFile: writeFile(t, f.dir, "client.crt", cert),
+		KeyFile:  writeFile(t, f.dir, "client.key", key),
+	}
+
+	untrustedCert, untrustedKey := newTestCA(t, "other").issue(t, "collector", 4, x509.ExtKeyUsageClientAuth)
+	untrusted := ClientOptions{
+		CertFile: writeFile(t, f.dir, "untrusted.crt", untrustedCert),
+		KeyFile:  writeFile(t, f.dir, "untrusted.key", untrustedKey),
+	}
+
+	tests := []struct {
+		name    string
+		client  ClientOptions
+		succeed bool
+	}{
+		{name: "trusted client certificate", client: trusted, succeed: true},
+		{name: "no client certificate", client: ClientOptions{}},
+		{name: "client certificate from another CA", client: untrusted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state, err := handshake(t, server, f.clientConfig(t, test.client))
+			if test.succeed && err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+
+			if !test.succeed && err == nil {
+				t.Fatal("handshake succeeded, want failure")
+			}
+
+			if test.succeed && state.NegotiatedProtocol != "h2" {
+				t.Errorf("got negotiated protocol %q, want h2", state.NegotiatedProtocol)
+			}
+		})
+	}
+}
+
+func TestKeyPairReloader(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+
+	r, err := NewKeyPairReloader(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := r.Certificate().Certificate[0]
+
+	// Replace the key pair, with a later modification time, and skip the rate limit.
+	cert, key := f.ca.issue(t, "zephyrus", 5, x509.ExtKeyUsageServerAuth)
+	writeFile(t, f.dir, "server.crt", cert)
+	writeFile(t, f.dir, "server.key", key)
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(f.certFile, later, later)
+	os.Chtimes(f.keyFile, later, later)
+
+	r.files.lastCheck = time.Time{}
+
+	reloaded, err := x509.ParseCertificate(r.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.SerialNumber.Int64() != 5 {
+		t.Errorf("got serial %v after reload, want 5", reloaded.SerialNumber)
+	}
+
+	// A broken key pair is not loaded, and the previous one remains in use.
+	writeFile(t, f.dir, "server.key", []byte("invalid"))
+
+	later = later.Add(time.Minute)
+	os.Chtimes(f.keyFile, later, later)
+
+	r.files.lastCheck = time.Time{}
+
+	if current := r.Certificate().Certificate[0]; string(current) == string(original) {
+		t.Error("reverted to the original key pair after a failed reload")
+	} else if parsed, _ := x509.ParseCertificate(current); parsed.SerialNumber.Int64() != 5 {
+		t.Errorf("got serial %v after a failed reload, want 5", parsed.SerialNumber)
+	}
+}
+
+func TestCertPoolReloader(t *testing.T) {
+	f := newFixture(t)
+	defer os.RemoveAll(f.dir)
+
+	r, err := NewCertPoolReloader(f.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestCA(t, "other")
+	writeFile(t, f.dir, "ca.crt", other.pem)
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(f.caFile, later, later)
+
+	r.files.lastCheck = time.Time{}
+
+	if _, err := other.cert.Verify(x509.VerifyOptions{Roots: r.Pool()}); err != nil {
+		t.Errorf("reloaded pool does not contain the new CA: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval describes the minimum amount of time between consecutive checks of whether
+// watched files have changed on disk.
+const reloadCheckInterval = 5 * time.Second
+
+// watchedFiles tracks the modification times of a set of files to detect changes on disk.
+type watchedFiles struct {
+	paths    []string
+	modTimes []time.Time
+	// Time of the most recent change check.
+	lastCheck time.Time
+}
+
+// newWatchedFiles creates a watcher for the specified files, recording their current state.
+func newWatchedFiles(paths ...string) *watchedFiles {
+	w := &watchedFiles{paths: paths, modTimes: make([]time.Time, len(paths))}
+	w.changed()
+
+	return w
+}
+
+// changed reports whether any file has been modified since the last call that reported a change.
+// Checks are rate limited to once per reloadCheckInterval.
+func (w *watchedFiles) changed() bool {
+	now := time.Now()
+	if !w.lastCheck.IsZero() && now.Sub(w.lastCheck) < reloadCheckInterval {
+		return false
+	}
+
+	w.lastCheck = now
+	changed := false
+
+	for i, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(w.modTimes[i]) {
+			w.modTimes[i] = info.ModTime()
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// KeyPairReloader provides a certificate and key pair loaded from disk, transparently reloading it
+// when either file changes. It is safe for concurrent use.
+type KeyPairReloader struct {
+	certFile string
+	keyFile  string
+	// Currently loaded key pair.
+	cert *tls.Certificate
+	// Watcher detecting changes to the certificate and key files.
+	files *watchedFiles
+	// Mutex used to synchronize reloads.
+	mutex sync.Mutex
+}
+
+// NewKeyPairReloader loads a PEM-encoded certificate and key pair from disk.
+func NewKeyPairReloader(certFile string, keyFile string) (*KeyPairReloader, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: %v", err)
+	}
+
+	return &KeyPairReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		cert:     &cert,
+		files:    newWatchedFiles(certFile, keyFile),
+	}, nil
+}
+
+// Certificate returns the current key pair, reloading it first if the files have changed. If the
+// reload fails, the previously loaded key pair continues to be used.
+func (r *KeyPairReloader) Certificate() *tls.Certificate {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.files.changed() {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			log.Printf("tlsconfig: failed to reload key pair: cert=%s error=%v", r.certFile, err)
+		} else {
+			log.Printf("tlsconfig: reloaded key pair: cert=%s", r.certFile)
+			r.cert = &cert
+		}
+	}
+
+	return r.cert
+}
+
+// CertPoolReloader provides a pool of PEM-encoded CA certificates loaded from disk, transparently
+// reloading it when the file changes. It is safe for concurrent use.
+type CertPoolReloader struct {
+	caFile string
+	// Currently loaded certificate pool.
+	pool *x509.CertPool
+	// Watcher detecting changes to the CA file.
+	files *watchedFiles
+	// Mutex used to synchronize reloads.
+	mutex sync.Mutex
+}
+
+// NewCertPoolReloader loads a pool of PEM-encoded CA certificates from disk.
+func NewCertPoolReloader(caFile string) (*CertPoolReloader, error) {
+	pool, err := loadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertPoolReloader{
+		caFile: caFile,
+		pool:   pool,
+		files:  newWatchedFiles(caFile),
+	}, nil
+}
+
+// Pool returns the current certificate pool, reloading it first if the file has changed. If the
+// reload fails, the previously loaded pool continues to be used.
+func (r *CertPoolReloader) Pool() *x509.CertPool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.files.changed() {
+		pool, err := loadCertPool(r.caFile)
+		if err != nil {
+			log.Printf("tlsconfig: failed to reload CA certificates: ca=%s error=%v", r.caFile, err)
+		} else {
+			log.Printf("tlsconfig: reloaded CA certificates: ca=%s", r.caFile)
+			r.pool = pool
+		}
+	}
+
+	return r.pool
+}
+
+// loadCertPool reads a file of PEM-encoded certificates into a new pool.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("tlsconfig: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("tlsconfig: no valid certificates found in %s", caFile)
+	}
+
+	return pool, nil
+}